@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/importers"
+	"github.com/arimatakao/deepenc/server/vault"
+)
+
+// VAULT_PASSPHRASE_ENV keeps the passphrase out of shell history and process
+// list.
+const VAULT_PASSPHRASE_ENV = "DEEPENC_VAULT_PASSPHRASE"
+
+// IMPORT_PASSWORD_ENV is the message password for password and aes
+// encodings of imported messages.
+const IMPORT_PASSWORD_ENV = "DEEPENC_IMPORT_PASSWORD"
+
+// runCommand runs maintenance command given after flags instead of the
+// server, e.g. deepenc -config config.yaml vault-import -user bob -in vault.zip
+func runCommand(args []string) error {
+	switch args[0] {
+	case "vault-export":
+		return vaultExport(args[1:])
+	case "vault-import":
+		return vaultImport(args[1:])
+	case "import":
+		return importFile(args[1:])
+	case "audit-verify":
+		return auditVerify()
+	case "promote":
+		return promote(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, use vault-export, vault-import, import, "+
+			"audit-verify or promote", args[0])
+	}
+}
+
+func openUserDB(username string) (*database.MainDB, database.UserOut, error) {
+	db, err := database.NewMainDB(config.MongoURL)
+	if err != nil {
+		return nil, database.UserOut{}, err
+	}
+
+	u, err := db.GetUser(username)
+	if err != nil {
+		db.Shutdown(context.Background())
+		return nil, database.UserOut{}, fmt.Errorf("can't find user %q: %w", username, err)
+	}
+
+	return db, u, nil
+}
+
+func vaultPassphrase() (string, error) {
+	passphrase := os.Getenv(VAULT_PASSPHRASE_ENV)
+	if passphrase == "" {
+		return "", errors.New(VAULT_PASSPHRASE_ENV + " environment variable is empty")
+	}
+	return passphrase, nil
+}
+
+func vaultExport(args []string) error {
+	flags := flag.NewFlagSet("vault-export", flag.ExitOnError)
+	username := flags.String("user", "", "username whose messages are exported")
+	out := flags.String("out", "", "path to the archive")
+	flags.Parse(args)
+
+	if *username == "" || *out == "" {
+		return errors.New("-user and -out are required")
+	}
+
+	passphrase, err := vaultPassphrase()
+	if err != nil {
+		return err
+	}
+
+	db, u, err := openUserDB(*username)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown(context.Background())
+
+	entries, err := server.VaultEntries(db, u.Id.Hex())
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err = vault.Write(buf, passphrase, entries); err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(*out, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d messages to %s\n", len(entries), *out)
+	return nil
+}
+
+func vaultImport(args []string) error {
+	flags := flag.NewFlagSet("vault-import", flag.ExitOnError)
+	username := flags.String("user", "", "username who receives imported messages")
+	in := flags.String("in", "", "path to the archive")
+	conflict := flags.String("conflict", server.VAULT_CONFLICT_NEW,
+		"what to do with taken message ids: new, skip or overwrite")
+	flags.Parse(args)
+
+	if *username == "" || *in == "" {
+		return errors.New("-user and -in are required")
+	}
+
+	passphrase, err := vaultPassphrase()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+
+	entries, err := vault.Read(bytes.NewReader(data), int64(len(data)), passphrase)
+	if err != nil {
+		return err
+	}
+
+	db, u, err := openUserDB(*username)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown(context.Background())
+
+	start := time.Now()
+	results, err := server.ImportVaultEntries(db, u.Id.Hex(), entries, *conflict)
+	for _, r := range results {
+		fmt.Printf("%s -> %s %s\n", r.SourceId, r.Id, r.Status)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("processed %d of %d messages in %s\n", len(results), len(entries),
+		time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func importFile(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	username := flags.String("user", "", "username who receives imported messages")
+	in := flags.String("in", "", "path to the exported file")
+	format := flags.String("format", "", "format of the file: keepass, bitwarden, env or csv")
+	encoding := flags.String("encoding", "internal", "encoding_type of created messages")
+	collection := flags.String("collection", "", "id of collection for created messages")
+	dryRun := flags.Bool("dry-run", false, "only report what would be imported")
+	flags.Parse(args)
+
+	if *username == "" || *in == "" || *format == "" {
+		return errors.New("-user, -in and -format are required")
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := importers.Parse(*format, f)
+	if err != nil {
+		return err
+	}
+
+	db, u, err := openUserDB(*username)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown(context.Background())
+
+	results, err := server.ImportRecords(db, u.Id.Hex(), records, server.ImportOptions{
+		EncodingType: *encoding,
+		Password:     os.Getenv(IMPORT_PASSWORD_ENV),
+		CollectionId: *collection,
+		DryRun:       *dryRun,
+	})
+
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Printf("%d\t%s\t%s\t%s%s\n", r.Record, r.Status, r.Title, r.Id, r.Reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("records: %d, created: %d, valid: %d, invalid: %d\n", len(records),
+		counts[server.IMPORT_STATUS_CREATED], counts[server.IMPORT_STATUS_VALID],
+		counts[server.IMPORT_STATUS_INVALID])
+	return nil
+}
+
+// auditVerify checks hash chain of the audit log and fails when the log is
+// changed.
+func auditVerify() error {
+	auditor, err := server.NewAuditor()
+	if err != nil {
+		return err
+	}
+	defer auditor.Close()
+
+	result, err := auditor.Verify()
+	if err != nil {
+		return err
+	}
+
+	if !result.Valid {
+		return fmt.Errorf("audit log is broken at event %d: %s, %d events are intact",
+			result.BrokenSeq, result.Reason, result.Checked)
+	}
+
+	if result.Head == nil {
+		fmt.Println("audit log is empty")
+		return nil
+	}
+
+	fmt.Printf("audit log is intact, %d events checked, head is event %d with hash %s\n",
+		result.Checked, result.Head.Seq, result.Head.Hash)
+	return nil
+}
+
+// promote grants admin role to the existing account. Admins are granted only
+// by operator, never by sign in.
+func promote(args []string) error {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	username := flags.String("user", "", "username who gets admin role")
+	flags.Parse(args)
+
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+
+	db, u, err := openUserDB(*username)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown(context.Background())
+
+	if err = db.SetUserRole(u.Id.Hex(), server.ROLE_ADMIN); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s) is admin now, the role is applied on the next sign in\n",
+		u.Username, u.Id.Hex())
+	return nil
+}
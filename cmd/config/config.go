@@ -2,26 +2,118 @@ package config
 
 import (
 	"errors"
+	"log"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	defaultLoginMaxAttempts     = 5
+	defaultLoginLockoutMinutes  = 15
+	defaultVerificationMinutes  = 60
+	defaultPasswordResetMinutes = 30
+	defaultSMTPPort             = 587
+	defaultDeletionGraceHours   = 72
+	defaultRevisionsRetention   = 10
+	defaultAuditSink            = "mongo"
+)
+
 var (
-	Port      string
-	MongoURL  string
-	RedisURL  string
-	JWTSecret string
-	AESInternalKey []byte
+	Port             string
+	MongoURL         string
+	RedisURL         string
+	JWTSecret        string
+	AESInternalKey   []byte
+	TrustedProxies   []*net.IPNet
+	LoginMaxAttempts int64
+	LoginLockoutTime time.Duration
+	BaseURL          string
+	VerificationTTL  time.Duration
+	PasswordResetTTL time.Duration
+	DeletionGrace    time.Duration
+
+	RevisionsRetention int
+
+	InsecureWebhooks bool
+
+	JWTAlgorithm        string
+	JWTSigningKey       JWTKey
+	JWTVerificationKeys []JWTKey
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	OIDCIssuer       string
+	OIDCClientId     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+
+	AuditSink     string
+	AuditFile     string
+	AuditKey      []byte
+	AuditHeadFile string
 )
 
+// JWTKey is a PEM file with a key used for JWT signing or verification.
+// Kid is put into the token header and JWKS to pick the key.
+type JWTKey struct {
+	Kid  string `yaml:"kid"`
+	File string `yaml:"file"`
+}
+
+type smtpCfg struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+type oidcCfg struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientId     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+type auditCfg struct {
+	Sink     string `yaml:"sink"`
+	File     string `yaml:"file"`
+	Key      string `yaml:"key"`
+	HeadFile string `yaml:"head_file"`
+}
+
 type cfg struct {
-	Port       int    `yaml:"port"`
-	MongoDBURL string `yaml:"mongodb_url"`
-	RedisURL   string `yaml:"redis_url"`
-	JWTSecret  string `yaml:"jwt_secret"`
-	AESInternalKey string `yaml:"aes_internal_key"`
+	Port                 int      `yaml:"port"`
+	MongoDBURL           string   `yaml:"mongodb_url"`
+	RedisURL             string   `yaml:"redis_url"`
+	JWTSecret            string   `yaml:"jwt_secret"`
+	JWTAlgorithm         string   `yaml:"jwt_algorithm"`
+	JWTSigningKey        JWTKey   `yaml:"jwt_signing_key"`
+	JWTVerificationKeys  []JWTKey `yaml:"jwt_verification_keys"`
+	AESInternalKey       string   `yaml:"aes_internal_key"`
+	TrustedProxies       []string `yaml:"trusted_proxies"`
+	LoginMaxAttempts     int      `yaml:"login_max_attempts"`
+	LoginLockoutMinutes  int      `yaml:"login_lockout_minutes"`
+	BaseURL              string   `yaml:"base_url"`
+	VerificationMinutes  int      `yaml:"verification_ttl_minutes"`
+	PasswordResetMinutes int      `yaml:"password_reset_ttl_minutes"`
+	DeletionGraceHours   int      `yaml:"account_deletion_grace_hours"`
+	RevisionsRetention   int      `yaml:"message_revisions_retention"`
+	InsecureWebhooks     bool     `yaml:"insecure_webhooks"`
+	SMTP                 smtpCfg  `yaml:"smtp"`
+	OIDC                 oidcCfg  `yaml:"oidc"`
+	Audit                auditCfg `yaml:"audit"`
 }
 
 func LoadConfig(pathToYaml string) error {
@@ -39,19 +131,145 @@ func LoadConfig(pathToYaml string) error {
 		return errors.New("port value from config is not allowed")
 	}
 
-	if c.JWTSecret == "" {
-		return errors.New("jwt_secret field from config is empty")
+	switch c.JWTAlgorithm {
+	case "", "HS256":
+		c.JWTAlgorithm = "HS256"
+		if c.JWTSecret == "" {
+			return errors.New("jwt_secret field from config is empty")
+		}
+	case "RS256", "EdDSA":
+		if c.JWTSigningKey.File == "" || c.JWTSigningKey.Kid == "" {
+			return errors.New("jwt_signing_key kid and file are required for " + c.JWTAlgorithm)
+		}
+		for _, k := range c.JWTVerificationKeys {
+			if k.File == "" || k.Kid == "" {
+				return errors.New("jwt_verification_keys kid and file are required")
+			}
+		}
+	default:
+		return errors.New("jwt_algorithm should be HS256, RS256 or EdDSA")
 	}
 
 	if len(c.AESInternalKey) < 8 {
 		return errors.New("aes_internal_key field is shorter than 8 symbols in config")
 	}
 
+	if c.LoginMaxAttempts < 0 || c.LoginLockoutMinutes < 0 {
+		return errors.New("login_max_attempts and login_lockout_minutes can't be negative")
+	}
+	if c.LoginMaxAttempts == 0 {
+		c.LoginMaxAttempts = defaultLoginMaxAttempts
+	}
+	if c.LoginLockoutMinutes == 0 {
+		c.LoginLockoutMinutes = defaultLoginLockoutMinutes
+	}
+
+	if c.VerificationMinutes < 0 {
+		return errors.New("verification_ttl_minutes can't be negative")
+	}
+	if c.VerificationMinutes == 0 {
+		c.VerificationMinutes = defaultVerificationMinutes
+	}
+
+	if c.PasswordResetMinutes < 0 {
+		return errors.New("password_reset_ttl_minutes can't be negative")
+	}
+	if c.PasswordResetMinutes == 0 {
+		c.PasswordResetMinutes = defaultPasswordResetMinutes
+	}
+
+	if c.DeletionGraceHours < 0 {
+		return errors.New("account_deletion_grace_hours can't be negative")
+	}
+	if c.DeletionGraceHours == 0 {
+		c.DeletionGraceHours = defaultDeletionGraceHours
+	}
+
+	if c.RevisionsRetention < 0 {
+		return errors.New("message_revisions_retention can't be negative")
+	}
+	if c.RevisionsRetention == 0 {
+		c.RevisionsRetention = defaultRevisionsRetention
+	}
+
+	if c.BaseURL == "" {
+		c.BaseURL = "http://localhost:" + strconv.Itoa(c.Port)
+	}
+
+	if c.SMTP.Port == 0 {
+		c.SMTP.Port = defaultSMTPPort
+	}
+
+	if c.OIDC.Issuer != "" && c.OIDC.ClientId == "" {
+		return errors.New("oidc client_id is required when oidc issuer is set")
+	}
+	if c.OIDC.Issuer != "" && c.OIDC.RedirectURL == "" {
+		c.OIDC.RedirectURL = strings.TrimSuffix(c.BaseURL, "/") + "/api/oidc/callback"
+	}
+
+	switch c.Audit.Sink {
+	case "":
+		c.Audit.Sink = defaultAuditSink
+	case "mongo":
+	case "file":
+		if c.Audit.File == "" {
+			return errors.New("audit file is required for file sink")
+		}
+	default:
+		return errors.New("audit sink should be mongo or file")
+	}
+	// Audit key was added after the first releases, configs without it are
+	// still loaded, but hashes can be recomputed by anyone with database
+	// access then.
+	if c.Audit.Key == "" {
+		log.Println("WARNING: audit key is not set in config, audit log is not protected from forged events")
+	} else if len(c.Audit.Key) < 16 {
+		return errors.New("audit key is shorter than 16 symbols in config")
+	}
+
+	trustedProxies := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, cidr := range c.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.New("trusted_proxies should be CIDR ranges, like 10.0.0.0/8")
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+
 	Port = strconv.Itoa(c.Port)
 	MongoURL = c.MongoDBURL
 	RedisURL = c.RedisURL
 	JWTSecret = c.JWTSecret
+	JWTAlgorithm = c.JWTAlgorithm
+	JWTSigningKey = c.JWTSigningKey
+	JWTVerificationKeys = c.JWTVerificationKeys
 	AESInternalKey = []byte(c.AESInternalKey)
+	TrustedProxies = trustedProxies
+	LoginMaxAttempts = int64(c.LoginMaxAttempts)
+	LoginLockoutTime = time.Duration(c.LoginLockoutMinutes) * time.Minute
+	BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	VerificationTTL = time.Duration(c.VerificationMinutes) * time.Minute
+	PasswordResetTTL = time.Duration(c.PasswordResetMinutes) * time.Minute
+	DeletionGrace = time.Duration(c.DeletionGraceHours) * time.Hour
+	RevisionsRetention = c.RevisionsRetention
+	InsecureWebhooks = c.InsecureWebhooks
+
+	SMTPHost = c.SMTP.Host
+	SMTPPort = c.SMTP.Port
+	SMTPUsername = c.SMTP.Username
+	SMTPPassword = c.SMTP.Password
+	SMTPFrom = c.SMTP.From
+
+	OIDCIssuer = c.OIDC.Issuer
+	OIDCClientId = c.OIDC.ClientId
+	OIDCClientSecret = c.OIDC.ClientSecret
+	OIDCRedirectURL = c.OIDC.RedirectURL
+	OIDCScopes = c.OIDC.Scopes
+
+	AuditSink = c.Audit.Sink
+	AuditFile = c.Audit.File
+	AuditKey = []byte(c.Audit.Key)
+	AuditHeadFile = c.Audit.HeadFile
 
 	return nil
 }
@@ -25,6 +25,13 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	srv := new(server.Server)
 	err = srv.Init()
 	if err != nil {
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	ACCESS_OUTCOME_READ           = "read"
+	ACCESS_OUTCOME_WRONG_PASSWORD = "wrong_password"
+	ACCESS_OUTCOME_DENIED         = "denied"
+
+	// Only first failures from the same IP are recorded, the rest in the
+	// window are dropped.
+	MAX_RECORDED_ACCESS_FAILURES = 5
+	ACCESS_FAILURES_WINDOW       = 10 * time.Minute
+)
+
+// logAccess records attempt to read the message in the audit log and, when
+// the reader is other user, in the access log of the owner. Successful reads
+// are counted as read receipts. Reads and wrong passwords are sent to
+// webhooks of the owner. Errors are only logged, the reader gets the message
+// anyway.
+//
+// Anonymous denials are not recorded in the audit log, anyone can request
+// them without limit and every audit event is appended one by one. Other
+// failures are recorded up to MAX_RECORDED_ACCESS_FAILURES per message and
+// IP in ACCESS_FAILURES_WINDOW.
+func (s *Server) logAccess(c echo.Context, msg database.MessageOut, outcome string) {
+	sub := subjectFromContext(c)
+
+	if outcome != ACCESS_OUTCOME_READ && !s.recordAccessFailure(c, msg) {
+		return
+	}
+
+	switch {
+	case outcome == ACCESS_OUTCOME_READ:
+		s.recordAudit(c, messageEvent(AUDIT_MESSAGE_READ, msg))
+	case outcome != ACCESS_OUTCOME_DENIED || !sub.isAnonymous():
+		s.auditFailure(c, messageEvent(AUDIT_MESSAGE_READ, msg), outcome)
+	}
+
+	if sub.owns(msg) {
+		return
+	}
+
+	now := time.Now().UTC()
+	err := s.db.AddAccessLogEntry(&database.AccessLogEntry{
+		MessageId: msg.Id.Hex(),
+		PublicId:  msg.PublicId,
+		OwnerId:   msg.OwnerId,
+		At:        now,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		Outcome:   outcome,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+	}
+
+	events := []string{}
+	switch {
+	case outcome == ACCESS_OUTCOME_WRONG_PASSWORD:
+		events = append(events, WEBHOOK_EVENT_DECRYPT_FAILED)
+	case outcome == ACCESS_OUTCOME_READ && msg.IsOneTime:
+		events = append(events, WEBHOOK_EVENT_READ, WEBHOOK_EVENT_BURNED)
+	case outcome == ACCESS_OUTCOME_READ:
+		events = append(events, WEBHOOK_EVENT_READ)
+		if err = s.db.CountMessageRead(msg.Id.Hex(), now); err != nil {
+			c.Logger().Error(err)
+		}
+	}
+
+	for _, event := range events {
+		if err = s.emitMessageEvent(event, msg); err != nil {
+			c.Logger().Error(err)
+		}
+	}
+}
+
+// recordAccessFailure counts the failure and reports whether it must be
+// recorded. The failure is recorded when the counter is not available.
+func (s *Server) recordAccessFailure(c echo.Context, msg database.MessageOut) bool {
+	failures, err := s.cachedb.AddAccessFailure(msg.Id.Hex(), c.RealIP(),
+		ACCESS_FAILURES_WINDOW)
+	if err != nil {
+		c.Logger().Error(err)
+		return true
+	}
+	return failures <= MAX_RECORDED_ACCESS_FAILURES
+}
+
+// GetMessageAccessLog returns who and when tried to read the message. Log of
+// deleted messages, like consumed one-time messages, is available too.
+func (s *Server) GetMessageAccessLog(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	msgId := c.Param("id")
+	if msgId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	msg, err := s.loadMessage(msgId)
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err == nil {
+		if err = authorize(subjectFromContext(c), actionOwnerRead, msg); err != nil {
+			return c.String(authorizationStatus(err), "")
+		}
+		msgId = msg.Id.Hex()
+	}
+
+	skip, limit := parsePagination(c)
+
+	entries, total, err := s.db.GetAccessLog(userId, msgId, skip, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if total == 0 && msg.Id.IsZero() {
+		return c.String(http.StatusNotFound, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total":   total,
+		"entries": entries,
+	})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func privateMessageRequest(s *Server, publicId, password, ip string) *httptest.ResponseRecorder {
+	body := `{"password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = ip + ":52000"
+	rec := httptest.NewRecorder()
+
+	c := s.e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(publicId)
+	s.GetPrivateMessage(c)
+	return rec
+}
+
+// countEvents returns how many events of the type were published.
+func countEvents(cache *fakeCacher, eventType string) int {
+	count := 0
+	for _, e := range cache.events {
+		var payload struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal([]byte(e.Payload), &payload) == nil && payload.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+type TestCaseAccessLog struct {
+	Name                  string
+	Password              string
+	IP                    string
+	ExpectedStatus        int
+	ExpectedEntries       int
+	ExpectedDecryptFailed int
+}
+
+func TestGetPrivateMessageAccessLog(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	msg := database.MessageOut{
+		Id:           primitive.NewObjectID(),
+		PublicId:     "public",
+		OwnerId:      primitive.NewObjectID().Hex(),
+		Content:      "secret",
+		IsPrivate:    true,
+		EncodingType: "password",
+		Password:     string(hashed),
+	}
+	db := newFakeStorager()
+	db.addMessages(msg)
+	cache := newFakeCacher()
+	s, _ := newTestServer(t, db, cache)
+
+	// Requests are made one after another, expected counts are totals.
+	cases := []TestCaseAccessLog{}
+	for i := 1; i <= MAX_RECORDED_ACCESS_FAILURES; i++ {
+		cases = append(cases, TestCaseAccessLog{"recorded failure", "wrong password",
+			"203.0.113.7", http.StatusNotFound, i, i})
+	}
+	cases = append(cases, []TestCaseAccessLog{
+		{"dropped failure", "wrong password", "203.0.113.7",
+			http.StatusNotFound, MAX_RECORDED_ACCESS_FAILURES, MAX_RECORDED_ACCESS_FAILURES},
+		{"failure from other ip", "wrong password", "198.51.100.1",
+			http.StatusNotFound, MAX_RECORDED_ACCESS_FAILURES + 1, MAX_RECORDED_ACCESS_FAILURES + 1},
+		{"read after failures", "right password", "203.0.113.7",
+			http.StatusOK, MAX_RECORDED_ACCESS_FAILURES + 2, MAX_RECORDED_ACCESS_FAILURES + 1},
+	}...)
+
+	for _, tc := range cases {
+		rec := privateMessageRequest(s, msg.PublicId, tc.Password, tc.IP)
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, tc.Name)
+		assert.Len(t, db.access, tc.ExpectedEntries, tc.Name)
+		assert.Equal(t, tc.ExpectedDecryptFailed,
+			countEvents(cache, WEBHOOK_EVENT_DECRYPT_FAILED), tc.Name)
+	}
+
+	last := db.access[len(db.access)-1]
+	assert.Equal(t, ACCESS_OUTCOME_READ, last.Outcome)
+	assert.Equal(t, msg.OwnerId, last.OwnerId)
+	assert.Equal(t, "203.0.113.7", last.IP)
+	assert.Equal(t, int64(1), db.messages[msg.Id.Hex()].ReadCount)
+}
+
+func TestGetPrivateMessageOwnerIsNotLogged(t *testing.T) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	ownerId := primitive.NewObjectID().Hex()
+	msg := database.MessageOut{
+		Id:           primitive.NewObjectID(),
+		PublicId:     "public",
+		OwnerId:      ownerId,
+		Content:      "secret",
+		IsPrivate:    true,
+		EncodingType: "password",
+		Password:     string(hashed),
+	}
+	db := newFakeStorager()
+	db.addMessages(msg)
+	cache := newFakeCacher()
+	s, _ := newTestServer(t, db, cache)
+
+	body := `{"password":"right password"}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := s.e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(msg.PublicId)
+	authenticate(c, ownerId, "")
+
+	s.GetPrivateMessage(c)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, db.access)
+	assert.Equal(t, int64(0), db.messages[msg.Id.Hex()].ReadCount)
+}
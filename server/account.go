@@ -0,0 +1,176 @@
+package server
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type accountProfile struct {
+	Id                  string             `json:"id"`
+	Username            string             `json:"username"`
+	Email               string             `json:"email"`
+	Role                string             `json:"role"`
+	Disabled            bool               `json:"disabled"`
+	LastLogin           database.LoginInfo `json:"last_login"`
+	TOTPEnabled         bool               `json:"totp_enabled"`
+	DeletionScheduledAt *time.Time         `json:"deletion_scheduled_at,omitempty"`
+}
+
+func toAccountProfile(u database.UserOut) accountProfile {
+	return accountProfile{
+		Id:                  u.Id.Hex(),
+		Username:            u.Username,
+		Email:               u.Email,
+		Role:                userRole(u),
+		Disabled:            u.Disabled,
+		LastLogin:           u.LastLogin,
+		TOTPEnabled:         u.TOTPEnabled,
+		DeletionScheduledAt: u.DeletionScheduledAt,
+	}
+}
+
+func (s *Server) DeleteAccount(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputPassword)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	// Accounts created by single sign-on have no local password.
+	if u.Password != "" {
+		err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(input.Password))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, resp("password is wrong"))
+		}
+	}
+
+	deleteAt := time.Now().UTC().Add(config.DeletionGrace)
+	if err = s.db.ScheduleUserDeletion(userId, deleteAt); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	text := fmt.Sprintf("your account and all messages will be deleted at %s. "+
+		"Use POST /api/account/restore before that to keep the account.",
+		deleteAt.Format(time.RFC1123))
+	if err = s.notifier.Notify(u, "account deletion scheduled", text); err != nil {
+		c.Logger().Warn(err)
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]time.Time{
+		"deletion_scheduled_at": deleteAt,
+	})
+}
+
+func (s *Server) RestoreAccount(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if err = s.db.CancelUserDeletion(userId); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+// ExportAccount returns zip archive with the user profile and all owned
+// messages. Encrypted contents are exported as they are stored.
+func (s *Server) ExportAccount(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	messages, err := s.db.GetUserMessages(userId, database.MessageFilter{})
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	for i := range messages {
+		messages[i].Password = ""
+		if err = openTitle(&messages[i]); err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+	}
+
+	colls, err := s.db.GetCollections(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	s.recordAudit(c, exportEvent(userId, "archive", len(messages)))
+
+	filename := fmt.Sprintf("deepenc-export-%s-%s.zip", u.Username,
+		time.Now().UTC().Format("20060102"))
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	if err = writeZipJSON(archive, "profile.json", toAccountProfile(u)); err != nil {
+		c.Logger().Error(err)
+		return nil
+	}
+	if err = writeZipJSON(archive, "messages.json", messages); err != nil {
+		c.Logger().Error(err)
+		return nil
+	}
+	if err = writeZipJSON(archive, "collections.json", colls); err != nil {
+		c.Logger().Error(err)
+		return nil
+	}
+	if err = archive.Close(); err != nil {
+		c.Logger().Error(err)
+	}
+
+	return nil
+}
+
+func writeZipJSON(archive *zip.Writer, name string, v interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
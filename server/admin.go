@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	DEFAULT_PAGE_LIMIT = 50
+	MAX_PAGE_LIMIT     = 100
+)
+
+type InputRole struct {
+	Role string `json:"role"`
+}
+
+// parsePagination reads page (starting from 1) and limit query params.
+func parsePagination(c echo.Context) (skip, limit int) {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err = strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit < 1 {
+		limit = DEFAULT_PAGE_LIMIT
+	}
+	if limit > MAX_PAGE_LIMIT {
+		limit = MAX_PAGE_LIMIT
+	}
+
+	return (page - 1) * limit, limit
+}
+
+func (s *Server) AdminGetUsers(c echo.Context) error {
+	skip, limit := parsePagination(c)
+
+	users, total, err := s.db.GetUsers(skip, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	profiles := make([]accountProfile, 0, len(users))
+	for _, u := range users {
+		profiles = append(profiles, toAccountProfile(u))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total": total,
+		"users": profiles,
+	})
+}
+
+func (s *Server) setUserDisabled(c echo.Context, disabled bool) error {
+	adminId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	userId := c.Param("id")
+	if userId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if userId == adminId {
+		return c.JSON(http.StatusBadRequest, resp("admin can't disable own account"))
+	}
+
+	err = s.db.SetUserDisabled(userId, disabled)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if disabled {
+		if err = s.cachedb.RevokeTokens(userId, time.Now()); err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		s.recordAudit(c, tokensRevokedEvent(userId, "account disabled"))
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+func (s *Server) AdminDisableUser(c echo.Context) error {
+	return s.setUserDisabled(c, true)
+}
+
+func (s *Server) AdminEnableUser(c echo.Context) error {
+	return s.setUserDisabled(c, false)
+}
+
+func (s *Server) AdminSetUserRole(c echo.Context) error {
+	adminId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	userId := c.Param("id")
+	if userId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputRole)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !roles[input.Role] {
+		return c.JSON(http.StatusBadRequest, resp("unknown role"))
+	}
+
+	if userId == adminId {
+		return c.JSON(http.StatusBadRequest, resp("admin can't change own role"))
+	}
+
+	err = s.db.SetUserRole(userId, input.Role)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	// Role is stored in issued tokens, so they have to be reissued.
+	if err = s.cachedb.RevokeTokens(userId, time.Now()); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	s.recordAudit(c, tokensRevokedEvent(userId, "role changed"))
+
+	return c.String(http.StatusNoContent, "")
+}
+
+func (s *Server) AdminDeleteMessage(c echo.Context) error {
+	msgId := c.Param("id")
+	if msgId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	msg, err := s.db.GetMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = authorize(subjectFromContext(c), actionDelete, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	if err = s.db.DeleteMessage(msg.Id.Hex()); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	c.Logger().Info("admin deleted message: " + msgId)
+	s.recordAudit(c, messageEvent(AUDIT_MESSAGE_DELETE, msg))
+
+	if err = s.emitMessageEvent(WEBHOOK_EVENT_DELETED, msg); err != nil {
+		c.Logger().Error(err)
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+func (s *Server) AdminGetStats(c echo.Context) error {
+	stats, err := s.db.GetStats()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
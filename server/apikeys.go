@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	API_KEY_PREFIX      = "dpk_"
+	API_KEY_HEADER      = "X-API-Key"
+	API_KEY_SCOPES_KEY  = "api_key_scopes"
+	MAX_API_KEY_NAME    = 64
+	MAX_API_KEYS_AMOUNT = 20
+
+	SCOPE_MESSAGES_READ  = "messages:read"
+	SCOPE_MESSAGES_WRITE = "messages:write"
+)
+
+var apiKeyScopes = map[string]bool{
+	SCOPE_MESSAGES_READ:  true,
+	SCOPE_MESSAGES_WRITE: true,
+}
+
+type InputAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// hashToken hashes random high-entropy tokens like API keys and share link
+// tokens, slow hashing is not needed for them.
+func hashToken(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+// apiKeyFromRequest returns API key passed either in X-API-Key header or as
+// a bearer token with the API key prefix.
+func apiKeyFromRequest(c echo.Context) string {
+	if key := c.Request().Header.Get(API_KEY_HEADER); key != "" {
+		return key
+	}
+
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if ok && strings.HasPrefix(token, API_KEY_PREFIX) {
+		return token
+	}
+
+	return ""
+}
+
+func isAPIKeyAuthenticated(c echo.Context) bool {
+	return c.Get(API_KEY_SCOPES_KEY) != nil
+}
+
+// authenticateAPIKey checks API key from the request and puts the key owner
+// into the context the same way as echojwt does, so handlers don't care
+// which credential was used. Requests without API key are passed to the
+// next middleware untouched.
+func (s *Server) authenticateAPIKey(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := apiKeyFromRequest(c)
+		if key == "" {
+			return next(c)
+		}
+
+		k, err := s.db.GetAPIKey(hashToken(key))
+		if err == mongo.ErrNoDocuments {
+			return c.String(http.StatusUnauthorized, "")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+
+		owner, err := s.db.GetUserById(k.OwnerId)
+		if err == mongo.ErrNoDocuments {
+			return c.String(http.StatusUnauthorized, "")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+
+		if owner.Disabled {
+			return c.JSON(http.StatusForbidden, resp(ACCOUNT_DISABLED_TEXT))
+		}
+
+		if err = s.db.TouchAPIKey(k.Id.Hex(), time.Now().UTC()); err != nil {
+			c.Logger().Warn(err)
+		}
+
+		// API keys never carry admin role, admin routes need a user token.
+		c.Set("user", &jwt.Token{
+			Claims: &jwtCustomClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					ID: k.OwnerId,
+				},
+				Role: ROLE_USER,
+			},
+			Valid: true,
+		})
+		c.Set(API_KEY_SCOPES_KEY, k.Scopes)
+
+		return next(c)
+	}
+}
+
+// requireScope restricts route for API keys without the scope. Requests
+// authenticated by JWT have every scope.
+func requireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !isAPIKeyAuthenticated(c) {
+				return next(c)
+			}
+
+			scopes, _ := c.Get(API_KEY_SCOPES_KEY).([]string)
+			for _, v := range scopes {
+				if v == scope {
+					return next(c)
+				}
+			}
+
+			return c.JSON(http.StatusForbidden, resp("api key has no "+scope+" scope"))
+		}
+	}
+}
+
+func (s *Server) CreateAPIKey(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputAPIKey)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if input.Name == "" || len(input.Name) > MAX_API_KEY_NAME || len(input.Scopes) == 0 {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	for _, scope := range input.Scopes {
+		if !apiKeyScopes[scope] {
+			return c.JSON(http.StatusBadRequest, resp("unknown scope "+scope))
+		}
+	}
+
+	keys, err := s.db.GetUserAPIKeys(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if len(keys) >= MAX_API_KEYS_AMOUNT {
+		return c.JSON(http.StatusConflict, resp("api keys limit is reached"))
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	key := API_KEY_PREFIX + secret
+
+	k := &database.APIKey{
+		OwnerId:   userId,
+		Name:      input.Name,
+		Prefix:    key[:len(API_KEY_PREFIX)+6],
+		HashedKey: hashToken(key),
+		Scopes:    input.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	id, err := s.db.AddAPIKey(k)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	// The key itself is shown only once, only its hash is stored.
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":     id,
+		"name":   k.Name,
+		"key":    key,
+		"scopes": k.Scopes,
+	})
+}
+
+func (s *Server) GetAPIKeysList(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	keys, err := s.db.GetUserAPIKeys(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (s *Server) DeleteAPIKey(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	keyId := c.Param("id")
+	if keyId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	err = s.db.DeleteAPIKey(userId, keyId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
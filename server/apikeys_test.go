@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TestCaseAPIKeyAuth struct {
+	Name           string
+	Header         string
+	Value          string
+	Scope          string
+	ExpectedStatus int
+	ExpectedUserId string
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	owner := database.UserOut{Id: primitive.NewObjectID(), Username: "alice"}
+	disabled := database.UserOut{Id: primitive.NewObjectID(), Username: "bob", Disabled: true}
+
+	db := newFakeStorager(owner, disabled)
+	db.apiKeys = []database.APIKeyOut{
+		{
+			Id:        primitive.NewObjectID(),
+			OwnerId:   owner.Id.Hex(),
+			HashedKey: hashToken("dpk_read"),
+			Scopes:    []string{SCOPE_MESSAGES_READ},
+		},
+		{
+			Id:        primitive.NewObjectID(),
+			OwnerId:   disabled.Id.Hex(),
+			HashedKey: hashToken("dpk_disabled"),
+			Scopes:    []string{SCOPE_MESSAGES_READ, SCOPE_MESSAGES_WRITE},
+		},
+		{
+			Id:        primitive.NewObjectID(),
+			OwnerId:   primitive.NewObjectID().Hex(),
+			HashedKey: hashToken("dpk_deleted_owner"),
+			Scopes:    []string{SCOPE_MESSAGES_READ},
+		},
+	}
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	auth := echo.HeaderAuthorization
+	cases := []TestCaseAPIKeyAuth{
+		{"without api key", "", "", SCOPE_MESSAGES_WRITE, http.StatusOK, ""},
+		{"jwt bearer token", auth, "Bearer jwt", SCOPE_MESSAGES_WRITE, http.StatusOK, ""},
+		{"key in header", API_KEY_HEADER, "dpk_read", SCOPE_MESSAGES_READ,
+			http.StatusOK, owner.Id.Hex()},
+		{"key as bearer token", auth, "Bearer dpk_read", SCOPE_MESSAGES_READ,
+			http.StatusOK, owner.Id.Hex()},
+		{"key without scope", API_KEY_HEADER, "dpk_read", SCOPE_MESSAGES_WRITE,
+			http.StatusForbidden, ""},
+		{"unknown key", API_KEY_HEADER, "dpk_unknown", SCOPE_MESSAGES_READ,
+			http.StatusUnauthorized, ""},
+		{"disabled owner", API_KEY_HEADER, "dpk_disabled", SCOPE_MESSAGES_READ,
+			http.StatusForbidden, ""},
+		{"deleted owner", API_KEY_HEADER, "dpk_deleted_owner", SCOPE_MESSAGES_READ,
+			http.StatusUnauthorized, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.Header != "" {
+				req.Header.Set(tc.Header, tc.Value)
+			}
+			rec := httptest.NewRecorder()
+			c := s.e.NewContext(req, rec)
+
+			userId := ""
+			handler := func(c echo.Context) error {
+				if isAPIKeyAuthenticated(c) {
+					sub := subjectFromContext(c)
+					userId = sub.userId
+					assert.Equal(t, ROLE_USER, sub.role)
+				}
+				return c.String(http.StatusOK, "")
+			}
+
+			err := s.authenticateAPIKey(requireScope(tc.Scope)(handler))(c)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.ExpectedStatus, rec.Code)
+			assert.Equal(t, tc.ExpectedUserId, userId)
+		})
+	}
+
+	assert.NotNil(t, db.apiKeys[0].LastUsedAt)
+	assert.Nil(t, db.apiKeys[1].LastUsedAt)
+}
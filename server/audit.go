@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	AUDIT_SIGNUP         = "auth.signup"
+	AUDIT_VERIFY         = "auth.verify"
+	AUDIT_SIGNIN         = "auth.signin"
+	AUDIT_TOKENS_REVOKED = "auth.tokens_revoked"
+
+	AUDIT_ACCOUNT_EXPORT = "account.export"
+
+	AUDIT_MESSAGE_CREATE = "message.create"
+	AUDIT_MESSAGE_UPDATE = "message.update"
+	AUDIT_MESSAGE_DELETE = "message.delete"
+	AUDIT_MESSAGE_READ   = "message.read"
+)
+
+// recordAudit appends the event with caller and IP of the request. Actor is
+// taken from the token when it is not set. Errors are only logged, the
+// request is not failed because of the audit log.
+func (s *Server) recordAudit(c echo.Context, e audit.Event) {
+	if e.ActorId == "" {
+		e.ActorId = subjectFromContext(c).userId
+	}
+	if e.Outcome == "" {
+		e.Outcome = audit.OUTCOME_SUCCESS
+	}
+	e.IP = c.RealIP()
+
+	if err := s.auditor.Record(e); err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// auditFailure records failed attempt with the reason.
+func (s *Server) auditFailure(c echo.Context, e audit.Event, reason string) {
+	e.Outcome = audit.OUTCOME_FAILURE
+	if e.Details == nil {
+		e.Details = map[string]string{}
+	}
+	e.Details["reason"] = reason
+	s.recordAudit(c, e)
+}
+
+// messageEvent is audit event of action on the message.
+func messageEvent(eventType string, msg database.MessageOut) audit.Event {
+	return audit.Event{
+		Type:     eventType,
+		TargetId: msg.Id.Hex(),
+		Details: map[string]string{
+			"public_id": msg.PublicId,
+			"owner_id":  msg.OwnerId,
+		},
+	}
+}
+
+// tokensRevokedEvent is audit event of revocation of every user token.
+func tokensRevokedEvent(userId, reason string) audit.Event {
+	return audit.Event{
+		Type:     AUDIT_TOKENS_REVOKED,
+		TargetId: userId,
+		Details:  map[string]string{"reason": reason},
+	}
+}
+
+// exportEvent is audit event of download of the user messages in the format.
+func exportEvent(userId, format string, messages int) audit.Event {
+	return audit.Event{
+		Type:     AUDIT_ACCOUNT_EXPORT,
+		TargetId: userId,
+		Details: map[string]string{
+			"format":   format,
+			"messages": strconv.Itoa(messages),
+		},
+	}
+}
+
+// NewAuditor opens audit log sink selected in config.
+func NewAuditor() (audit.Auditor, error) {
+	if config.AuditSink == "file" {
+		return audit.NewFileAuditor(config.AuditFile, config.AuditKey, config.AuditHeadFile)
+	}
+	return audit.NewMongoAuditor(config.MongoURL, config.AuditKey, config.AuditHeadFile)
+}
+
+// parseAuditTime reads RFC 3339 time from query param, empty param is zero
+// time.
+func parseAuditTime(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// AdminGetAuditLog returns audit events from the newest one filtered by type,
+// actor_id, target_id and from/to time range.
+func (s *Server) AdminGetAuditLog(c echo.Context) error {
+	from, err := parseAuditTime(c, "from")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp("from should be RFC 3339 time"))
+	}
+	to, err := parseAuditTime(c, "to")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp("to should be RFC 3339 time"))
+	}
+
+	filter := audit.Filter{
+		Type:     c.QueryParam("type"),
+		ActorId:  c.QueryParam("actor_id"),
+		TargetId: c.QueryParam("target_id"),
+		From:     from,
+		To:       to,
+	}
+
+	skip, limit := parsePagination(c)
+
+	events, total, err := s.auditor.Query(filter, skip, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total":  total,
+		"events": events,
+	})
+}
+
+// AdminVerifyAuditLog checks the whole hash chain and returns the first
+// changed, removed or reordered event. Head of the intact chain can be kept
+// outside to compare with later results.
+func (s *Server) AdminVerifyAuditLog(c echo.Context) error {
+	result, err := s.auditor.Verify()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if !result.Valid {
+		c.Logger().Warnf("audit log is broken at event %d: %s", result.BrokenSeq, result.Reason)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,266 @@
+// Package audit keeps append-only log of security events. Every event holds
+// hash of the previous one, so changed, removed or reordered events break
+// the chain and are found by verification. Hashes are keyed by the key kept
+// outside of the log, so the chain can't be rebuilt by whoever changes the
+// log. Events removed from the end are found by the head kept outside of
+// the log too.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	OUTCOME_SUCCESS = "success"
+	OUTCOME_FAILURE = "failure"
+)
+
+// Event is a record of the audit log. Seq, PrevHash and Hash are set by the
+// sink when the event is appended.
+type Event struct {
+	Seq      int64             `json:"seq" bson:"seq"`
+	At       time.Time         `json:"at" bson:"at"`
+	Type     string            `json:"type" bson:"type"`
+	Outcome  string            `json:"outcome" bson:"outcome"`
+	ActorId  string            `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	IP       string            `json:"ip,omitempty" bson:"ip,omitempty"`
+	TargetId string            `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	Details  map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	PrevHash string            `json:"prev_hash" bson:"prev_hash"`
+	Hash     string            `json:"hash" bson:"hash"`
+}
+
+// Filter selects events, empty fields match any value.
+type Filter struct {
+	Type     string
+	ActorId  string
+	TargetId string
+	From     time.Time
+	To       time.Time
+}
+
+func (f Filter) match(e Event) bool {
+	return (f.Type == "" || e.Type == f.Type) &&
+		(f.ActorId == "" || e.ActorId == f.ActorId) &&
+		(f.TargetId == "" || e.TargetId == f.TargetId) &&
+		(f.From.IsZero() || !e.At.Before(f.From)) &&
+		(f.To.IsZero() || e.At.Before(f.To))
+}
+
+// Head is the last appended event. Its hash is keyed, so the head can be
+// exported and compared with the log later to find removed events.
+type Head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// VerifyResult describes the first broken event of the chain, BrokenSeq is
+// zero when the chain is intact. Head is the last checked event.
+type VerifyResult struct {
+	Valid     bool   `json:"valid"`
+	Checked   int64  `json:"checked"`
+	BrokenSeq int64  `json:"broken_seq,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Head      *Head  `json:"head,omitempty"`
+}
+
+// Auditor appends events to the log. There is no way to change or remove
+// recorded events.
+type Auditor interface {
+	Record(e Event) error
+	// Query returns matched events from the newest one and total amount of
+	// matched events.
+	Query(f Filter, skip, limit int) ([]Event, int64, error)
+	Verify() (VerifyResult, error)
+	Close() error
+}
+
+// hashedEvent is the part of event covered by the hash. Time is formatted
+// with millisecond precision which is kept by every sink.
+type hashedEvent struct {
+	Seq      int64             `json:"seq"`
+	At       string            `json:"at"`
+	Type     string            `json:"type"`
+	Outcome  string            `json:"outcome"`
+	ActorId  string            `json:"actor_id"`
+	IP       string            `json:"ip"`
+	TargetId string            `json:"target_id"`
+	Details  map[string]string `json:"details,omitempty"`
+	PrevHash string            `json:"prev_hash"`
+}
+
+// ComputeHash returns hex encoded HMAC-SHA256 of the event fields except
+// Hash.
+func ComputeHash(key []byte, e Event) string {
+	data, _ := json.Marshal(hashedEvent{
+		Seq:      e.Seq,
+		At:       e.At.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Type:     e.Type,
+		Outcome:  e.Outcome,
+		ActorId:  e.ActorId,
+		IP:       e.IP,
+		TargetId: e.TargetId,
+		Details:  e.Details,
+		PrevHash: e.PrevHash,
+	})
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// chain links the event to the previous one, prev is nil for the first
+// event of the log.
+func chain(key []byte, e Event, prev *Event) Event {
+	e.Seq = 1
+	e.PrevHash = ""
+	if prev != nil {
+		e.Seq = prev.Seq + 1
+		e.PrevHash = prev.Hash
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	e.At = e.At.UTC().Truncate(time.Millisecond)
+	e.Hash = ComputeHash(key, e)
+	return e
+}
+
+// writeHead replaces the head file with the event. The file is replaced by
+// rename, so it's never left half written. Nothing is written when the path
+// is empty.
+func writeHead(path string, e Event) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(Head{Seq: e.Seq, Hash: e.Hash})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readHead returns head from the file, it's nil when the path is empty or
+// nothing is appended yet.
+func readHead(path string) (*Head, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	head := &Head{}
+	if err = json.Unmarshal(data, head); err != nil {
+		return nil, err
+	}
+
+	return head, nil
+}
+
+// verifier checks events one by one in order of the chain. Head is the
+// event which must be in the log, it's nil when it's not known.
+type verifier struct {
+	key    []byte
+	head   *Head
+	result VerifyResult
+	prev   *Event
+}
+
+func newVerifier(key []byte, head *Head) *verifier {
+	return &verifier{
+		key:    key,
+		head:   head,
+		result: VerifyResult{Valid: true},
+	}
+}
+
+// add returns false when the event breaks the chain, next events are not
+// checked after that.
+func (v *verifier) add(e Event) bool {
+	if !v.result.Valid {
+		return false
+	}
+
+	expectedSeq, expectedPrev := int64(1), ""
+	if v.prev != nil {
+		expectedSeq, expectedPrev = v.prev.Seq+1, v.prev.Hash
+	}
+
+	reason := ""
+	switch {
+	case e.Seq != expectedSeq:
+		reason = fmt.Sprintf("expected event %d, found %d", expectedSeq, e.Seq)
+	case e.PrevHash != expectedPrev:
+		reason = "previous hash doesn't match"
+	case e.Hash != ComputeHash(v.key, e):
+		reason = "event hash doesn't match its content"
+	case v.head != nil && e.Seq == v.head.Seq && e.Hash != v.head.Hash:
+		reason = "event doesn't match the head"
+	}
+
+	if reason != "" {
+		v.fail(expectedSeq, reason)
+		return false
+	}
+
+	v.result.Checked++
+	v.result.Head = &Head{Seq: e.Seq, Hash: e.Hash}
+	v.prev = &e
+	return true
+}
+
+func (v *verifier) fail(seq int64, reason string) {
+	v.result.Valid = false
+	v.result.BrokenSeq = seq
+	v.result.Reason = reason
+}
+
+// finish checks that the log isn't cut before the head after every event
+// is added.
+func (v *verifier) finish() VerifyResult {
+	if v.result.Valid && v.head != nil && v.result.Checked < v.head.Seq {
+		v.fail(v.result.Checked+1, fmt.Sprintf("events up to the head %d are removed", v.head.Seq))
+	}
+	return v.result
+}
+
+// VerifyEvents checks chain of events sorted by Seq from the first one. Head
+// is nil when it's not known.
+func VerifyEvents(key []byte, events []Event, head *Head) VerifyResult {
+	v := newVerifier(key, head)
+	for _, e := range events {
+		if !v.add(e) {
+			break
+		}
+	}
+	return v.finish()
+}
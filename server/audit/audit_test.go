@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = []byte("audit-test-key")
+
+func newChain(n int) []Event {
+	events := []Event{}
+	var prev *Event
+	for i := 0; i < n; i++ {
+		e := chain(testKey, Event{
+			Type:    "auth.signin",
+			Outcome: OUTCOME_SUCCESS,
+			ActorId: "user",
+			Details: map[string]string{"username": "user"},
+		}, prev)
+		events = append(events, e)
+		prev = &e
+	}
+	return events
+}
+
+func TestVerifyEvents(t *testing.T) {
+	type TestCaseVerifyEvents struct {
+		Name      string
+		Change    func(events []Event) []Event
+		Head      *Head
+		Valid     bool
+		BrokenSeq int64
+	}
+
+	testCases := []TestCaseVerifyEvents{
+		{
+			Name:   "intact",
+			Change: func(events []Event) []Event { return events },
+			Valid:  true,
+		},
+		{
+			Name: "changed content",
+			Change: func(events []Event) []Event {
+				events[2].Outcome = OUTCOME_FAILURE
+				return events
+			},
+			BrokenSeq: 3,
+		},
+		{
+			Name: "changed content with recomputed hash",
+			Change: func(events []Event) []Event {
+				events[2].ActorId = "admin"
+				events[2].Hash = ComputeHash(testKey, events[2])
+				return events
+			},
+			BrokenSeq: 4,
+		},
+		{
+			Name: "rebuilt chain without key",
+			Change: func(events []Event) []Event {
+				events[2].ActorId = "admin"
+				events[2].Hash = ComputeHash([]byte("other-key"), events[2])
+				for i := 3; i < len(events); i++ {
+					events[i].PrevHash = events[i-1].Hash
+					events[i].Hash = ComputeHash([]byte("other-key"), events[i])
+				}
+				return events
+			},
+			BrokenSeq: 3,
+		},
+		{
+			Name:   "intact with head",
+			Change: func(events []Event) []Event { return events },
+			Head:   &Head{Seq: 5},
+			Valid:  true,
+		},
+		{
+			Name: "removed tail",
+			Change: func(events []Event) []Event {
+				return events[:3]
+			},
+			Head:      &Head{Seq: 5},
+			BrokenSeq: 4,
+		},
+		{
+			Name: "replaced head",
+			Change: func(events []Event) []Event {
+				return events
+			},
+			Head:      &Head{Seq: 5, Hash: "other"},
+			BrokenSeq: 5,
+		},
+		{
+			Name: "removed event",
+			Change: func(events []Event) []Event {
+				return append(events[:1], events[2:]...)
+			},
+			BrokenSeq: 2,
+		},
+		{
+			Name: "reordered events",
+			Change: func(events []Event) []Event {
+				events[1], events[2] = events[2], events[1]
+				return events
+			},
+			BrokenSeq: 2,
+		},
+		{
+			Name: "removed head",
+			Change: func(events []Event) []Event {
+				return events[1:]
+			},
+			BrokenSeq: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		events := newChain(5)
+		if tc.Head != nil && tc.Head.Hash == "" {
+			tc.Head.Hash = events[tc.Head.Seq-1].Hash
+		}
+		result := VerifyEvents(testKey, tc.Change(events), tc.Head)
+		assert.Equal(t, tc.Valid, result.Valid, tc.Name)
+		assert.Equal(t, tc.BrokenSeq, result.BrokenSeq, tc.Name)
+	}
+}
+
+func TestChain(t *testing.T) {
+	events := newChain(2)
+
+	assert.Equal(t, int64(1), events[0].Seq)
+	assert.Equal(t, "", events[0].PrevHash)
+	assert.Equal(t, int64(2), events[1].Seq)
+	assert.Equal(t, events[0].Hash, events[1].PrevHash)
+	assert.Equal(t, events[0].At, events[0].At.Truncate(time.Millisecond))
+}
+
+func TestFileAuditor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	headPath := filepath.Join(t.TempDir(), "head.json")
+
+	a, err := NewFileAuditor(path, testKey, headPath)
+	assert.Nil(t, err)
+	for _, actor := range []string{"first", "second", "first"} {
+		assert.Nil(t, a.Record(Event{Type: "message.read", Outcome: OUTCOME_SUCCESS, ActorId: actor}))
+	}
+	assert.Nil(t, a.Close())
+
+	// Chain continues after reopening.
+	a, err = NewFileAuditor(path, testKey, headPath)
+	assert.Nil(t, err)
+	defer a.Close()
+	assert.Nil(t, a.Record(Event{Type: "message.delete", Outcome: OUTCOME_SUCCESS, ActorId: "first"}))
+
+	result, err := a.Verify()
+	assert.Nil(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, int64(4), result.Checked)
+	assert.Equal(t, a.last.Hash, result.Head.Hash)
+
+	head, err := readHead(headPath)
+	assert.Nil(t, err)
+	assert.Equal(t, result.Head, head)
+
+	events, total, err := a.Query(Filter{ActorId: "first"}, 0, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, events, 2)
+	assert.Equal(t, int64(4), events[0].Seq)
+	assert.Equal(t, int64(3), events[1].Seq)
+
+	events, _, err = a.Query(Filter{ActorId: "first"}, 2, 2)
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, int64(1), events[0].Seq)
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+
+	// Last event is removed.
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	truncated := strings.Join(lines[:len(lines)-1], "")
+	assert.Nil(t, os.WriteFile(path, []byte(truncated), 0600))
+
+	result, err = a.Verify()
+	assert.Nil(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, int64(4), result.BrokenSeq)
+	assert.Equal(t, int64(3), result.Checked)
+
+	tampered := strings.Replace(string(data), `"actor_id":"second"`, `"actor_id":"third"`, 1)
+	assert.Nil(t, os.WriteFile(path, []byte(tampered), 0600))
+
+	result, err = a.Verify()
+	assert.Nil(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, int64(2), result.BrokenSeq)
+	assert.Equal(t, int64(1), result.Checked)
+}
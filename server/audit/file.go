@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// Upper bound of one line in the log file.
+const MAX_LINE_SIZE = 1 << 20
+
+// FileAuditor writes events to the file as JSON lines. The file must be
+// written by a single process, use MongoAuditor when the server runs in
+// several instances.
+type FileAuditor struct {
+	path     string
+	key      []byte
+	headPath string
+
+	mu   sync.Mutex
+	file *os.File
+	last *Event
+}
+
+// NewFileAuditor opens the log file. Events are hashed with the key, head of
+// the log is written to headPath when it's not empty.
+func NewFileAuditor(path string, key []byte, headPath string) (*FileAuditor, error) {
+	a := &FileAuditor{
+		path:     path,
+		key:      key,
+		headPath: headPath,
+	}
+
+	err := a.scan(func(e Event) bool {
+		a.last = &e
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	a.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// scan reads events from the start of the file until fn returns false.
+func (a *FileAuditor) scan(fn func(e Event) bool) error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_LINE_SIZE)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		e := Event{}
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (a *FileAuditor) Record(e Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	e = chain(a.key, e, a.last)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err = a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err = a.file.Sync(); err != nil {
+		return err
+	}
+
+	a.last = &e
+	return writeHead(a.headPath, e)
+}
+
+func (a *FileAuditor) Query(f Filter, skip, limit int) ([]Event, int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	matched := []Event{}
+	err := a.scan(func(e Event) bool {
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, 0, err
+	}
+
+	total := int64(len(matched))
+	events := make([]Event, 0, limit)
+	for i := len(matched) - 1 - skip; i >= 0 && len(events) < limit; i-- {
+		events = append(events, matched[i])
+	}
+
+	return events, total, nil
+}
+
+func (a *FileAuditor) Verify() (VerifyResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	head, err := readHead(a.headPath)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	v := newVerifier(a.key, head)
+	err = a.scan(v.add)
+	if errors.Is(err, os.ErrNotExist) {
+		return v.finish(), nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// Broken line is a changed event too.
+		v.result.Valid = false
+		v.result.BrokenSeq = v.result.Checked + 1
+		v.result.Reason = "event is not valid JSON"
+		return v.result, nil
+	}
+	if err != nil {
+		return v.result, err
+	}
+
+	return v.finish(), nil
+}
+
+func (a *FileAuditor) Close() error {
+	return a.file.Close()
+}
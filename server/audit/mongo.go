@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Delay before the next attempt to append event when other instance
+// appended event at the same time, it doubles up to the max delay. The event
+// is not recorded after max attempts.
+const (
+	APPEND_RETRY_DELAY     = 5 * time.Millisecond
+	APPEND_MAX_RETRY_DELAY = time.Second
+	APPEND_MAX_ATTEMPTS    = 10
+)
+
+var ErrAppendConflict = errors.New("event is not appended, other instances took every seq")
+
+// MongoAuditor keeps events in the collection. Unique index on seq makes
+// sure that instances don't append two events after the same one.
+type MongoAuditor struct {
+	client   *mongo.Client
+	col      *mongo.Collection
+	key      []byte
+	headPath string
+	// mu serializes appends of the instance, only other instances compete
+	// for the next seq.
+	mu sync.Mutex
+}
+
+// NewMongoAuditor connects to the database. Events are hashed with the key,
+// head of events appended by the instance is written to headPath when it's
+// not empty.
+func NewMongoAuditor(connectionUrl string, key []byte, headPath string) (*MongoAuditor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connectionUrl))
+	if err != nil {
+		return nil, err
+	}
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		return nil, err
+	}
+
+	col := client.Database("deepenc").Collection("AuditLog")
+
+	_, err = col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "type", Value: 1}, {Key: "seq", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "seq", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "seq", Value: -1}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoAuditor{
+		client:   client,
+		col:      col,
+		key:      key,
+		headPath: headPath,
+	}, nil
+}
+
+// Record appends the event after the last one. When other instance takes
+// the seq first, the event is chained after the new last event, so events
+// are not dropped because of concurrent appends. After APPEND_MAX_ATTEMPTS
+// collisions ErrAppendConflict is returned, the lock is not held forever.
+func (a *MongoAuditor) Record(e Event) error {
+	ctx := context.Background()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delay := APPEND_RETRY_DELAY
+	for attempt := 1; ; attempt++ {
+		var prev *Event
+		last := Event{}
+		opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+		err := a.col.FindOne(ctx, bson.D{}, opts).Decode(&last)
+		if err == nil {
+			prev = &last
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		chained := chain(a.key, e, prev)
+		_, err = a.col.InsertOne(ctx, chained)
+		if err == nil {
+			return writeHead(a.headPath, chained)
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if attempt == APPEND_MAX_ATTEMPTS {
+			return ErrAppendConflict
+		}
+
+		// Jitter spreads attempts of instances which collided.
+		time.Sleep(delay + rand.N(delay))
+		delay = min(delay*2, APPEND_MAX_RETRY_DELAY)
+	}
+}
+
+func eventsFilter(f Filter) bson.D {
+	filter := bson.D{}
+	if f.Type != "" {
+		filter = append(filter, bson.E{Key: "type", Value: f.Type})
+	}
+	if f.ActorId != "" {
+		filter = append(filter, bson.E{Key: "actor_id", Value: f.ActorId})
+	}
+	if f.TargetId != "" {
+		filter = append(filter, bson.E{Key: "target_id", Value: f.TargetId})
+	}
+
+	at := bson.D{}
+	if !f.From.IsZero() {
+		at = append(at, bson.E{Key: "$gte", Value: f.From})
+	}
+	if !f.To.IsZero() {
+		at = append(at, bson.E{Key: "$lt", Value: f.To})
+	}
+	if len(at) > 0 {
+		filter = append(filter, bson.E{Key: "at", Value: at})
+	}
+
+	return filter
+}
+
+func (a *MongoAuditor) Query(f Filter, skip, limit int) ([]Event, int64, error) {
+	ctx := context.Background()
+	filter := eventsFilter(f)
+
+	total, err := a.col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}}).
+		SetSkip(int64(skip)).SetLimit(int64(limit))
+	cursor, err := a.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := make([]Event, 0)
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+func (a *MongoAuditor) Verify() (VerifyResult, error) {
+	ctx := context.Background()
+
+	head, err := readHead(a.headPath)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := a.col.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer cursor.Close(ctx)
+
+	v := newVerifier(a.key, head)
+	for cursor.Next(ctx) {
+		e := Event{}
+		if err = cursor.Decode(&e); err != nil {
+			return v.result, err
+		}
+		if !v.add(e) {
+			break
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return v.result, err
+	}
+
+	return v.finish(), nil
+}
+
+func (a *MongoAuditor) Close() error {
+	return a.client.Disconnect(context.Background())
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAudit(t *testing.T) {
+	auditor, err := audit.NewFileAuditor(filepath.Join(t.TempDir(), "audit.log"),
+		[]byte("audit-test-key"), "")
+	assert.Nil(t, err)
+	defer auditor.Close()
+
+	s := &Server{auditor: auditor}
+
+	e := echo.New()
+	e.IPExtractor = newIPExtractor(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "203.0.113.7:52000"
+	// Set by the client, not by a trusted proxy.
+	req.Header.Set(echo.HeaderXRealIP, "198.51.100.1")
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	s.recordAudit(c, tokensRevokedEvent("user", "password changed"))
+	s.auditFailure(c, audit.Event{Type: AUDIT_SIGNIN}, "wrong credentials")
+
+	events, total, err := auditor.Query(audit.Filter{}, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), total)
+
+	assert.Equal(t, AUDIT_SIGNIN, events[0].Type)
+	assert.Equal(t, audit.OUTCOME_FAILURE, events[0].Outcome)
+	assert.Equal(t, "wrong credentials", events[0].Details["reason"])
+	assert.Equal(t, "203.0.113.7", events[0].IP)
+
+	assert.Equal(t, AUDIT_TOKENS_REVOKED, events[1].Type)
+	assert.Equal(t, audit.OUTCOME_SUCCESS, events[1].Outcome)
+	assert.Equal(t, "user", events[1].TargetId)
+
+	result, err := auditor.Verify()
+	assert.Nil(t, err)
+	assert.True(t, result.Valid)
+}
@@ -2,50 +2,97 @@ package server
 
 import (
 	"errors"
+	"net/http"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 )
 
+func init() {
+	// Issue time is compared with revocation time, seconds are too coarse
+	// for tokens issued right before or after the revocation.
+	jwt.TimePrecision = time.Millisecond
+}
+
 type jwtCustomClaims struct {
 	jwt.RegisteredClaims
+	Role string `json:"role,omitempty"`
 }
 
-func newJWTConfig(secret string) echojwt.Config {
+func newJWTConfig(keys *jwtKeySet) echojwt.Config {
 	cfg := echojwt.Config{
 		NewClaimsFunc: func(c echo.Context) jwt.Claims {
 			return new(jwtCustomClaims)
 		},
-		SigningKey: []byte(secret),
+		KeyFunc: keys.keyFunc,
 	}
 
 	return cfg
 }
 
-func newJWT(userId, secret string) (string, error) {
+func newJWT(userId, role string, keys *jwtKeySet) (string, error) {
 	claims := &jwtCustomClaims{
-		jwt.RegisteredClaims{
-			ID: userId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       userId,
+			IssuedAt: jwt.NewNumericDate(time.Now()),
 		},
+		Role: role,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(secret))
+	return keys.sign(claims)
+}
+
+func getUserIdFromJWT(c echo.Context) (string, error) {
+	claims, err := getClaimsFromJWT(c)
 	if err != nil {
 		return "", err
 	}
-	return signedToken, nil
+	return claims.ID, nil
 }
 
-func getUserIdFromJWT(c echo.Context) (string, error) {
+func getClaimsFromJWT(c echo.Context) (*jwtCustomClaims, error) {
 	user, ok := c.Get("user").(*jwt.Token)
 	if !ok {
-		return "", errors.New("can't convert value from context to *jwt.Token")
+		return nil, errors.New("can't convert value from context to *jwt.Token")
 	}
 	claims, ok := user.Claims.(*jwtCustomClaims)
 	if !ok {
-		return "", errors.New("can't convert jwt token to custom claims")
+		return nil, errors.New("can't convert jwt token to custom claims")
 	}
-	return claims.ID, nil
+	return claims, nil
+}
+
+// checkTokenRevocation rejects tokens issued before the last revocation of
+// user tokens (password change or reset).
+func (s *Server) checkTokenRevocation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isAPIKeyAuthenticated(c) {
+			return next(c)
+		}
+
+		claims, err := getClaimsFromJWT(c)
+		if err != nil {
+			return c.String(http.StatusUnauthorized, "")
+		}
+
+		revokedAt, err := s.cachedb.GetTokensRevokedAt(claims.ID)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+
+		if isTokenRevoked(claims, revokedAt) {
+			return c.String(http.StatusUnauthorized, "")
+		}
+
+		return next(c)
+	}
+}
+
+func isTokenRevoked(claims *jwtCustomClaims, revokedAt time.Time) bool {
+	return !revokedAt.IsZero() &&
+		(claims.IssuedAt == nil ||
+			claims.IssuedAt.Time.Before(revokedAt.Truncate(time.Millisecond)))
 }
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestCaseTokenRevoked struct {
+	Name      string
+	IssuedAt  *jwt.NumericDate
+	RevokedAt time.Time
+	Expected  bool
+}
+
+func TestIsTokenRevoked(t *testing.T) {
+	revokedAt := time.Date(2024, 3, 1, 10, 0, 0, 500*int(time.Millisecond), time.UTC)
+
+	cases := []TestCaseTokenRevoked{
+		{"never revoked", jwt.NewNumericDate(revokedAt), time.Time{}, false},
+		{"without issue time", nil, revokedAt, true},
+		{"issued earlier", jwt.NewNumericDate(revokedAt.Add(-time.Hour)), revokedAt, true},
+		{"issued earlier in the same second", jwt.NewNumericDate(revokedAt.Add(-100 * time.Millisecond)),
+			revokedAt, true},
+		{"issued at revocation", jwt.NewNumericDate(revokedAt), revokedAt, false},
+		{"issued later in the same second", jwt.NewNumericDate(revokedAt.Add(100 * time.Millisecond)),
+			revokedAt, false},
+	}
+
+	for _, tc := range cases {
+		claims := &jwtCustomClaims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: tc.IssuedAt}}
+		assert.Equal(t, tc.Expected, isTokenRevoked(claims, tc.RevokedAt), tc.Name)
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	BULK_ACTION_DELETE         = "delete"
+	BULK_ACTION_SET_VISIBILITY = "set_visibility"
+	BULK_ACTION_EXTEND_TTL     = "extend_ttl"
+
+	MAX_BULK_ITEMS = 500
+
+	BULK_STATUS_OK        = "ok"
+	BULK_STATUS_NOT_FOUND = "not_found"
+	BULK_STATUS_FORBIDDEN = "forbidden"
+	BULK_STATUS_INVALID   = "invalid"
+	BULK_STATUS_FAILED    = "failed"
+)
+
+type BulkFilter struct {
+	Tag           string    `json:"tag"`
+	CollectionId  string    `json:"collection_id"`
+	EncodingType  string    `json:"encoding_type"`
+	CreatedBefore time.Time `json:"created_before"`
+}
+
+// BulkRequest selects messages by ids or by filter and applies one action to
+// all of them.
+type BulkRequest struct {
+	Ids    []string    `json:"ids"`
+	Filter *BulkFilter `json:"filter"`
+	Action string      `json:"action"`
+
+	// Used by set_visibility action.
+	IsPrivate     *bool `json:"is_private"`
+	OnlyOwnerView *bool `json:"only_owner_view"`
+
+	// Used by extend_ttl action, seconds added to current expiration time.
+	TTL int `json:"ttl"`
+}
+
+type BulkResult struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+}
+
+func (r BulkRequest) isValid() bool {
+	if (len(r.Ids) == 0) == (r.Filter == nil) || len(r.Ids) > MAX_BULK_ITEMS {
+		return false
+	}
+
+	switch r.Action {
+	case BULK_ACTION_DELETE:
+	case BULK_ACTION_SET_VISIBILITY:
+		if r.IsPrivate == nil && r.OnlyOwnerView == nil {
+			return false
+		}
+	case BULK_ACTION_EXTEND_TTL:
+		if r.TTL <= 0 || r.TTL > MAX_TTL {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// bulkOp builds operation for the message or returns status why it is
+// skipped.
+func (r BulkRequest) bulkOp(msg database.MessageOut, now time.Time) (database.BulkMessageOp, string) {
+	op := database.BulkMessageOp{Id: msg.Id.Hex(), Patch: database.MessagePatch{UpdatedAt: now}}
+
+	switch r.Action {
+	case BULK_ACTION_DELETE:
+		op.Delete = true
+	case BULK_ACTION_SET_VISIBILITY:
+		// Encoded messages are always private.
+		if r.IsPrivate != nil && !*r.IsPrivate && msg.EncodingType != "plaintext" {
+			return op, BULK_STATUS_INVALID
+		}
+		op.Patch.IsPrivate = r.IsPrivate
+		op.Patch.OnlyOwnerView = r.OnlyOwnerView
+	case BULK_ACTION_EXTEND_TTL:
+		// Messages without expiration live forever already.
+		if msg.ExpiresAt == nil {
+			return op, BULK_STATUS_INVALID
+		}
+		at := msg.ExpiresAt.Add(time.Duration(r.TTL) * time.Second)
+		if limit := now.Add(MAX_TTL * time.Second); at.After(limit) {
+			at = limit
+		}
+		op.Patch.ExpiresAt = &at
+	}
+
+	return op, BULK_STATUS_OK
+}
+
+// uniqueIds drops repeated ids keeping the order.
+func uniqueIds(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func (s *Server) bulkTargets(userId string, r BulkRequest) (database.MessagesOut, error) {
+	if r.Filter == nil {
+		return s.db.GetMessagesByIds(r.Ids)
+	}
+
+	messages, err := s.db.GetUserMessages(userId, database.MessageFilter{
+		Tag:           strings.ToLower(strings.TrimSpace(r.Filter.Tag)),
+		CollectionId:  r.Filter.CollectionId,
+		EncodingType:  r.Filter.EncodingType,
+		CreatedBefore: r.Filter.CreatedBefore,
+	})
+	if err == mongo.ErrNoDocuments {
+		return database.MessagesOut{}, nil
+	}
+
+	return messages, err
+}
+
+// BulkMessages deletes, changes visibility or extends ttl of many messages
+// at once and returns result for every message.
+func (s *Server) BulkMessages(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(BulkRequest)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !input.isValid() {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	messages, err := s.bulkTargets(userId, *input)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if len(messages) > MAX_BULK_ITEMS {
+		return c.JSON(http.StatusBadRequest,
+			resp("filter matches too many messages, narrow it down"))
+	}
+
+	// Ids in the request are either internal or public.
+	found := make(map[string]database.MessageOut, len(messages))
+	for _, msg := range messages {
+		found[msg.Id.Hex()] = msg
+		found[msg.PublicId] = msg
+	}
+
+	ids := uniqueIds(input.Ids)
+	if input.Filter != nil {
+		ids = make([]string, 0, len(messages))
+		for _, msg := range messages {
+			ids = append(ids, msg.Id.Hex())
+		}
+	}
+
+	act := actionUpdate
+	if input.Action == BULK_ACTION_DELETE {
+		act = actionDelete
+	}
+
+	sub := subjectFromContext(c)
+	now := time.Now().UTC()
+
+	results := make([]BulkResult, len(ids))
+	ops := make([]database.BulkMessageOp, 0, len(ids))
+	opResults := make([]int, 0, len(ids))
+	// The same message may be passed by both internal and public ids, it
+	// is changed once and gets the result of its first id.
+	targeted := make(map[string]int, len(ids))
+	aliases := make(map[int]int)
+	for i, id := range ids {
+		results[i] = BulkResult{Id: id, Status: BULK_STATUS_NOT_FOUND}
+
+		msg, ok := found[id]
+		if !ok || isExpired(msg) {
+			continue
+		}
+
+		if first, ok := targeted[msg.Id.Hex()]; ok {
+			aliases[i] = first
+			continue
+		}
+		targeted[msg.Id.Hex()] = i
+
+		// Bulk operations work on own messages only, admins remove other
+		// users messages one by one.
+		err = authorize(sub, act, msg)
+		if err == nil && !sub.owns(msg) {
+			err = errForbidden
+		}
+		if err != nil {
+			if err == errForbidden {
+				results[i].Status = BULK_STATUS_FORBIDDEN
+			}
+			continue
+		}
+
+		op, status := input.bulkOp(msg, now)
+		results[i].Status = status
+		if status == BULK_STATUS_OK {
+			ops = append(ops, op)
+			opResults = append(opResults, i)
+		}
+	}
+
+	failed, err := s.db.BulkMessages(userId, ops)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	for i, opErr := range failed {
+		if opErr == mongo.ErrNoDocuments {
+			results[opResults[i]].Status = BULK_STATUS_NOT_FOUND
+			continue
+		}
+		c.Logger().Error(opErr)
+		results[opResults[i]].Status = BULK_STATUS_FAILED
+	}
+
+	eventType := AUDIT_MESSAGE_UPDATE
+	if input.Action == BULK_ACTION_DELETE {
+		eventType = AUDIT_MESSAGE_DELETE
+	}
+	for _, i := range opResults {
+		if results[i].Status != BULK_STATUS_OK {
+			continue
+		}
+		msg := found[results[i].Id]
+
+		e := messageEvent(eventType, msg)
+		e.Details["bulk_action"] = input.Action
+		s.recordAudit(c, e)
+
+		if input.Action == BULK_ACTION_DELETE {
+			if err = s.emitMessageEvent(WEBHOOK_EVENT_DELETED, msg); err != nil {
+				c.Logger().Error(err)
+			}
+		}
+	}
+
+	for i, first := range aliases {
+		results[i].Status = results[first].Status
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TestCaseBulkRequest struct {
+	Name     string
+	Request  BulkRequest
+	Expected bool
+}
+
+func TestBulkRequestIsValid(t *testing.T) {
+	yes := true
+	ids := []string{"65f1c0a0e4b0a1b2c3d4e5f6"}
+	filter := &BulkFilter{Tag: "old"}
+
+	cases := []TestCaseBulkRequest{
+		{"delete by ids", BulkRequest{Ids: ids, Action: BULK_ACTION_DELETE}, true},
+		{"delete by filter", BulkRequest{Filter: filter, Action: BULK_ACTION_DELETE}, true},
+		{"ids and filter", BulkRequest{Ids: ids, Filter: filter, Action: BULK_ACTION_DELETE}, false},
+		{"no targets", BulkRequest{Action: BULK_ACTION_DELETE}, false},
+		{"too many ids", BulkRequest{Ids: make([]string, MAX_BULK_ITEMS+1),
+			Action: BULK_ACTION_DELETE}, false},
+		{"unknown action", BulkRequest{Ids: ids, Action: "archive"}, false},
+		{"visibility", BulkRequest{Ids: ids, Action: BULK_ACTION_SET_VISIBILITY,
+			IsPrivate: &yes}, true},
+		{"visibility without fields", BulkRequest{Ids: ids,
+			Action: BULK_ACTION_SET_VISIBILITY}, false},
+		{"extend ttl", BulkRequest{Ids: ids, Action: BULK_ACTION_EXTEND_TTL, TTL: 60}, true},
+		{"extend ttl without ttl", BulkRequest{Ids: ids, Action: BULK_ACTION_EXTEND_TTL}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, tc.Request.isValid())
+		})
+	}
+}
+
+func TestBulkOp(t *testing.T) {
+	no := false
+	now := time.Now().UTC()
+	expires := now.Add(time.Hour)
+
+	plain := database.MessageOut{EncodingType: "plaintext", ExpiresAt: &expires}
+	encoded := database.MessageOut{EncodingType: "aes", IsPrivate: true}
+
+	r := BulkRequest{Action: BULK_ACTION_SET_VISIBILITY, IsPrivate: &no}
+	_, status := r.bulkOp(plain, now)
+	assert.Equal(t, BULK_STATUS_OK, status)
+	_, status = r.bulkOp(encoded, now)
+	assert.Equal(t, BULK_STATUS_INVALID, status)
+
+	r = BulkRequest{Action: BULK_ACTION_EXTEND_TTL, TTL: 60}
+	op, status := r.bulkOp(plain, now)
+	assert.Equal(t, BULK_STATUS_OK, status)
+	assert.Equal(t, expires.Add(time.Minute), *op.Patch.ExpiresAt)
+	_, status = r.bulkOp(encoded, now)
+	assert.Equal(t, BULK_STATUS_INVALID, status)
+
+	r = BulkRequest{Action: BULK_ACTION_EXTEND_TTL, TTL: MAX_TTL}
+	op, _ = r.bulkOp(plain, now)
+	assert.Equal(t, now.Add(MAX_TTL*time.Second), *op.Patch.ExpiresAt)
+
+	r = BulkRequest{Action: BULK_ACTION_DELETE}
+	op, status = r.bulkOp(plain, now)
+	assert.Equal(t, BULK_STATUS_OK, status)
+	assert.True(t, op.Delete)
+}
+
+type TestCaseBulkDelete struct {
+	Name            string
+	Ids             []string
+	Vanished        []string
+	ExpectedResults []BulkResult
+	ExpectedDeleted []string
+}
+
+func TestBulkMessagesDelete(t *testing.T) {
+	owner := primitive.NewObjectID().Hex()
+	first := database.MessageOut{Id: primitive.NewObjectID(), PublicId: "first", OwnerId: owner}
+	second := database.MessageOut{Id: primitive.NewObjectID(), PublicId: "second", OwnerId: owner}
+	other := database.MessageOut{Id: primitive.NewObjectID(), PublicId: "other",
+		OwnerId: primitive.NewObjectID().Hex()}
+	missing := primitive.NewObjectID().Hex()
+
+	cases := []TestCaseBulkDelete{
+		{
+			Name: "own messages",
+			Ids:  []string{first.Id.Hex(), second.PublicId},
+			ExpectedResults: []BulkResult{
+				{first.Id.Hex(), BULK_STATUS_OK},
+				{second.PublicId, BULK_STATUS_OK},
+			},
+			ExpectedDeleted: []string{first.Id.Hex(), second.Id.Hex()},
+		},
+		{
+			Name: "repeated ids",
+			Ids:  []string{first.Id.Hex(), first.Id.Hex(), first.PublicId},
+			ExpectedResults: []BulkResult{
+				{first.Id.Hex(), BULK_STATUS_OK},
+				{first.PublicId, BULK_STATUS_OK},
+			},
+			ExpectedDeleted: []string{first.Id.Hex()},
+		},
+		{
+			Name: "missing and foreign messages",
+			Ids:  []string{missing, other.PublicId},
+			ExpectedResults: []BulkResult{
+				{missing, BULK_STATUS_NOT_FOUND},
+				{other.PublicId, BULK_STATUS_FORBIDDEN},
+			},
+		},
+		{
+			Name:     "message deleted concurrently",
+			Ids:      []string{first.Id.Hex(), second.Id.Hex()},
+			Vanished: []string{second.Id.Hex()},
+			ExpectedResults: []BulkResult{
+				{first.Id.Hex(), BULK_STATUS_OK},
+				{second.Id.Hex(), BULK_STATUS_NOT_FOUND},
+			},
+			ExpectedDeleted: []string{first.Id.Hex()},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			db := newFakeStorager()
+			db.addMessages(first, second, other)
+			db.vanished = tc.Vanished
+			cache := newFakeCacher()
+			s, _ := newTestServer(t, db, cache)
+
+			body, err := json.Marshal(BulkRequest{Ids: tc.Ids, Action: BULK_ACTION_DELETE})
+			assert.Nil(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := s.e.NewContext(req, rec)
+			authenticate(c, owner, ROLE_USER)
+
+			s.BulkMessages(c)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var result struct {
+				Results []BulkResult `json:"results"`
+			}
+			assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			assert.Equal(t, tc.ExpectedResults, result.Results)
+
+			// Deleted event is emitted once for every removed message.
+			var deleted []string
+			for _, e := range cache.events {
+				var event struct {
+					Type string       `json:"type"`
+					Data eventMessage `json:"data"`
+				}
+				assert.Nil(t, json.Unmarshal([]byte(e.Payload), &event))
+				assert.Equal(t, WEBHOOK_EVENT_DELETED, event.Type)
+				deleted = append(deleted, event.Data.MessageId)
+			}
+			assert.Equal(t, tc.ExpectedDeleted, deleted)
+		})
+	}
+}
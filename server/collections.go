@@ -0,0 +1,323 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	MAX_COLLECTION_NAME_SIZE = 100
+	MAX_COLLECTION_DEPTH     = 10
+)
+
+var (
+	errCollectionNotFound = errors.New("collection is not found")
+	errCollectionCycle    = errors.New("collection can't be moved into itself")
+	errCollectionDepth    = errors.New("collections are nested too deep")
+)
+
+type InputCollection struct {
+	Name     string                      `json:"name"`
+	ParentId string                      `json:"parent_id"`
+	Defaults database.CollectionDefaults `json:"defaults"`
+}
+
+func (i *InputCollection) isValid() bool {
+	i.Name = strings.TrimSpace(i.Name)
+	if i.Name == "" || len(i.Name) > MAX_COLLECTION_NAME_SIZE {
+		return false
+	}
+
+	switch i.Defaults.EncodingType {
+	case "", "plaintext", "password", "internal", "aes":
+	default:
+		return false
+	}
+
+	return i.Defaults.TTL >= 0 && i.Defaults.TTL <= MAX_TTL
+}
+
+// applyDefaults fills message settings which are not set with the collection
+// defaults. Visibility defaults can only make the message more restricted.
+func applyDefaults(msg *Message, d database.CollectionDefaults) {
+	if msg.EncodingType == "" {
+		msg.EncodingType = d.EncodingType
+	}
+	if msg.TTL == 0 {
+		msg.TTL = d.TTL
+	}
+	msg.IsPrivate = msg.IsPrivate || d.IsPrivate
+	msg.OnlyOwnerView = msg.OnlyOwnerView || d.OnlyOwnerView
+}
+
+// checkParent makes sure that parent collection exists and collection with
+// id is not placed inside itself. Empty id is used for new collections. The
+// moved collection brings its children along, so their depth is counted too.
+func (s *Server) checkParent(ownerId, id, parentId string) error {
+	height := 0
+	if id != "" && parentId != "" {
+		var err error
+		if height, err = s.subtreeHeight(ownerId, id); err != nil {
+			return err
+		}
+	}
+
+	for depth := 1; parentId != ""; depth++ {
+		if parentId == id {
+			return errCollectionCycle
+		}
+		if depth+height > MAX_COLLECTION_DEPTH {
+			return errCollectionDepth
+		}
+
+		parent, err := s.db.GetCollection(ownerId, parentId)
+		if err == mongo.ErrNoDocuments {
+			return errCollectionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		parentId = parent.ParentId
+	}
+
+	return nil
+}
+
+// subtreeHeight returns how many levels of collections are nested in the
+// collection with id.
+func (s *Server) subtreeHeight(ownerId, id string) (int, error) {
+	colls, err := s.db.GetCollections(ownerId)
+	if err != nil {
+		return 0, err
+	}
+
+	children := map[string][]string{}
+	for _, coll := range colls {
+		children[coll.ParentId] = append(children[coll.ParentId], coll.Id.Hex())
+	}
+
+	height := 0
+	level := children[id]
+	for len(level) > 0 && height <= MAX_COLLECTION_DEPTH {
+		height++
+		next := []string{}
+		for _, child := range level {
+			next = append(next, children[child]...)
+		}
+		level = next
+	}
+
+	return height, nil
+}
+
+// checkCollection returns error if collection id is set and the owner
+// doesn't have such collection.
+func checkCollection(db database.Storager, ownerId, id string) (database.CollectionOut, error) {
+	if id == "" {
+		return database.CollectionOut{}, nil
+	}
+
+	coll, err := db.GetCollection(ownerId, id)
+	if err == mongo.ErrNoDocuments {
+		return database.CollectionOut{}, errCollectionNotFound
+	}
+
+	return coll, err
+}
+
+func (s *Server) CreateCollection(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputCollection)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !input.isValid() {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	err = s.checkParent(userId, "", input.ParentId)
+	if err == errCollectionNotFound || err == errCollectionDepth {
+		return c.JSON(http.StatusBadRequest, resp(err.Error()))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	now := time.Now().UTC()
+	id, err := s.db.AddCollection(&database.Collection{
+		OwnerId:   userId,
+		ParentId:  input.ParentId,
+		Name:      input.Name,
+		Defaults:  input.Defaults,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err == database.ErrAlreadyExist {
+		return c.JSON(http.StatusConflict, resp("collection with this name already exists"))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{"id": id})
+}
+
+func (s *Server) GetCollectionsList(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	colls, err := s.db.GetCollections(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, colls)
+}
+
+// GetCollectionMessages returns the collection with its child collections
+// and messages.
+func (s *Server) GetCollectionMessages(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	coll, err := s.db.GetCollection(userId, c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	colls, err := s.db.GetCollections(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	children := make([]database.CollectionOut, 0)
+	for _, child := range colls {
+		if child.ParentId == coll.Id.Hex() {
+			children = append(children, child)
+		}
+	}
+
+	messages, err := s.db.GetUserMessages(userId,
+		database.MessageFilter{CollectionId: coll.Id.Hex()})
+	if err != nil && err != mongo.ErrNoDocuments {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	messages = filterAuthorized(subjectFromContext(c), actionList, messages)
+	for i := range messages {
+		if err = openTitle(&messages[i]); err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"collection":  coll,
+		"collections": children,
+		"messages":    messages,
+	})
+}
+
+// UpdateCollection renames the collection, moves it to another parent and
+// changes its defaults.
+func (s *Server) UpdateCollection(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	collId := c.Param("id")
+
+	input := new(InputCollection)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !input.isValid() {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if _, err = s.db.GetCollection(userId, collId); err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	} else if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	err = s.checkParent(userId, collId, input.ParentId)
+	if err == errCollectionNotFound || err == errCollectionCycle ||
+		err == errCollectionDepth {
+		return c.JSON(http.StatusBadRequest, resp(err.Error()))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	err = s.db.UpdateCollection(userId, collId, &database.Collection{
+		ParentId:  input.ParentId,
+		Name:      input.Name,
+		Defaults:  input.Defaults,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err == database.ErrAlreadyExist {
+		return c.JSON(http.StatusConflict, resp("collection with this name already exists"))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+// DeleteCollection removes the collection, its content is moved to the
+// parent collection.
+func (s *Server) DeleteCollection(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	err = s.db.DeleteCollection(userId, c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err == database.ErrAlreadyExist {
+		return c.JSON(http.StatusConflict,
+			resp("parent collection already has child with the same name"))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	defaults := database.CollectionDefaults{
+		EncodingType:  "internal",
+		TTL:           3600,
+		OnlyOwnerView: true,
+	}
+
+	msg := Message{Content: "some secret content"}
+	applyDefaults(&msg, defaults)
+	assert.Equal(t, "internal", msg.EncodingType)
+	assert.Equal(t, 3600, msg.TTL)
+	assert.True(t, msg.OnlyOwnerView)
+	assert.False(t, msg.IsPrivate)
+
+	msg = Message{Content: "some secret content", EncodingType: "plaintext", TTL: 60,
+		IsPrivate: true}
+	applyDefaults(&msg, defaults)
+	assert.Equal(t, "plaintext", msg.EncodingType)
+	assert.Equal(t, 60, msg.TTL)
+	assert.True(t, msg.IsPrivate)
+}
+
+func TestInputCollectionIsValid(t *testing.T) {
+	input := InputCollection{Name: "  runbooks "}
+	assert.True(t, input.isValid())
+	assert.Equal(t, "runbooks", input.Name)
+
+	assert.False(t, (&InputCollection{Name: " "}).isValid())
+	assert.False(t, (&InputCollection{Name: "a",
+		Defaults: database.CollectionDefaults{EncodingType: "rot13"}}).isValid())
+	assert.False(t, (&InputCollection{Name: "a",
+		Defaults: database.CollectionDefaults{TTL: -1}}).isValid())
+}
+
+type TestCaseCheckParent struct {
+	Name        string
+	Id          string
+	ParentId    string
+	ExpectedErr error
+}
+
+func TestCheckParent(t *testing.T) {
+	const owner = "owner"
+
+	// chain[0] is the top level collection, chain[i] is nested into
+	// chain[i-1]. The tree holds two levels.
+	chain := make([]database.CollectionOut, MAX_COLLECTION_DEPTH)
+	for i := range chain {
+		chain[i] = database.CollectionOut{Id: primitive.NewObjectID(), OwnerId: owner}
+		if i > 0 {
+			chain[i].ParentId = chain[i-1].Id.Hex()
+		}
+	}
+	tree := database.CollectionOut{Id: primitive.NewObjectID(), OwnerId: owner}
+	leaf := database.CollectionOut{Id: primitive.NewObjectID(), OwnerId: owner,
+		ParentId: tree.Id.Hex()}
+
+	db := newFakeStorager()
+	db.colls = append(append(db.colls, chain...), tree, leaf)
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	id := func(c database.CollectionOut) string { return c.Id.Hex() }
+	last := len(chain) - 1
+	cases := []TestCaseCheckParent{
+		{"new top level collection", "", "", nil},
+		{"new collection in the deepest one", "", id(chain[last]), nil},
+		{"unknown parent", "", primitive.NewObjectID().Hex(), errCollectionNotFound},
+		{"into itself", id(tree), id(tree), errCollectionCycle},
+		{"into own child", id(tree), id(leaf), errCollectionCycle},
+		{"leaf into the deepest one", id(leaf), id(chain[last]), nil},
+		{"tree into the deepest one", id(tree), id(chain[last]), errCollectionDepth},
+		{"tree into the one above the deepest", id(tree), id(chain[last-1]), nil},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.ExpectedErr, s.checkParent(owner, tc.Id, tc.ParentId), tc.Name)
+	}
+}
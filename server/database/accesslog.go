@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ACCESS_LOG_RETENTION is how long access log entries are kept, Mongo
+// removes older entries by TTL index.
+const ACCESS_LOG_RETENTION = 90 * 24 * time.Hour
+
+var accessLogIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "message_id", Value: 1}, {Key: "at", Value: -1}},
+	},
+	{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "public_id", Value: 1}, {Key: "at", Value: -1}},
+	},
+	{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ACCESS_LOG_RETENTION.Seconds())),
+	},
+}
+
+func (d MainDB) AddAccessLogEntry(e *AccessLogEntry) error {
+	ctx := context.Background()
+	_, err := d.accessCol.InsertOne(ctx, e)
+	return err
+}
+
+func (d MainDB) GetAccessLog(ownerId, messageId string, skip, limit int) ([]AccessLogEntryOut, int64, error) {
+	ctx := context.Background()
+	filter := bson.D{
+		{Key: "owner_id", Value: ownerId},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "message_id", Value: messageId}},
+			bson.D{{Key: "public_id", Value: messageId}},
+		}},
+	}
+
+	total, err := d.accessCol.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).
+		SetSkip(int64(skip)).SetLimit(int64(limit))
+	cursor, err := d.accessCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]AccessLogEntryOut, 0)
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+func (d MainDB) DeleteUserAccessLog(ownerId string) error {
+	ctx := context.Background()
+	_, err := d.accessCol.DeleteMany(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	return err
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (d MainDB) AddAPIKey(k *APIKey) (string, error) {
+	ctx := context.Background()
+	result, err := d.apiKeysCol.InsertOne(ctx, k)
+	if err != nil {
+		return "", err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("can't convert inserted id primitive")
+	}
+
+	return id.Hex(), nil
+}
+
+func (d MainDB) GetAPIKey(hashedKey string) (APIKeyOut, error) {
+	k := APIKeyOut{}
+
+	ctx := context.Background()
+	err := d.apiKeysCol.FindOne(ctx, bson.D{{Key: "hashed_key", Value: hashedKey}}).Decode(&k)
+	if err != nil {
+		return APIKeyOut{}, err
+	}
+
+	return k, nil
+}
+
+func (d MainDB) GetUserAPIKeys(ownerId string) ([]APIKeyOut, error) {
+	ctx := context.Background()
+	cursor, err := d.apiKeysCol.Find(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKeyOut, 0)
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (d MainDB) TouchAPIKey(id string, at time.Time) error {
+	keyId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = d.apiKeysCol.UpdateOne(ctx, bson.D{{Key: "_id", Value: keyId}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "last_used_at", Value: at}}}})
+	return err
+}
+
+func (d MainDB) DeleteAPIKey(ownerId, id string) error {
+	keyId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	ctx := context.Background()
+	res, err := d.apiKeysCol.DeleteOne(ctx,
+		bson.D{{Key: "_id", Value: keyId}, {Key: "owner_id", Value: ownerId}})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (d MainDB) DeleteUserAPIKeys(ownerId string) error {
+	ctx := context.Background()
+	_, err := d.apiKeysCol.DeleteMany(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	return err
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const BULK_BATCH_SIZE = 100
+
+// GetMessagesByIds returns messages by internal or public ids.
+func (d MainDB) GetMessagesByIds(ids []string) (MessagesOut, error) {
+	msgIds := make(bson.A, 0, len(ids))
+	publicIds := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		if msgId, err := primitive.ObjectIDFromHex(id); err == nil {
+			msgIds = append(msgIds, msgId)
+		} else if id != "" {
+			publicIds = append(publicIds, id)
+		}
+	}
+
+	messages := make(MessagesOut, 0)
+	if len(msgIds) == 0 && len(publicIds) == 0 {
+		return messages, nil
+	}
+
+	ctx := context.Background()
+	cursor, err := d.messagesCol.Find(ctx, bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: msgIds}}}},
+		bson.D{{Key: "public_id", Value: bson.D{{Key: "$in", Value: publicIds}}}},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func bulkModel(ownerId string, op BulkMessageOp) (mongo.WriteModel, error) {
+	msgId, err := primitive.ObjectIDFromHex(op.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	set, unset := patchFields(op.Patch)
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}}}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if unset != nil {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	filter := bson.D{{Key: "_id", Value: msgId}, {Key: "owner_id", Value: ownerId}}
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), nil
+}
+
+// bulkDelete removes the message of the owner, it returns
+// mongo.ErrNoDocuments when the message is already removed.
+func (d MainDB) bulkDelete(ctx context.Context, ownerId string, op BulkMessageOp) error {
+	msgId, err := primitive.ObjectIDFromHex(op.Id)
+	if err != nil {
+		return err
+	}
+
+	res, err := d.messagesCol.DeleteOne(ctx,
+		bson.D{{Key: "_id", Value: msgId}, {Key: "owner_id", Value: ownerId}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// BulkMessages runs operations on messages of the owner. Updates are sent
+// in unordered batches, deletes one by one, so a message removed
+// concurrently is reported. Returned map holds errors of failed operations
+// by their index, mongo.ErrNoDocuments for the deletes of missing messages.
+// err is set only if the whole batch failed.
+func (d MainDB) BulkMessages(ownerId string, ops []BulkMessageOp) (map[int]error, error) {
+	failed := make(map[int]error)
+	deleted := make(bson.A, 0)
+	updates := make([]int, 0, len(ops))
+
+	ctx := context.Background()
+	for i, op := range ops {
+		if !op.Delete {
+			updates = append(updates, i)
+			continue
+		}
+		if err := d.bulkDelete(ctx, ownerId, op); err != nil {
+			failed[i] = err
+			continue
+		}
+		deleted = append(deleted, op.Id)
+	}
+
+	opts := options.BulkWrite().SetOrdered(false)
+	for start := 0; start < len(updates); start += BULK_BATCH_SIZE {
+		end := min(start+BULK_BATCH_SIZE, len(updates))
+
+		models := make([]mongo.WriteModel, 0, end-start)
+		indexes := make([]int, 0, end-start)
+		for _, i := range updates[start:end] {
+			model, err := bulkModel(ownerId, ops[i])
+			if err != nil {
+				failed[i] = err
+				continue
+			}
+			models = append(models, model)
+			indexes = append(indexes, i)
+		}
+
+		if len(models) == 0 {
+			continue
+		}
+
+		_, err := d.messagesCol.BulkWrite(ctx, models, opts)
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, we := range bulkErr.WriteErrors {
+				failed[indexes[we.Index]] = we
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(deleted) > 0 {
+		err := d.deleteMessagesData(ctx,
+			bson.D{{Key: "message_id", Value: bson.D{{Key: "$in", Value: deleted}}}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (d MainDB) AddCollection(c *Collection) (string, error) {
+	ctx := context.Background()
+	result, err := d.collsCol.InsertOne(ctx, c)
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrAlreadyExist
+	}
+	if err != nil {
+		return "", err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("can't convert inserted id primitive")
+	}
+
+	return id.Hex(), nil
+}
+
+func (d MainDB) GetCollection(ownerId, id string) (CollectionOut, error) {
+	collId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return CollectionOut{}, mongo.ErrNoDocuments
+	}
+
+	c := CollectionOut{}
+
+	ctx := context.Background()
+	err = d.collsCol.FindOne(ctx,
+		bson.D{{Key: "_id", Value: collId}, {Key: "owner_id", Value: ownerId}}).Decode(&c)
+	if err != nil {
+		return CollectionOut{}, err
+	}
+
+	return c, nil
+}
+
+func (d MainDB) GetCollections(ownerId string) ([]CollectionOut, error) {
+	ctx := context.Background()
+	cursor, err := d.collsCol.Find(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	if err != nil {
+		return nil, err
+	}
+
+	colls := make([]CollectionOut, 0)
+	if err = cursor.All(ctx, &colls); err != nil {
+		return nil, err
+	}
+
+	return colls, nil
+}
+
+func (d MainDB) UpdateCollection(ownerId, id string, c *Collection) error {
+	collId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	ctx := context.Background()
+	res, err := d.collsCol.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: collId}, {Key: "owner_id", Value: ownerId}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "parent_id", Value: c.ParentId},
+			{Key: "name", Value: c.Name},
+			{Key: "defaults", Value: c.Defaults},
+			{Key: "updated_at", Value: c.UpdatedAt},
+		}}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExist
+	}
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// DeleteCollection removes the collection and moves its messages and child
+// collections to the parent of removed one. Names of the children are
+// checked against the new siblings before anything is changed, so the
+// conflict doesn't leave the collection half deleted.
+func (d MainDB) DeleteCollection(ownerId, id string) error {
+	c, err := d.GetCollection(ownerId, id)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	children := []CollectionOut{}
+	cursor, err := d.collsCol.Find(ctx,
+		bson.D{{Key: "owner_id", Value: ownerId}, {Key: "parent_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if err = cursor.All(ctx, &children); err != nil {
+		return err
+	}
+
+	if len(children) > 0 {
+		names := bson.A{}
+		for _, child := range children {
+			names = append(names, child.Name)
+		}
+
+		// The removed collection itself is not a sibling of its children
+		// after removal.
+		conflicts, err := d.collsCol.CountDocuments(ctx, bson.D{
+			{Key: "owner_id", Value: ownerId},
+			{Key: "parent_id", Value: c.ParentId},
+			{Key: "name", Value: bson.D{{Key: "$in", Value: names}}},
+			{Key: "_id", Value: bson.D{{Key: "$ne", Value: c.Id}}},
+		})
+		if err != nil {
+			return err
+		}
+		if conflicts > 0 {
+			return ErrAlreadyExist
+		}
+	}
+
+	_, err = d.messagesCol.UpdateMany(ctx,
+		bson.D{{Key: "owner_id", Value: ownerId}, {Key: "collection_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "collection_id", Value: c.ParentId}}}})
+	if err != nil {
+		return err
+	}
+
+	// The collection is removed before its children are moved, otherwise
+	// a child with the same name conflicts with it.
+	if _, err = d.collsCol.DeleteOne(ctx, bson.D{{Key: "_id", Value: c.Id}}); err != nil {
+		return err
+	}
+
+	_, err = d.collsCol.UpdateMany(ctx,
+		bson.D{{Key: "owner_id", Value: ownerId}, {Key: "parent_id", Value: id}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "parent_id", Value: c.ParentId}}}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExist
+	}
+	return err
+}
+
+func (d MainDB) DeleteUserCollections(ownerId string) error {
+	ctx := context.Background()
+	_, err := d.collsCol.DeleteMany(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	return err
+}
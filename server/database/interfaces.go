@@ -2,50 +2,154 @@ package database
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	ErrTokenNotFound = errors.New("token is not found or expired")
+	ErrAlreadyExist  = errors.New("already exist")
+	// ErrVersionConflict is returned when a message was changed after the
+	// version used for the update was read.
+	ErrVersionConflict = errors.New("message version conflict")
+)
+
 type cachedUser struct {
 	Username       string `redis:"username"`
+	Email          string `redis:"email"`
 	HashedPassword string `redis:"password"`
 }
 
 type User struct {
 	Username string `json:"username"`
+	Email    string `json:"email" bson:"email"`
 	Password string `json:"password"`
+
+	OIDCIssuer  string `json:"-" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
+
+	Role string `json:"-" bson:"role,omitempty"`
+}
+
+type LoginInfo struct {
+	IP        string    `json:"ip" bson:"ip"`
+	UserAgent string    `json:"user_agent" bson:"user_agent"`
+	At        time.Time `json:"at" bson:"at"`
 }
 
 type UserOut struct {
-	Id       primitive.ObjectID `bson:"_id"`
-	Username string             `json:"username"`
-	Password string             `json:"password"`
+	Id        primitive.ObjectID `bson:"_id"`
+	Username  string             `json:"username"`
+	Email     string             `json:"email" bson:"email"`
+	Password  string             `json:"password"`
+	Role      string             `json:"role" bson:"role"`
+	Disabled  bool               `json:"disabled" bson:"disabled"`
+	LastLogin LoginInfo          `json:"last_login" bson:"last_login"`
+
+	OIDCIssuer  string `json:"-" bson:"oidc_issuer,omitempty"`
+	OIDCSubject string `json:"-" bson:"oidc_subject,omitempty"`
+
+	TOTPSecret    string   `json:"-" bson:"totp_secret"`
+	TOTPEnabled   bool     `json:"totp_enabled" bson:"totp_enabled"`
+	RecoveryCodes []string `json:"-" bson:"recovery_codes"`
+	// TOTPLastStep is the time step of the last accepted code.
+	TOTPLastStep int64 `json:"-" bson:"totp_last_step"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" bson:"deletion_scheduled_at,omitempty"`
 }
 
 type UsersDB interface {
 	AddUser(u *User) error
 	GetUser(username string) (UserOut, error)
+	GetUserById(id string) (UserOut, error)
+	GetUserByOIDCSubject(issuer, subject string) (UserOut, error)
+	UpdateLastLogin(userId string, l LoginInfo) error
+	UpdatePassword(userId string, hashedPassword string) error
+	SetTOTP(userId string, secret string, enabled bool) error
+	SetRecoveryCodes(userId string, hashedCodes []string) error
+	UseRecoveryCode(userId string, hashedCode string) (bool, error)
+	UseTOTPStep(userId string, step int64) (bool, error)
+	ScheduleUserDeletion(userId string, at time.Time) error
+	CancelUserDeletion(userId string) error
+	GetUsersToDelete(before time.Time) ([]UserOut, error)
+	DeleteUser(userId string) error
+	GetUsers(skip, limit int) (users []UserOut, total int64, err error)
+	SetUserRole(userId string, role string) error
+	SetUserDisabled(userId string, disabled bool) error
 }
 
 type Cacher interface {
-	AddUser(username string, hashedPassword string) (token string, err error)
+	AddUser(username, email, hashedPassword string, ttl time.Duration) (token string, err error)
 	GetUser(token string) (*User, error)
+	DeletePendingUser(token, username string) error
+	AddFailedLogin(username string, lockout time.Duration) (attempts int64, err error)
+	GetFailedLogins(username string) (int64, error)
+	ResetFailedLogins(username string) error
+	AddShareNotification(userId string, window time.Duration) (count int64, err error)
+	AddAccessFailure(messageId, ip string, window time.Duration) (count int64, err error)
+	AddLoginChallenge(userId string, ttl time.Duration) (token string, err error)
+	GetLoginChallenge(token string) (userId string, err error)
+	AddEventsTicket(userId string, ttl time.Duration) (ticket string, err error)
+	GetEventsTicket(ticket string) (userId string, err error)
+	AddPasswordResetToken(userId string, ttl time.Duration) (token string, err error)
+	GetPasswordResetToken(token string) (userId string, err error)
+	RevokeTokens(userId string, at time.Time) error
+	GetTokensRevokedAt(userId string) (time.Time, error)
+	AddOIDCState(verifier, nonce string, ttl time.Duration) (state string, err error)
+	GetOIDCState(state string) (verifier, nonce string, err error)
+	EnqueueWebhookJob(j WebhookJob, at time.Time) error
+	ClaimWebhookJobs(now time.Time, limit int, timeout time.Duration) ([]WebhookJob, error)
+	AckWebhookJob(j WebhookJob) error
+	PublishUserEvent(userId string, event string) error
+	SubscribeUserEvents(ctx context.Context) (Subscription, error)
 	Shutdown(context.Context) error
 }
 
+// UserEvent is an event published for the user.
+type UserEvent struct {
+	UserId  string
+	Payload string
+}
+
+// Subscription receives events published for every user until it's closed.
+type Subscription interface {
+	Events() <-chan UserEvent
+	Close() error
+}
+
 type Message struct {
-	OwnerId       string `json:"owner_id" bson:"owner_id"`
-	Content       string `json:"content" bson:"content"`
-	IsPrivate     bool   `json:"is_private" bson:"is_private"`
-	EncodingType  string `json:"encoding_type" bson:"encoding_type"`
-	Password      string `json:"password" bson:"password"`
-	OnlyOwnerView bool   `json:"only_owner_view" bson:"only_owner_view"`
-	IsAnon        bool   `json:"is_anon" bson:"is_anon"`
-	IsOneTime     bool   `json:"is_one_time" bson:"is_one_time"`
+	// Id is set only to keep message id on import, otherwise it's generated.
+	Id            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	OwnerId       string             `json:"owner_id" bson:"owner_id"`
+	Content       string             `json:"content" bson:"content"`
+	IsPrivate     bool               `json:"is_private" bson:"is_private"`
+	EncodingType  string             `json:"encoding_type" bson:"encoding_type"`
+	Password      string             `json:"password" bson:"password"`
+	OnlyOwnerView bool               `json:"only_owner_view" bson:"only_owner_view"`
+	IsAnon        bool               `json:"is_anon" bson:"is_anon"`
+	IsOneTime     bool               `json:"is_one_time" bson:"is_one_time"`
+	Version       int64              `json:"version" bson:"version"`
+	// PublicId is random id used in links, it's generated on creation and
+	// never changed by updates.
+	PublicId string `json:"public_id" bson:"public_id,omitempty"`
+
+	Title          string   `json:"title" bson:"title"`
+	TitleEncrypted bool     `json:"title_encrypted" bson:"title_encrypted"`
+	Tags           []string `json:"tags" bson:"tags"`
+	CollectionId   string   `json:"collection_id" bson:"collection_id"`
+
+	CreatedAt      time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" bson:"updated_at"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty" bson:"last_accessed_at,omitempty"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
 }
 
 type MessageOut struct {
 	Id            primitive.ObjectID `json:"id" bson:"_id"`
+	PublicId      string             `json:"public_id" bson:"public_id"`
 	OwnerId       string             `json:"owner_id,omitempty" bson:"owner_id"`
 	Content       string             `json:"content" bson:"content"`
 	IsPrivate     bool               `json:"is_private" bson:"is_private"`
@@ -54,21 +158,343 @@ type MessageOut struct {
 	OnlyOwnerView bool               `json:"only_owner_view" bson:"only_owner_view"`
 	IsAnon        bool               `json:"is_anon" bson:"is_anon"`
 	IsOneTime     bool               `json:"is_one_time" bson:"is_one_time"`
+	Version       int64              `json:"version" bson:"version"`
+
+	Title          string   `json:"title,omitempty" bson:"title"`
+	TitleEncrypted bool     `json:"title_encrypted,omitempty" bson:"title_encrypted"`
+	Tags           []string `json:"tags,omitempty" bson:"tags"`
+	CollectionId   string   `json:"collection_id,omitempty" bson:"collection_id"`
+
+	CreatedAt      time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" bson:"updated_at"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty" bson:"last_accessed_at,omitempty"`
+
+	// Read receipts count successful reads by other users, they are changed
+	// by CountMessageRead only.
+	ReadCount  int64      `json:"read_count" bson:"read_count"`
+	LastReadAt *time.Time `json:"last_read_at,omitempty" bson:"last_read_at,omitempty"`
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+
+	// Score is a relevance of the message in search results.
+	Score float64 `json:"score,omitempty" bson:"score,omitempty"`
 }
 
 type MessagesOut []MessageOut
 
+// MessagePatch holds message metadata changes, nil fields are left as is.
+type MessagePatch struct {
+	OnlyOwnerView *bool
+	IsPrivate     *bool
+	IsAnon        *bool
+	IsOneTime     *bool
+
+	Title          *string
+	TitleEncrypted *bool
+	Tags           *[]string
+	CollectionId   *string
+
+	ExpiresAt      *time.Time
+	ClearExpiresAt bool
+
+	UpdatedAt time.Time
+}
+
+// SearchQuery is a full-text search over public plaintext messages and
+// messages of the owner.
+type SearchQuery struct {
+	Text    string
+	OwnerId string
+	Skip    int
+	Limit   int
+}
+
+// MessageFilter narrows down list of user messages, empty fields match all.
+type MessageFilter struct {
+	Tag           string
+	CollectionId  string
+	EncodingType  string
+	CreatedBefore time.Time
+}
+
+// BulkMessageOp deletes the message or applies the patch to it. Bulk
+// operations don't check message version.
+type BulkMessageOp struct {
+	Id     string
+	Delete bool
+	Patch  MessagePatch
+}
+
 type MessagesDB interface {
 	AddMessage(m *Message) (id string, err error)
 	GetMessage(id string) (MessageOut, error)
+	GetMessageByPublicId(publicId string) (MessageOut, error)
 	GetLastPublicMessages(limit int) (MessagesOut, error)
-	GetUserMessages(ownerId string) (MessagesOut, error)
-	UpdateMessage(id string, m *Message) error
+	GetUserMessages(ownerId string, f MessageFilter) (MessagesOut, error)
+	SearchMessages(q SearchQuery) (messages MessagesOut, total int64, err error)
+	UpdateMessage(id string, version int64, m *Message) error
+	PatchMessage(id string, version int64, p MessagePatch) error
+	TouchMessage(id string, at time.Time) error
+	CountMessageRead(id string, at time.Time) error
+	GetMessagesByIds(ids []string) (MessagesOut, error)
+	BulkMessages(ownerId string, ops []BulkMessageOp) (failed map[int]error, err error)
 	DeleteMessage(id string) error
+	DeleteUserMessages(ownerId string) (deleted int64, err error)
+	DeleteExpiredMessages(now time.Time) (deleted MessagesOut, err error)
+
+	AddCollection(c *Collection) (id string, err error)
+	GetCollection(ownerId, id string) (CollectionOut, error)
+	GetCollections(ownerId string) ([]CollectionOut, error)
+	UpdateCollection(ownerId, id string, c *Collection) error
+	DeleteCollection(ownerId, id string) error
+	DeleteUserCollections(ownerId string) error
+}
+
+// CollectionDefaults are applied to new messages created in the collection.
+type CollectionDefaults struct {
+	EncodingType  string `json:"encoding_type,omitempty" bson:"encoding_type,omitempty"`
+	TTL           int    `json:"ttl,omitempty" bson:"ttl,omitempty"`
+	IsPrivate     bool   `json:"is_private,omitempty" bson:"is_private,omitempty"`
+	OnlyOwnerView bool   `json:"only_owner_view,omitempty" bson:"only_owner_view,omitempty"`
+}
+
+// Collection is a folder of messages, collections without parent are on the
+// top level.
+type Collection struct {
+	OwnerId   string             `bson:"owner_id"`
+	ParentId  string             `bson:"parent_id"`
+	Name      string             `bson:"name"`
+	Defaults  CollectionDefaults `bson:"defaults"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+type CollectionOut struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerId   string             `json:"-" bson:"owner_id"`
+	ParentId  string             `json:"parent_id,omitempty" bson:"parent_id"`
+	Name      string             `json:"name" bson:"name"`
+	Defaults  CollectionDefaults `json:"defaults" bson:"defaults"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type APIKey struct {
+	OwnerId   string    `bson:"owner_id"`
+	Name      string    `bson:"name"`
+	Prefix    string    `bson:"prefix"`
+	HashedKey string    `bson:"hashed_key"`
+	Scopes    []string  `bson:"scopes"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type APIKeyOut struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerId    string             `json:"-" bson:"owner_id"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	HashedKey  string             `json:"-" bson:"hashed_key"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+type APIKeysDB interface {
+	AddAPIKey(k *APIKey) (id string, err error)
+	GetAPIKey(hashedKey string) (APIKeyOut, error)
+	GetUserAPIKeys(ownerId string) ([]APIKeyOut, error)
+	TouchAPIKey(id string, at time.Time) error
+	DeleteAPIKey(ownerId, id string) error
+	DeleteUserAPIKeys(ownerId string) error
+}
+
+// Revision is a previous state of message content stored in the same
+// encoded form as the message itself.
+type Revision struct {
+	MessageId    string    `bson:"message_id"`
+	OwnerId      string    `bson:"owner_id"`
+	Number       int       `bson:"number"`
+	Content      string    `bson:"content"`
+	EncodingType string    `bson:"encoding_type"`
+	Password     string    `bson:"password"`
+	IsPrivate    bool      `bson:"is_private"`
+	CreatedAt    time.Time `bson:"created_at"`
+}
+
+type RevisionOut struct {
+	Id           primitive.ObjectID `json:"-" bson:"_id"`
+	MessageId    string             `json:"message_id" bson:"message_id"`
+	OwnerId      string             `json:"-" bson:"owner_id"`
+	Number       int                `json:"number" bson:"number"`
+	Content      string             `json:"-" bson:"content"`
+	EncodingType string             `json:"encoding_type" bson:"encoding_type"`
+	Password     string             `json:"-" bson:"password"`
+	IsPrivate    bool               `json:"is_private" bson:"is_private"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type RevisionsDB interface {
+	AddRevision(r *Revision) (number int, err error)
+	GetRevisions(messageId string) ([]RevisionOut, error)
+	GetRevision(messageId string, number int) (RevisionOut, error)
+	PruneRevisions(messageId string, keep int) error
+}
+
+// ShareLink gives access to the message by a random token. Only the hash of
+// the token is stored.
+type ShareLink struct {
+	OwnerId        string    `bson:"owner_id"`
+	MessageId      string    `bson:"message_id"`
+	HashedToken    string    `bson:"hashed_token"`
+	HashedPassword string    `bson:"hashed_password,omitempty"`
+	MaxViews       int       `bson:"max_views"`
+	Views          int       `bson:"views"`
+	CreatedAt      time.Time `bson:"created_at"`
+	ExpiresAt      time.Time `bson:"expires_at"`
+}
+
+type ShareLinkOut struct {
+	Id             primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerId        string             `json:"-" bson:"owner_id"`
+	MessageId      string             `json:"message_id" bson:"message_id"`
+	HashedToken    string             `json:"-" bson:"hashed_token"`
+	HashedPassword string             `json:"-" bson:"hashed_password,omitempty"`
+	HasPassword    bool               `json:"has_password" bson:"-"`
+	// MaxViews is zero for links without view limit.
+	MaxViews  int       `json:"max_views" bson:"max_views"`
+	Views     int       `json:"views" bson:"views"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+type SharesDB interface {
+	AddShareLink(l *ShareLink) (id string, err error)
+	GetShareLink(hashedToken string) (ShareLinkOut, error)
+	GetShareLinks(messageId string) ([]ShareLinkOut, error)
+	UseShareLink(id string, now time.Time) error
+	DeleteShareLink(ownerId, messageId, id string) error
+}
+
+// AccessLogEntry is an attempt to read the message by other user. Entries are
+// kept after the message is deleted, so owner can see that one-time message
+// was read.
+type AccessLogEntry struct {
+	MessageId string    `bson:"message_id"`
+	PublicId  string    `bson:"public_id"`
+	OwnerId   string    `bson:"owner_id"`
+	At        time.Time `bson:"at"`
+	IP        string    `bson:"ip"`
+	UserAgent string    `bson:"user_agent"`
+	Outcome   string    `bson:"outcome"`
+}
+
+type AccessLogEntryOut struct {
+	Id        primitive.ObjectID `json:"-" bson:"_id"`
+	MessageId string             `json:"message_id" bson:"message_id"`
+	PublicId  string             `json:"public_id" bson:"public_id"`
+	OwnerId   string             `json:"-" bson:"owner_id"`
+	At        time.Time          `json:"at" bson:"at"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"user_agent" bson:"user_agent"`
+	Outcome   string             `json:"outcome" bson:"outcome"`
+}
+
+type AccessLogDB interface {
+	AddAccessLogEntry(e *AccessLogEntry) error
+	// GetAccessLog returns entries of the owner message by its internal or
+	// public id, newest first.
+	GetAccessLog(ownerId, messageId string, skip, limit int) (entries []AccessLogEntryOut, total int64, err error)
+	DeleteUserAccessLog(ownerId string) error
+}
+
+// Webhook is a subscription of the user to message events.
+type Webhook struct {
+	OwnerId string   `bson:"owner_id"`
+	URL     string   `bson:"url"`
+	Events  []string `bson:"events"`
+	// Secret signs payloads, it's encrypted with internal key.
+	Secret    string    `bson:"secret"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+type WebhookOut struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
+	OwnerId   string             `json:"-" bson:"owner_id"`
+	URL       string             `json:"url" bson:"url"`
+	Events    []string           `json:"events" bson:"events"`
+	Secret    string             `json:"-" bson:"secret"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// WebhookDelivery is a result of one attempt to deliver event.
+type WebhookDelivery struct {
+	WebhookId  string    `bson:"webhook_id"`
+	OwnerId    string    `bson:"owner_id"`
+	DeliveryId string    `bson:"delivery_id"`
+	Event      string    `bson:"event"`
+	Attempt    int       `bson:"attempt"`
+	StatusCode int       `bson:"status_code"`
+	Error      string    `bson:"error,omitempty"`
+	Success    bool      `bson:"success"`
+	At         time.Time `bson:"at"`
+}
+
+type WebhookDeliveryOut struct {
+	Id         primitive.ObjectID `json:"-" bson:"_id"`
+	WebhookId  string             `json:"webhook_id" bson:"webhook_id"`
+	OwnerId    string             `json:"-" bson:"owner_id"`
+	DeliveryId string             `json:"delivery_id" bson:"delivery_id"`
+	Event      string             `json:"event" bson:"event"`
+	Attempt    int                `json:"attempt" bson:"attempt"`
+	StatusCode int                `json:"status_code,omitempty" bson:"status_code"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	Success    bool               `json:"success" bson:"success"`
+	At         time.Time          `json:"at" bson:"at"`
+}
+
+// WebhookJob is an event waiting for delivery in the queue. Payload is kept
+// as is, so every attempt sends the same body.
+type WebhookJob struct {
+	DeliveryId string `json:"delivery_id"`
+	WebhookId  string `json:"webhook_id"`
+	OwnerId    string `json:"owner_id"`
+	Event      string `json:"event"`
+	Payload    string `json:"payload"`
+	Attempt    int    `json:"attempt"`
+
+	// claim is the job as it's kept in the queue, set when the job is
+	// claimed.
+	claim string
+}
+
+type WebhooksDB interface {
+	AddWebhook(w *Webhook) (id string, err error)
+	GetWebhook(ownerId, id string) (WebhookOut, error)
+	GetWebhooks(ownerId string) ([]WebhookOut, error)
+	GetEventWebhooks(ownerId, event string) ([]WebhookOut, error)
+	DeleteWebhook(ownerId, id string) error
+	DeleteUserWebhooks(ownerId string) error
+	AddWebhookDelivery(d *WebhookDelivery) error
+	GetWebhookDeliveries(ownerId, webhookId string, skip, limit int) (deliveries []WebhookDeliveryOut, total int64, err error)
+}
+
+type Stats struct {
+	Users              int64            `json:"users"`
+	DisabledUsers      int64            `json:"disabled_users"`
+	Messages           int64            `json:"messages"`
+	PublicMessages     int64            `json:"public_messages"`
+	MessagesByEncoding map[string]int64 `json:"messages_by_encoding"`
+	APIKeys            int64            `json:"api_keys"`
 }
 
 type Storager interface {
 	UsersDB
 	MessagesDB
+	APIKeysDB
+	RevisionsDB
+	SharesDB
+	AccessLogDB
+	WebhooksDB
+	GetStats() (Stats, error)
 	Shutdown(context.Context) error
 }
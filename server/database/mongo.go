@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/arimatakao/deepenc/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,10 +13,21 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// PUBLIC_ID_SIZE is the size of random public message id in bytes.
+const PUBLIC_ID_SIZE = 16
+
 type MainDB struct {
 	client      *mongo.Client
 	usersCol    *mongo.Collection
 	messagesCol *mongo.Collection
+	apiKeysCol  *mongo.Collection
+	revsCol     *mongo.Collection
+	collsCol    *mongo.Collection
+	sharesCol   *mongo.Collection
+	accessCol   *mongo.Collection
+
+	webhooksCol   *mongo.Collection
+	deliveriesCol *mongo.Collection
 }
 
 func NewMainDB(connectionUrl string) (*MainDB, error) {
@@ -33,25 +45,183 @@ func NewMainDB(connectionUrl string) (*MainDB, error) {
 	database := clientdb.Database("deepenc")
 	usersCol := database.Collection("Users")
 	messagesCol := database.Collection("Messages")
+	apiKeysCol := database.Collection("APIKeys")
+	revsCol := database.Collection("MessageRevisions")
+	collsCol := database.Collection("Collections")
+	sharesCol := database.Collection("ShareLinks")
+	accessCol := database.Collection("MessageAccessLog")
+	webhooksCol := database.Collection("Webhooks")
+	deliveriesCol := database.Collection("WebhookDeliveries")
 
 	db := &MainDB{
 		client:      clientdb,
 		usersCol:    usersCol,
 		messagesCol: messagesCol,
+		apiKeysCol:  apiKeysCol,
+		revsCol:     revsCol,
+		collsCol:    collsCol,
+		sharesCol:   sharesCol,
+		accessCol:   accessCol,
+
+		webhooksCol:   webhooksCol,
+		deliveriesCol: deliveriesCol,
+	}
+
+	if err = db.migratePublicIds(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if err = db.createIndexes(ctx); err != nil {
+		return nil, err
 	}
 
 	return db, nil
 }
 
+func (d *MainDB) createIndexes(ctx context.Context) error {
+	_, err := d.usersCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "oidc_issuer", Value: 1}, {Key: "oidc_subject", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+				bson.D{{Key: "oidc_subject", Value: bson.D{{Key: "$exists", Value: true}}}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.apiKeysCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hashed_key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.messagesCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "tags", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "public_id", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(
+				bson.D{{Key: "public_id", Value: bson.D{{Key: "$exists", Value: true}}}}),
+		},
+		messagesTextIndex,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.collsCol.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "owner_id", Value: 1},
+			{Key: "parent_id", Value: 1},
+			{Key: "name", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.revsCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "number", Value: -1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "owner_id", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.sharesCol.Indexes().CreateMany(ctx, sharesIndexes)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.accessCol.Indexes().CreateMany(ctx, accessLogIndexes)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.webhooksCol.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "events", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = d.deliveriesCol.Indexes().CreateMany(ctx, webhookDeliveriesIndexes)
+	return err
+}
+
+// migratePublicIds sets public id to messages created before public ids were
+// added.
+func (d *MainDB) migratePublicIds(ctx context.Context) error {
+	filter := bson.D{{Key: "public_id", Value: bson.D{{Key: "$exists", Value: false}}}}
+	cursor, err := d.messagesCol.Find(ctx, filter,
+		options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var m struct {
+			Id primitive.ObjectID `bson:"_id"`
+		}
+		if err = cursor.Decode(&m); err != nil {
+			return err
+		}
+
+		publicId, err := utils.RandomBase62(PUBLIC_ID_SIZE)
+		if err != nil {
+			return err
+		}
+
+		// The filter keeps id set by concurrently started instance.
+		_, err = d.messagesCol.UpdateOne(ctx,
+			append(bson.D{{Key: "_id", Value: m.Id}}, filter...),
+			bson.D{{Key: "$set", Value: bson.D{{Key: "public_id", Value: publicId}}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
 func (d *MainDB) Shutdown(ctx context.Context) error {
 	d.usersCol = nil
 	d.messagesCol = nil
+	d.apiKeysCol = nil
+	d.revsCol = nil
+	d.sharesCol = nil
+	d.accessCol = nil
+	d.webhooksCol = nil
+	d.deliveriesCol = nil
 	return d.client.Disconnect(ctx)
 }
 
 func (d MainDB) AddUser(u *User) error {
 	ctx := context.Background()
 	_, err := d.usersCol.InsertOne(ctx, u)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAlreadyExist
+	}
 	return err
 }
 
@@ -67,9 +237,268 @@ func (d MainDB) GetUser(username string) (UserOut, error) {
 	return u, nil
 }
 
+func (d MainDB) GetUserById(id string) (UserOut, error) {
+	userId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return UserOut{}, err
+	}
+
+	u := UserOut{}
+
+	ctx := context.Background()
+	err = d.usersCol.FindOne(ctx, bson.D{{Key: "_id", Value: userId}}).Decode(&u)
+	if err != nil {
+		return UserOut{}, err
+	}
+
+	return u, nil
+}
+
+func (d MainDB) GetUserByOIDCSubject(issuer, subject string) (UserOut, error) {
+	u := UserOut{}
+
+	ctx := context.Background()
+	err := d.usersCol.FindOne(ctx, bson.D{
+		{Key: "oidc_issuer", Value: issuer},
+		{Key: "oidc_subject", Value: subject},
+	}).Decode(&u)
+	if err != nil {
+		return UserOut{}, err
+	}
+
+	return u, nil
+}
+
+func (d MainDB) updateUser(userId string, set bson.D) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	res, err := d.usersCol.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (d MainDB) UpdateLastLogin(userId string, l LoginInfo) error {
+	return d.updateUser(userId, bson.D{{Key: "last_login", Value: l}})
+}
+
+func (d MainDB) UpdatePassword(userId string, hashedPassword string) error {
+	return d.updateUser(userId, bson.D{{Key: "password", Value: hashedPassword}})
+}
+
+func (d MainDB) SetTOTP(userId string, secret string, enabled bool) error {
+	return d.updateUser(userId, bson.D{
+		{Key: "totp_secret", Value: secret},
+		{Key: "totp_enabled", Value: enabled},
+	})
+}
+
+func (d MainDB) SetRecoveryCodes(userId string, hashedCodes []string) error {
+	return d.updateUser(userId, bson.D{{Key: "recovery_codes", Value: hashedCodes}})
+}
+
+func (d MainDB) UseRecoveryCode(userId string, hashedCode string) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	res, err := d.usersCol.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}, {Key: "recovery_codes", Value: hashedCode}},
+		bson.D{{Key: "$pull", Value: bson.D{{Key: "recovery_codes", Value: hashedCode}}}})
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+// UseTOTPStep saves the time step of accepted code, it returns false when
+// the code of this or a later step is already used.
+func (d MainDB) UseTOTPStep(userId string, step int64) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	res, err := d.usersCol.UpdateOne(ctx,
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "totp_last_step", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gte", Value: step}}}}},
+		},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "totp_last_step", Value: step}}}})
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount == 1, nil
+}
+
+func (d MainDB) ScheduleUserDeletion(userId string, at time.Time) error {
+	return d.updateUser(userId, bson.D{{Key: "deletion_scheduled_at", Value: at}})
+}
+
+func (d MainDB) CancelUserDeletion(userId string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = d.usersCol.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "deletion_scheduled_at", Value: ""}}}})
+	return err
+}
+
+func (d MainDB) GetUsersToDelete(before time.Time) ([]UserOut, error) {
+	ctx := context.Background()
+	cursor, err := d.usersCol.Find(ctx,
+		bson.D{{Key: "deletion_scheduled_at", Value: bson.D{{Key: "$lte", Value: before}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]UserOut, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (d MainDB) DeleteUser(userId string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	res, err := d.usersCol.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (d MainDB) GetUsers(skip, limit int) ([]UserOut, int64, error) {
+	ctx := context.Background()
+
+	total, err := d.usersCol.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(skip)).SetLimit(int64(limit))
+	cursor, err := d.usersCol.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]UserOut, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func (d MainDB) SetUserRole(userId string, role string) error {
+	return d.updateUser(userId, bson.D{{Key: "role", Value: role}})
+}
+
+func (d MainDB) SetUserDisabled(userId string, disabled bool) error {
+	return d.updateUser(userId, bson.D{{Key: "disabled", Value: disabled}})
+}
+
+func (d MainDB) GetStats() (Stats, error) {
+	ctx := context.Background()
+	stats := Stats{
+		MessagesByEncoding: map[string]int64{},
+	}
+
+	var err error
+	if stats.Users, err = d.usersCol.CountDocuments(ctx, bson.D{}); err != nil {
+		return Stats{}, err
+	}
+	stats.DisabledUsers, err = d.usersCol.CountDocuments(ctx,
+		bson.D{{Key: "disabled", Value: true}})
+	if err != nil {
+		return Stats{}, err
+	}
+	if stats.Messages, err = d.messagesCol.CountDocuments(ctx, bson.D{}); err != nil {
+		return Stats{}, err
+	}
+	stats.PublicMessages, err = d.messagesCol.CountDocuments(ctx, bson.D{
+		{Key: "encoding_type", Value: "plaintext"},
+		{Key: "is_private", Value: false},
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	if stats.APIKeys, err = d.apiKeysCol.CountDocuments(ctx, bson.D{}); err != nil {
+		return Stats{}, err
+	}
+
+	cursor, err := d.messagesCol.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$encoding_type"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	for cursor.Next(ctx) {
+		var group struct {
+			EncodingType string `bson:"_id"`
+			Count        int64  `bson:"count"`
+		}
+		if err = cursor.Decode(&group); err != nil {
+			return Stats{}, err
+		}
+		stats.MessagesByEncoding[group.EncodingType] = group.Count
+	}
+
+	return stats, cursor.Err()
+}
+
 func (d MainDB) AddMessage(m *Message) (string, error) {
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	if m.PublicId == "" {
+		publicId, err := utils.RandomBase62(PUBLIC_ID_SIZE)
+		if err != nil {
+			return "", err
+		}
+		m.PublicId = publicId
+	}
+
 	ctx := context.Background()
 	result, err := d.messagesCol.InsertOne(ctx, m)
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrAlreadyExist
+	}
 	if err != nil {
 		return "", err
 	}
@@ -80,26 +509,57 @@ func (d MainDB) AddMessage(m *Message) (string, error) {
 
 	return id.Hex(), nil
 }
-func (d MainDB) GetMessage(id string) (MessageOut, error) {
-	msgId, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return MessageOut{}, err
+
+// messageIdFilter matches message by internal or public id.
+func messageIdFilter(id string) bson.D {
+	if msgId, err := primitive.ObjectIDFromHex(id); err == nil {
+		return bson.D{{Key: "_id", Value: msgId}}
 	}
+	return bson.D{{Key: "public_id", Value: id}}
+}
 
+func (d MainDB) findMessage(filter bson.D) (MessageOut, error) {
 	ctx := context.Background()
-	result := d.messagesCol.FindOne(ctx, bson.D{{Key: "_id", Value: msgId}})
+	result := d.messagesCol.FindOne(ctx, filter)
 	if result.Err() != nil {
 		return MessageOut{}, result.Err()
 	}
 
 	msg := new(MessageOut)
-	err = result.Decode(msg)
+	err := result.Decode(msg)
 	if err != nil {
 		return MessageOut{}, err
 	}
 
 	return *msg, nil
 }
+
+// GetMessage returns message by internal or public id.
+func (d MainDB) GetMessage(id string) (MessageOut, error) {
+	if id == "" {
+		return MessageOut{}, mongo.ErrNoDocuments
+	}
+	return d.findMessage(messageIdFilter(id))
+}
+
+// GetMessageByPublicId doesn't accept internal ids, it's used by routes
+// available without authentication.
+func (d MainDB) GetMessageByPublicId(publicId string) (MessageOut, error) {
+	if publicId == "" {
+		return MessageOut{}, mongo.ErrNoDocuments
+	}
+	return d.findMessage(bson.D{{Key: "public_id", Value: publicId}})
+}
+
+// notExpired matches messages without expiration time or with expiration
+// in the future.
+func notExpired(now time.Time) bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: "expires_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}}},
+	}}
+}
+
 func (d MainDB) GetLastPublicMessages(skip int) (MessagesOut, error) {
 	ctx := context.Background()
 
@@ -117,7 +577,8 @@ func (d MainDB) GetLastPublicMessages(skip int) (MessagesOut, error) {
 			{
 				Key:   "is_one_time",
 				Value: false,
-			}}, opts)
+			},
+			notExpired(time.Now())}, opts)
 	if err != nil {
 		return MessagesOut{}, err
 	}
@@ -133,9 +594,24 @@ func (d MainDB) GetLastPublicMessages(skip int) (MessagesOut, error) {
 
 	return messages, nil
 }
-func (d MainDB) GetUserMessages(ownerId string) (MessagesOut, error) {
+func (d MainDB) GetUserMessages(ownerId string, f MessageFilter) (MessagesOut, error) {
+	filter := bson.D{{Key: "owner_id", Value: ownerId}, notExpired(time.Now())}
+	if f.Tag != "" {
+		filter = append(filter, bson.E{Key: "tags", Value: f.Tag})
+	}
+	if f.CollectionId != "" {
+		filter = append(filter, bson.E{Key: "collection_id", Value: f.CollectionId})
+	}
+	if f.EncodingType != "" {
+		filter = append(filter, bson.E{Key: "encoding_type", Value: f.EncodingType})
+	}
+	if !f.CreatedBefore.IsZero() {
+		filter = append(filter, bson.E{Key: "created_at",
+			Value: bson.D{{Key: "$lt", Value: f.CreatedBefore}}})
+	}
+
 	ctx := context.Background()
-	cursor, err := d.messagesCol.Find(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	cursor, err := d.messagesCol.Find(ctx, filter)
 	if err != nil {
 		return MessagesOut{}, err
 	}
@@ -151,7 +627,107 @@ func (d MainDB) GetUserMessages(ownerId string) (MessagesOut, error) {
 
 	return messages, nil
 }
-func (d MainDB) UpdateMessage(id string, m *Message) error {
+
+// versionFilter matches message with given id and version. Messages created
+// before versioning don't have the field and are treated as version 0.
+func versionFilter(id primitive.ObjectID, version int64) bson.D {
+	if version == 0 {
+		return bson.D{{Key: "_id", Value: id},
+			{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}}}
+	}
+	return bson.D{{Key: "_id", Value: id}, {Key: "version", Value: version}}
+}
+
+func (d MainDB) updateMessageVersion(id string, version int64, update bson.D) error {
+	msgId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	res, err := d.messagesCol.UpdateOne(ctx, versionFilter(msgId, version), update)
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrVersionConflict
+	}
+
+	return nil
+}
+
+// UpdateMessage replaces the message. It is a full replacement, so message
+// without expiration loses expiration time set before.
+func (d MainDB) UpdateMessage(id string, version int64, m *Message) error {
+	updated := *m
+	updated.Version = version + 1
+
+	update := bson.D{{Key: "$set", Value: updated}}
+	if m.ExpiresAt == nil {
+		update = append(update,
+			bson.E{Key: "$unset", Value: bson.D{{Key: "expires_at", Value: ""}}})
+	}
+
+	return d.updateMessageVersion(id, version, update)
+}
+
+// patchFields returns fields changed by the patch without version.
+func patchFields(p MessagePatch) (set bson.D, unset bson.D) {
+	set = bson.D{}
+	if p.OnlyOwnerView != nil {
+		set = append(set, bson.E{Key: "only_owner_view", Value: *p.OnlyOwnerView})
+	}
+	if p.IsPrivate != nil {
+		set = append(set, bson.E{Key: "is_private", Value: *p.IsPrivate})
+	}
+	if p.IsAnon != nil {
+		set = append(set, bson.E{Key: "is_anon", Value: *p.IsAnon})
+	}
+	if p.IsOneTime != nil {
+		set = append(set, bson.E{Key: "is_one_time", Value: *p.IsOneTime})
+	}
+	if p.Title != nil {
+		set = append(set, bson.E{Key: "title", Value: *p.Title})
+	}
+	if p.TitleEncrypted != nil {
+		set = append(set, bson.E{Key: "title_encrypted", Value: *p.TitleEncrypted})
+	}
+	if p.Tags != nil {
+		set = append(set, bson.E{Key: "tags", Value: *p.Tags})
+	}
+	if p.CollectionId != nil {
+		set = append(set, bson.E{Key: "collection_id", Value: *p.CollectionId})
+	}
+	if p.ExpiresAt != nil {
+		set = append(set, bson.E{Key: "expires_at", Value: *p.ExpiresAt})
+	}
+	if !p.UpdatedAt.IsZero() {
+		set = append(set, bson.E{Key: "updated_at", Value: p.UpdatedAt})
+	}
+
+	if p.ClearExpiresAt {
+		unset = bson.D{{Key: "expires_at", Value: ""}}
+	}
+
+	return set, unset
+}
+
+func (d MainDB) PatchMessage(id string, version int64, p MessagePatch) error {
+	set, unset := patchFields(p)
+	set = append(set, bson.E{Key: "version", Value: version + 1})
+
+	update := bson.D{{Key: "$set", Value: set}}
+	if unset != nil {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	return d.updateMessageVersion(id, version, update)
+}
+
+// TouchMessage sets time of the last message read, it doesn't change the
+// message version.
+func (d MainDB) TouchMessage(id string, at time.Time) error {
 	msgId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
@@ -159,9 +735,26 @@ func (d MainDB) UpdateMessage(id string, m *Message) error {
 
 	ctx := context.Background()
 	_, err = d.messagesCol.UpdateOne(ctx, bson.D{{Key: "_id", Value: msgId}},
-		bson.D{{Key: "$set", Value: m}})
+		bson.D{{Key: "$set", Value: bson.D{{Key: "last_accessed_at", Value: at}}}})
+	return err
+}
+
+// CountMessageRead updates read receipts of the message, it doesn't change
+// the message version.
+func (d MainDB) CountMessageRead(id string, at time.Time) error {
+	msgId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = d.messagesCol.UpdateOne(ctx, bson.D{{Key: "_id", Value: msgId}}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "read_count", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "last_read_at", Value: at}}},
+	})
 	return err
 }
+
 func (d MainDB) DeleteMessage(id string) error {
 	msgId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -178,5 +771,79 @@ func (d MainDB) DeleteMessage(id string) error {
 		return errors.New("message is not deleted")
 	}
 
+	return d.deleteMessagesData(ctx, bson.D{{Key: "message_id", Value: id}})
+}
+
+// deleteMessagesData removes revisions and share links of deleted messages
+// matched by the filter.
+func (d MainDB) deleteMessagesData(ctx context.Context, filter bson.D) error {
+	for _, col := range []*mongo.Collection{d.revsCol, d.sharesCol} {
+		if _, err := col.DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (d MainDB) DeleteUserMessages(ownerId string) (int64, error) {
+	ctx := context.Background()
+	res, err := d.messagesCol.DeleteMany(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.deleteMessagesData(ctx, bson.D{{Key: "owner_id", Value: ownerId}})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.DeletedCount, nil
+}
+
+// DeleteExpiredMessages removes expired messages and returns their ids and
+// owners.
+func (d MainDB) DeleteExpiredMessages(now time.Time) (MessagesOut, error) {
+	ctx := context.Background()
+	filter := bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lte", Value: now}}}}
+
+	opts := options.Find().SetProjection(bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "public_id", Value: 1},
+		{Key: "owner_id", Value: 1},
+	})
+	cursor, err := d.messagesCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make(MessagesOut, 0)
+	if err = cursor.All(ctx, &expired); err != nil {
+		return nil, err
+	}
+
+	if len(expired) == 0 {
+		return expired, nil
+	}
+
+	ids := make(bson.A, 0, len(expired))
+	hexIds := make(bson.A, 0, len(expired))
+	for _, m := range expired {
+		ids = append(ids, m.Id)
+		hexIds = append(hexIds, m.Id.Hex())
+	}
+
+	// Expiration filter is kept in case ttl was extended after the search.
+	_, err = d.messagesCol.DeleteMany(ctx,
+		append(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, filter...))
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.deleteMessagesData(ctx,
+		bson.D{{Key: "message_id", Value: bson.D{{Key: "$in", Value: hexIds}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
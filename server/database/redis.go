@@ -2,10 +2,13 @@ package database
 
 import (
 	"context"
-	"crypto/sha1"
+	"encoding/json"
 	"errors"
-	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/arimatakao/deepenc/utils"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -35,36 +38,91 @@ func (c CacheDB) Shutdown(context.Context) error {
 	return c.r.Close()
 }
 
-func (c CacheDB) AddUser(username string, hashedPassword string) (string, error) {
-	hasher := sha1.New()
-	hashedUsername := fmt.Sprintf("%x", hasher.Sum([]byte(username)))
+func signupKey(token string) string {
+	return "signup:" + token
+}
+
+func signupUsernameKey(username string) string {
+	return "signup_username:" + username
+}
 
-	isExist, err := c.r.Exists(context.Background(), hashedUsername).Result()
+func (c CacheDB) AddUser(username, email, hashedPassword string, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	token, err := utils.RandomToken(32)
 	if err != nil {
 		return "", err
 	}
 
-	if isExist == 1 {
-		return "", errors.New("user is already exist in cache")
+	isSet, err := c.r.SetNX(ctx, signupUsernameKey(username), token, ttl).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if !isSet {
+		return "", ErrAlreadyExist
 	}
 
 	cUser := cachedUser{
 		Username:       username,
+		Email:          email,
 		HashedPassword: hashedPassword,
 	}
-	err = c.r.HSet(context.Background(), hashedUsername, cUser).Err()
+	_, err = c.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, signupKey(token), cUser)
+		pipe.Expire(ctx, signupKey(token), ttl)
+		return nil
+	})
 	if err != nil {
+		c.r.Del(ctx, signupUsernameKey(username))
 		return "", err
 	}
 
-	return hashedUsername, nil
+	return token, nil
 }
 
+// DeletePendingUser removes signup waiting for verification and releases
+// the username.
+func (c CacheDB) DeletePendingUser(token, username string) error {
+	ctx := context.Background()
+	return c.r.Del(ctx, signupKey(token), signupUsernameKey(username)).Err()
+}
+
+// takeSignupScript reads the pending signup and removes it together with
+// the username reservation, so the token is used only once.
+var takeSignupScript = redis.NewScript(`
+local fields = redis.call('HGETALL', KEYS[1])
+if #fields == 0 then
+	return fields
+end
+for i = 1, #fields, 2 do
+	if fields[i] == 'username' then
+		local key = ARGV[1] .. fields[i + 1]
+		if redis.call('GET', key) == ARGV[2] then
+			redis.call('DEL', key)
+		end
+	end
+end
+redis.call('DEL', KEYS[1])
+return fields
+`)
+
 func (c CacheDB) GetUser(token string) (*User, error) {
-	result, err := c.r.HGetAll(context.Background(), token).Result()
+	ctx := context.Background()
+
+	fields, err := takeSignupScript.Run(ctx, c.r, []string{signupKey(token)},
+		signupUsernameKey(""), token).StringSlice()
 	if err != nil {
 		return nil, err
 	}
+	if len(fields) == 0 {
+		return nil, ErrTokenNotFound
+	}
+
+	result := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		result[fields[i]] = fields[i+1]
+	}
 	username, ok := result["username"]
 	if !ok {
 		return nil, errors.New("username field in hset not exist")
@@ -74,13 +132,369 @@ func (c CacheDB) GetUser(token string) (*User, error) {
 		return nil, errors.New("password field in hset not exist")
 	}
 
-	err = c.r.Del(context.Background(), token).Err()
-	if err != nil {
-		return nil, err
-	}
-
 	return &User{
 		Username: username,
+		Email:    result["email"],
 		Password: hashedPassword,
 	}, nil
 }
+
+func failedLoginsKey(username string) string {
+	return "failed_logins:" + username
+}
+
+func (c CacheDB) AddFailedLogin(username string, lockout time.Duration) (int64, error) {
+	ctx := context.Background()
+	key := failedLoginsKey(username)
+
+	var incr *redis.IntCmd
+	_, err := c.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, lockout)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+func (c CacheDB) GetFailedLogins(username string) (int64, error) {
+	attempts, err := c.r.Get(context.Background(), failedLoginsKey(username)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return attempts, err
+}
+
+func (c CacheDB) ResetFailedLogins(username string) error {
+	return c.r.Del(context.Background(), failedLoginsKey(username)).Err()
+}
+
+func shareNotificationsKey(userId string) string {
+	return "share_notifications:" + userId
+}
+
+// AddShareNotification counts notifications sent by the user, the counter
+// is reset when the window since the first notification is over.
+func (c CacheDB) AddShareNotification(userId string, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	key := shareNotificationsKey(userId)
+
+	var incr *redis.IntCmd
+	_, err := c.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+func accessFailuresKey(messageId, ip string) string {
+	return "access_failures:" + messageId + ":" + ip
+}
+
+// AddAccessFailure counts failed attempts to read the message from the IP,
+// the counter is reset when the window since the first failure is over.
+func (c CacheDB) AddAccessFailure(messageId, ip string, window time.Duration) (int64, error) {
+	ctx := context.Background()
+	key := accessFailuresKey(messageId, ip)
+
+	var incr *redis.IntCmd
+	_, err := c.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+func loginChallengeKey(token string) string {
+	return "login_challenge:" + token
+}
+
+func (c CacheDB) AddLoginChallenge(userId string, ttl time.Duration) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.r.Set(context.Background(), loginChallengeKey(token), userId, ttl).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (c CacheDB) GetLoginChallenge(token string) (string, error) {
+	userId, err := c.r.GetDel(context.Background(), loginChallengeKey(token)).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return userId, err
+}
+
+func eventsTicketKey(ticket string) string {
+	return "events_ticket:" + ticket
+}
+
+// AddEventsTicket creates ticket to open event stream of the user.
+func (c CacheDB) AddEventsTicket(userId string, ttl time.Duration) (string, error) {
+	ticket, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.r.Set(context.Background(), eventsTicketKey(ticket), userId, ttl).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return ticket, nil
+}
+
+// GetEventsTicket returns owner of the ticket and removes it, the ticket
+// is used once.
+func (c CacheDB) GetEventsTicket(ticket string) (string, error) {
+	userId, err := c.r.GetDel(context.Background(), eventsTicketKey(ticket)).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return userId, err
+}
+
+func passwordResetKey(token string) string {
+	return "password_reset:" + token
+}
+
+func (c CacheDB) AddPasswordResetToken(userId string, ttl time.Duration) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.r.Set(context.Background(), passwordResetKey(token), userId, ttl).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (c CacheDB) GetPasswordResetToken(token string) (string, error) {
+	userId, err := c.r.GetDel(context.Background(), passwordResetKey(token)).Result()
+	if err == redis.Nil {
+		return "", ErrTokenNotFound
+	}
+	return userId, err
+}
+
+func tokensRevokedKey(userId string) string {
+	return "tokens_revoked:" + userId
+}
+
+func (c CacheDB) RevokeTokens(userId string, at time.Time) error {
+	return c.r.Set(context.Background(), tokensRevokedKey(userId), at.UnixMilli(), 0).Err()
+}
+
+func (c CacheDB) GetTokensRevokedAt(userId string) (time.Time, error) {
+	ms, err := c.r.Get(context.Background(), tokensRevokedKey(userId)).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func oidcStateKey(state string) string {
+	return "oidc_state:" + state
+}
+
+func (c CacheDB) AddOIDCState(verifier, nonce string, ttl time.Duration) (string, error) {
+	ctx := context.Background()
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = c.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, oidcStateKey(state), "verifier", verifier, "nonce", nonce)
+		pipe.Expire(ctx, oidcStateKey(state), ttl)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+func (c CacheDB) GetOIDCState(state string) (string, string, error) {
+	ctx := context.Background()
+
+	var fields *redis.MapStringStringCmd
+	_, err := c.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fields = pipe.HGetAll(ctx, oidcStateKey(state))
+		pipe.Del(ctx, oidcStateKey(state))
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	result := fields.Val()
+	if len(result) == 0 {
+		return "", "", ErrTokenNotFound
+	}
+
+	return result["verifier"], result["nonce"], nil
+}
+
+// webhookQueueKey is a sorted set of webhook jobs scored by time of the next
+// attempt. Claimed jobs are moved to webhookProcessingKey scored by the time
+// when the claim expires.
+const (
+	webhookQueueKey      = "webhooks:queue"
+	webhookProcessingKey = "webhooks:processing"
+)
+
+// claimWebhookJobsScript puts expired claims back to the queue and moves due
+// jobs to the processing set. Both steps are atomic, so a job is claimed by
+// one instance and isn't lost when the instance stops before the ack.
+var claimWebhookJobsScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, m in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], m)
+	redis.call('ZADD', KEYS[1], ARGV[1], m)
+end
+
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, m in ipairs(due) do
+	redis.call('ZREM', KEYS[1], m)
+	redis.call('ZADD', KEYS[2], ARGV[2], m)
+end
+return due
+`)
+
+func (c CacheDB) EnqueueWebhookJob(j WebhookJob, at time.Time) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	return c.r.ZAdd(context.Background(), webhookQueueKey, redis.Z{
+		Score:  float64(at.Unix()),
+		Member: string(data),
+	}).Err()
+}
+
+// ClaimWebhookJobs takes jobs due to the time from the queue. A claimed job
+// is returned to the queue when it isn't acked within timeout, so every job
+// is delivered at least once when several instances share the queue.
+func (c CacheDB) ClaimWebhookJobs(now time.Time, limit int, timeout time.Duration) ([]WebhookJob, error) {
+	ctx := context.Background()
+
+	members, err := claimWebhookJobsScript.Run(ctx, c.r,
+		[]string{webhookQueueKey, webhookProcessingKey},
+		now.Unix(), now.Add(timeout).Unix(), limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]WebhookJob, 0, len(members))
+	for _, m := range members {
+		// Malformed job can't be delivered, it's just dropped.
+		j := WebhookJob{}
+		if err = json.Unmarshal([]byte(m), &j); err != nil {
+			if err = c.r.ZRem(ctx, webhookProcessingKey, m).Err(); err != nil {
+				return jobs, err
+			}
+			continue
+		}
+		j.claim = m
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// AckWebhookJob removes the claimed job when it's handled.
+func (c CacheDB) AckWebhookJob(j WebhookJob) error {
+	return c.r.ZRem(context.Background(), webhookProcessingKey, j.claim).Err()
+}
+
+const userEventsPrefix = "events:"
+
+func userEventsKey(userId string) string {
+	return userEventsPrefix + userId
+}
+
+// PublishUserEvent sends event to subscriptions of the user on every
+// instance.
+func (c CacheDB) PublishUserEvent(userId string, event string) error {
+	return c.r.Publish(context.Background(), userEventsKey(userId), event).Err()
+}
+
+type redisSubscription struct {
+	ps     *redis.PubSub
+	events chan UserEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *redisSubscription) Events() <-chan UserEvent {
+	return s.events
+}
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.ps.Close()
+}
+
+// SubscribeUserEvents subscribes to events of every user with one
+// connection, the instance dispatches them to its clients.
+func (c CacheDB) SubscribeUserEvents(ctx context.Context) (Subscription, error) {
+	ps := c.r.PSubscribe(ctx, userEventsKey("*"))
+	// Wait for confirmation, so events published after return are not lost.
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	sub := &redisSubscription{
+		ps:     ps,
+		events: make(chan UserEvent),
+		done:   make(chan struct{}),
+	}
+
+	messages := ps.Channel()
+	go func() {
+		defer close(sub.events)
+		for m := range messages {
+			e := UserEvent{
+				UserId:  strings.TrimPrefix(m.Channel, userEventsPrefix),
+				Payload: m.Payload,
+			}
+			select {
+			case sub.events <- e:
+			case <-sub.done:
+				return
+			}
+		}
+	}()
+
+	return sub, nil
+}
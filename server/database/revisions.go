@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (d MainDB) lastRevisionNumber(ctx context.Context, messageId string) (int, error) {
+	last := RevisionOut{}
+	opts := options.FindOne().SetSort(bson.D{{Key: "number", Value: -1}})
+	err := d.revsCol.FindOne(ctx, bson.D{{Key: "message_id", Value: messageId}}, opts).
+		Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return last.Number, nil
+}
+
+// ADD_REVISION_ATTEMPTS is how many times the next number is taken again
+// when concurrent update of the message took it first.
+const ADD_REVISION_ATTEMPTS = 10
+
+// AddRevision stores revision with the next number of the message. Unique
+// index on the number rejects the loser of concurrent updates, it retries
+// with the new last number.
+func (d MainDB) AddRevision(r *Revision) (int, error) {
+	ctx := context.Background()
+
+	for i := 0; i < ADD_REVISION_ATTEMPTS; i++ {
+		last, err := d.lastRevisionNumber(ctx, r.MessageId)
+		if err != nil {
+			return 0, err
+		}
+
+		r.Number = last + 1
+		_, err = d.revsCol.InsertOne(ctx, r)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return r.Number, nil
+	}
+
+	return 0, ErrVersionConflict
+}
+
+func (d MainDB) GetRevisions(messageId string) ([]RevisionOut, error) {
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: -1}})
+	cursor, err := d.revsCol.Find(ctx, bson.D{{Key: "message_id", Value: messageId}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]RevisionOut, 0)
+	if err = cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (d MainDB) GetRevision(messageId string, number int) (RevisionOut, error) {
+	r := RevisionOut{}
+
+	ctx := context.Background()
+	err := d.revsCol.FindOne(ctx, bson.D{
+		{Key: "message_id", Value: messageId},
+		{Key: "number", Value: number},
+	}).Decode(&r)
+	if err != nil {
+		return RevisionOut{}, err
+	}
+
+	return r, nil
+}
+
+// PruneRevisions keeps only the newest revisions of the message.
+func (d MainDB) PruneRevisions(messageId string, keep int) error {
+	ctx := context.Background()
+
+	last, err := d.lastRevisionNumber(ctx, messageId)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.revsCol.DeleteMany(ctx, bson.D{
+		{Key: "message_id", Value: messageId},
+		{Key: "number", Value: bson.D{{Key: "$lte", Value: last - keep}}},
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// messagesTextIndex ranks matches in titles and tags above matches in
+// content. Content of encrypted messages is stored as ciphertext, so only
+// their metadata is effectively searchable.
+var messagesTextIndex = mongo.IndexModel{
+	Keys: bson.D{
+		{Key: "title", Value: "text"},
+		{Key: "tags", Value: "text"},
+		{Key: "content", Value: "text"},
+	},
+	Options: options.Index().SetName("messages_text").SetWeights(bson.D{
+		{Key: "title", Value: 10},
+		{Key: "tags", Value: 5},
+		{Key: "content", Value: 1},
+	}),
+}
+
+// searchFilter matches public plaintext messages and all messages of the
+// owner if it is set.
+func searchFilter(q SearchQuery) bson.D {
+	public := bson.D{
+		{Key: "encoding_type", Value: "plaintext"},
+		{Key: "is_private", Value: false},
+		{Key: "is_one_time", Value: false},
+		{Key: "only_owner_view", Value: false},
+	}
+
+	visible := bson.A{public}
+	if q.OwnerId != "" {
+		visible = append(visible, bson.D{{Key: "owner_id", Value: q.OwnerId}})
+	}
+
+	return bson.D{
+		{Key: "$text", Value: bson.D{{Key: "$search", Value: q.Text}}},
+		{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$or", Value: visible}},
+			bson.D{notExpired(time.Now())},
+		}},
+	}
+}
+
+func (d MainDB) SearchMessages(q SearchQuery) (MessagesOut, int64, error) {
+	ctx := context.Background()
+	filter := searchFilter(q)
+
+	total, err := d.messagesCol.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	score := bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+	opts := options.Find().SetProjection(score).
+		SetSort(append(score, bson.E{Key: "_id", Value: -1})).
+		SetSkip(int64(q.Skip)).SetLimit(int64(q.Limit))
+	cursor, err := d.messagesCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	messages := make(MessagesOut, 0)
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, 0, err
+	}
+
+	return messages, total, nil
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sharesIndexes include TTL index, Mongo removes expired links by itself.
+var sharesIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "hashed_token", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	},
+	{
+		Keys: bson.D{{Key: "message_id", Value: 1}},
+	},
+	{
+		Keys: bson.D{{Key: "owner_id", Value: 1}},
+	},
+	{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	},
+}
+
+func (d MainDB) AddShareLink(l *ShareLink) (string, error) {
+	ctx := context.Background()
+	result, err := d.sharesCol.InsertOne(ctx, l)
+	if err != nil {
+		return "", err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("can't convert inserted id primitive")
+	}
+
+	return id.Hex(), nil
+}
+
+// GetShareLink returns not expired link by hash of its token.
+func (d MainDB) GetShareLink(hashedToken string) (ShareLinkOut, error) {
+	l := ShareLinkOut{}
+
+	ctx := context.Background()
+	err := d.sharesCol.FindOne(ctx, bson.D{
+		{Key: "hashed_token", Value: hashedToken},
+		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}).Decode(&l)
+	if err != nil {
+		return ShareLinkOut{}, err
+	}
+	l.HasPassword = l.HashedPassword != ""
+
+	return l, nil
+}
+
+func (d MainDB) GetShareLinks(messageId string) ([]ShareLinkOut, error) {
+	ctx := context.Background()
+	cursor, err := d.sharesCol.Find(ctx, bson.D{
+		{Key: "message_id", Value: messageId},
+		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now()}}},
+	}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]ShareLinkOut, 0)
+	if err = cursor.All(ctx, &links); err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].HasPassword = links[i].HashedPassword != ""
+	}
+
+	return links, nil
+}
+
+// UseShareLink counts a view of the link. It returns mongo.ErrNoDocuments if
+// the link is expired, revoked or all its views are used, so concurrent
+// requests can't exceed the view limit.
+func (d MainDB) UseShareLink(id string, now time.Time) error {
+	linkId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	ctx := context.Background()
+	res, err := d.sharesCol.UpdateOne(ctx, bson.D{
+		{Key: "_id", Value: linkId},
+		{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: now}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "max_views", Value: 0}},
+			bson.D{{Key: "$expr", Value: bson.D{{Key: "$lt", Value: bson.A{"$views", "$max_views"}}}}},
+		}},
+	}, bson.D{{Key: "$inc", Value: bson.D{{Key: "views", Value: 1}}}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (d MainDB) DeleteShareLink(ownerId, messageId, id string) error {
+	linkId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	ctx := context.Background()
+	res, err := d.sharesCol.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: linkId},
+		{Key: "owner_id", Value: ownerId},
+		{Key: "message_id", Value: messageId},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
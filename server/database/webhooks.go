@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WEBHOOK_DELIVERIES_RETENTION is how long delivery log is kept.
+const WEBHOOK_DELIVERIES_RETENTION = 30 * 24 * time.Hour
+
+var webhookDeliveriesIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "webhook_id", Value: 1}, {Key: "at", Value: -1}},
+	},
+	{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(WEBHOOK_DELIVERIES_RETENTION.Seconds())),
+	},
+}
+
+func (d MainDB) AddWebhook(w *Webhook) (string, error) {
+	ctx := context.Background()
+	result, err := d.webhooksCol.InsertOne(ctx, w)
+	if err != nil {
+		return "", err
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return "", errors.New("can't convert inserted id primitive")
+	}
+
+	return id.Hex(), nil
+}
+
+func (d MainDB) GetWebhook(ownerId, id string) (WebhookOut, error) {
+	webhookId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return WebhookOut{}, mongo.ErrNoDocuments
+	}
+
+	w := WebhookOut{}
+
+	ctx := context.Background()
+	err = d.webhooksCol.FindOne(ctx, bson.D{
+		{Key: "_id", Value: webhookId},
+		{Key: "owner_id", Value: ownerId},
+	}).Decode(&w)
+	if err != nil {
+		return WebhookOut{}, err
+	}
+
+	return w, nil
+}
+
+func (d MainDB) findWebhooks(filter bson.D) ([]WebhookOut, error) {
+	ctx := context.Background()
+	cursor, err := d.webhooksCol.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]WebhookOut, 0)
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (d MainDB) GetWebhooks(ownerId string) ([]WebhookOut, error) {
+	return d.findWebhooks(bson.D{{Key: "owner_id", Value: ownerId}})
+}
+
+// GetEventWebhooks returns webhooks of the user subscribed to the event.
+func (d MainDB) GetEventWebhooks(ownerId, event string) ([]WebhookOut, error) {
+	return d.findWebhooks(bson.D{
+		{Key: "owner_id", Value: ownerId},
+		{Key: "events", Value: event},
+	})
+}
+
+func (d MainDB) DeleteWebhook(ownerId, id string) error {
+	webhookId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	ctx := context.Background()
+	res, err := d.webhooksCol.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: webhookId},
+		{Key: "owner_id", Value: ownerId},
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	_, err = d.deliveriesCol.DeleteMany(ctx, bson.D{{Key: "webhook_id", Value: id}})
+	return err
+}
+
+func (d MainDB) DeleteUserWebhooks(ownerId string) error {
+	ctx := context.Background()
+	filter := bson.D{{Key: "owner_id", Value: ownerId}}
+
+	if _, err := d.webhooksCol.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+
+	_, err := d.deliveriesCol.DeleteMany(ctx, filter)
+	return err
+}
+
+func (d MainDB) AddWebhookDelivery(w *WebhookDelivery) error {
+	ctx := context.Background()
+	_, err := d.deliveriesCol.InsertOne(ctx, w)
+	return err
+}
+
+func (d MainDB) GetWebhookDeliveries(ownerId, webhookId string, skip, limit int) ([]WebhookDeliveryOut, int64, error) {
+	ctx := context.Background()
+	filter := bson.D{
+		{Key: "owner_id", Value: ownerId},
+		{Key: "webhook_id", Value: webhookId},
+	}
+
+	total, err := d.deliveriesCol.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).
+		SetSkip(int64(skip)).SetLimit(int64(limit))
+	cursor, err := d.deliveriesCol.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	deliveries := make([]WebhookDeliveryOut, 0)
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, 0, err
+	}
+
+	return deliveries, total, nil
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	EVENT_MESSAGE_SHARED = "message.shared"
+	EVENT_ACCOUNT_LOGIN  = "account.login"
+
+	// Query param with ticket for clients which can't set headers, like
+	// browser EventSource. Tickets are short-lived and used once, so the
+	// session token never appears in urls.
+	EVENTS_TICKET_QUERY_PARAM = "ticket"
+	EVENTS_TICKET_TTL         = 30 * time.Second
+
+	SSE_HEARTBEAT_INTERVAL = 25 * time.Second
+
+	// Limits of connected streams on the instance.
+	SSE_MAX_USER_STREAMS = 5
+	SSE_MAX_STREAMS      = 10000
+	// Amount of events waiting for the slow client before it's disconnected.
+	SSE_STREAM_BUFFER = 16
+)
+
+var (
+	errUserStreamsLimit  = errors.New("too many event streams of the user")
+	errEventsUnavailable = errors.New("event streams are unavailable")
+)
+
+// userEvent is pushed to connected clients of the user. Message events use
+// the same names as webhook events.
+type userEvent struct {
+	Type string      `json:"type"`
+	At   time.Time   `json:"at"`
+	Data interface{} `json:"data"`
+}
+
+// publishUserEvent sends event to streams of the user connected to any
+// instance.
+func (s *Server) publishUserEvent(userId, eventType string, data interface{}) error {
+	payload, err := json.Marshal(userEvent{
+		Type: eventType,
+		At:   time.Now().UTC(),
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.cachedb.PublishUserEvent(userId, string(payload))
+}
+
+// eventStream receives events of the user, the channel is closed when the
+// stream is disconnected by the hub.
+type eventStream struct {
+	userId string
+	events chan string
+}
+
+// eventHub dispatches events from one subscription of the instance to
+// connected streams. Stream which doesn't read events in time is
+// disconnected, so a slow client doesn't hold events of others.
+type eventHub struct {
+	sub database.Subscription
+
+	mu      sync.Mutex
+	streams map[string]map[*eventStream]struct{}
+	total   int
+	closed  bool
+}
+
+func newEventHub(sub database.Subscription) *eventHub {
+	h := &eventHub{
+		sub:     sub,
+		streams: make(map[string]map[*eventStream]struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *eventHub) run() {
+	for e := range h.sub.Events() {
+		h.dispatch(e)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, streams := range h.streams {
+		for st := range streams {
+			h.remove(st)
+		}
+	}
+}
+
+func (h *eventHub) dispatch(e database.UserEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for st := range h.streams[e.UserId] {
+		select {
+		case st.events <- e.Payload:
+		default:
+			h.remove(st)
+		}
+	}
+}
+
+// register connects new stream of the user when limits allow it.
+func (h *eventHub) register(userId string) (*eventStream, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed || h.total >= SSE_MAX_STREAMS {
+		return nil, errEventsUnavailable
+	}
+	if len(h.streams[userId]) >= SSE_MAX_USER_STREAMS {
+		return nil, errUserStreamsLimit
+	}
+
+	st := &eventStream{
+		userId: userId,
+		events: make(chan string, SSE_STREAM_BUFFER),
+	}
+	if h.streams[userId] == nil {
+		h.streams[userId] = make(map[*eventStream]struct{})
+	}
+	h.streams[userId][st] = struct{}{}
+	h.total++
+
+	return st, nil
+}
+
+// unregister disconnects the stream, it's safe to call for the stream
+// which is disconnected already.
+func (h *eventHub) unregister(st *eventStream) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.streams[st.userId][st]; ok {
+		h.remove(st)
+	}
+}
+
+// remove is called with the lock held.
+func (h *eventHub) remove(st *eventStream) {
+	delete(h.streams[st.userId], st)
+	if len(h.streams[st.userId]) == 0 {
+		delete(h.streams, st.userId)
+	}
+	close(st.events)
+	h.total--
+}
+
+// Close stops the subscription and disconnects every stream.
+func (h *eventHub) Close() error {
+	return h.sub.Close()
+}
+
+// formatSSE converts published event into server-sent event. Events are
+// encoded as single line JSON, so one data line is enough.
+func formatSSE(payload string) string {
+	e := userEvent{}
+	if err := json.Unmarshal([]byte(payload), &e); err != nil || e.Type == "" {
+		return fmt.Sprintf("data: %s\n\n", payload)
+	}
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", e.Type, payload)
+}
+
+// CreateEventsTicket returns ticket to open event stream with
+// EVENTS_TICKET_QUERY_PARAM.
+func (s *Server) CreateEventsTicket(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	ticket, err := s.cachedb.AddEventsTicket(userId, EVENTS_TICKET_TTL)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_in": int(EVENTS_TICKET_TTL.Seconds()),
+	})
+}
+
+func isEventsTicketRequest(c echo.Context) bool {
+	return c.QueryParam(EVENTS_TICKET_QUERY_PARAM) != ""
+}
+
+// authenticateEventsTicket takes the ticket from the request and puts its
+// owner into the context the same way as echojwt does. Requests without
+// ticket are passed to the next middleware untouched.
+func (s *Server) authenticateEventsTicket(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !isEventsTicketRequest(c) {
+			return next(c)
+		}
+
+		userId, err := s.cachedb.GetEventsTicket(c.QueryParam(EVENTS_TICKET_QUERY_PARAM))
+		if err == database.ErrTokenNotFound {
+			return c.String(http.StatusUnauthorized, "")
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+
+		// Issue time of the ticket is not kept, the earliest possible one is
+		// used, so tokens revoked while the ticket is alive reject it too.
+		c.Set("user", &jwt.Token{
+			Claims: &jwtCustomClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					ID:       userId,
+					IssuedAt: jwt.NewNumericDate(time.Now().Add(-EVENTS_TICKET_TTL)),
+				},
+				Role: ROLE_USER,
+			},
+			Valid: true,
+		})
+
+		return next(c)
+	}
+}
+
+// StreamEvents keeps the connection open and pushes events of the user as
+// server-sent events. The stream is closed when the user tokens are revoked.
+func (s *Server) StreamEvents(c echo.Context) error {
+	claims, err := getClaimsFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	ctx := c.Request().Context()
+
+	stream, err := s.events.register(claims.ID)
+	if err == errUserStreamsLimit {
+		return c.JSON(http.StatusTooManyRequests, resp("too many event streams are open"))
+	}
+	if err != nil {
+		return c.String(http.StatusServiceUnavailable, "")
+	}
+	defer s.events.unregister(stream)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	// Disables response buffering in nginx.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	heartbeat := time.NewTicker(SSE_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case payload, ok := <-stream.events:
+			if !ok {
+				return nil
+			}
+			if _, err = fmt.Fprint(w, formatSSE(payload)); err != nil {
+				return nil
+			}
+			w.Flush()
+		case <-heartbeat.C:
+			revokedAt, err := s.cachedb.GetTokensRevokedAt(claims.ID)
+			if err != nil {
+				c.Logger().Error(err)
+				return nil
+			}
+			if isTokenRevoked(claims, revokedAt) {
+				return nil
+			}
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSSE(t *testing.T) {
+	type TestCaseFormatSSE struct {
+		Payload  string
+		Expected string
+	}
+
+	testCases := []TestCaseFormatSSE{
+		{
+			Payload:  `{"type":"message.read","data":{}}`,
+			Expected: "event: message.read\ndata: {\"type\":\"message.read\",\"data\":{}}\n\n",
+		},
+		{
+			Payload:  `{"data":{}}`,
+			Expected: "data: {\"data\":{}}\n\n",
+		},
+		{
+			Payload:  "not json",
+			Expected: "data: not json\n\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Expected, formatSSE(tc.Payload))
+	}
+}
+
+type testSubscription struct {
+	events chan database.UserEvent
+}
+
+func (s *testSubscription) Events() <-chan database.UserEvent {
+	return s.events
+}
+
+func (s *testSubscription) Close() error {
+	close(s.events)
+	return nil
+}
+
+func TestEventHub(t *testing.T) {
+	sub := &testSubscription{events: make(chan database.UserEvent)}
+	h := newEventHub(sub)
+
+	first, err := h.register("first")
+	assert.Nil(t, err)
+	second, err := h.register("second")
+	assert.Nil(t, err)
+
+	sub.events <- database.UserEvent{UserId: "first", Payload: "event"}
+	assert.Equal(t, "event", <-first.events)
+	assert.Len(t, second.events, 0)
+
+	// Slow stream is disconnected when its buffer is full.
+	for i := 0; i <= SSE_STREAM_BUFFER; i++ {
+		sub.events <- database.UserEvent{UserId: "second", Payload: "event"}
+	}
+	// Events are dispatched one by one, the previous one is handled when
+	// the next one is received.
+	sub.events <- database.UserEvent{UserId: "nobody", Payload: "event"}
+	for i := 0; i < SSE_STREAM_BUFFER; i++ {
+		<-second.events
+	}
+	_, ok := <-second.events
+	assert.False(t, ok)
+	h.unregister(second)
+
+	for i := 1; i < SSE_MAX_USER_STREAMS; i++ {
+		_, err = h.register("first")
+		assert.Nil(t, err)
+	}
+	_, err = h.register("first")
+	assert.Equal(t, errUserStreamsLimit, err)
+
+	h.unregister(first)
+	first, err = h.register("first")
+	assert.Nil(t, err)
+
+	assert.Nil(t, h.Close())
+	_, ok = <-first.events
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool {
+		_, err := h.register("third")
+		return err == errEventsUnavailable
+	}, time.Second, 10*time.Millisecond)
+}
+
+type TestCaseEventsTicket struct {
+	Name           string
+	Ticket         string
+	ExpectedStatus int
+	ExpectedUserId string
+}
+
+func TestEventsTicket(t *testing.T) {
+	cache := newFakeCacher()
+	s, _ := newTestServer(t, newFakeStorager(), cache)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	c := s.e.NewContext(req, rec)
+	authenticate(c, "alice", ROLE_USER)
+
+	s.CreateEventsTicket(c)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var body struct {
+		Ticket string `json:"ticket"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.NotEmpty(t, body.Ticket)
+
+	// Requests are made one after another.
+	cases := []TestCaseEventsTicket{
+		{"no ticket", "", http.StatusOK, ""},
+		{"unknown ticket", "unknown", http.StatusUnauthorized, ""},
+		{"ticket", body.Ticket, http.StatusOK, "alice"},
+		{"used ticket", body.Ticket, http.StatusUnauthorized, ""},
+	}
+
+	for _, tc := range cases {
+		target := "/"
+		if tc.Ticket != "" {
+			target += "?" + EVENTS_TICKET_QUERY_PARAM + "=" + tc.Ticket
+		}
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		c := s.e.NewContext(req, rec)
+
+		userId := ""
+		s.authenticateEventsTicket(func(c echo.Context) error {
+			userId, _ = getUserIdFromJWT(c)
+			return c.String(http.StatusOK, "")
+		})(c)
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, tc.Name)
+		assert.Equal(t, tc.ExpectedUserId, userId, tc.Name)
+	}
+}
+
+func TestEventsTicketRevokedTokens(t *testing.T) {
+	cache := newFakeCacher()
+	s, _ := newTestServer(t, newFakeStorager(), cache)
+
+	ticket, err := cache.AddEventsTicket("alice", EVENTS_TICKET_TTL)
+	assert.Nil(t, err)
+	cache.revoked["alice"] = time.Now().UTC()
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+EVENTS_TICKET_QUERY_PARAM+"="+ticket, nil)
+	rec := httptest.NewRecorder()
+	c := s.e.NewContext(req, rec)
+
+	s.authenticateEventsTicket(s.checkTokenRevocation(func(c echo.Context) error {
+		return c.String(http.StatusOK, "")
+	}))(c)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,489 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeStorager keeps users in memory, the methods which are not used by
+// the tests panic through the embedded nil interface.
+type fakeStorager struct {
+	database.Storager
+	users    map[string]database.UserOut
+	apiKeys  []database.APIKeyOut
+	messages map[string]database.MessageOut
+	revs     []database.Revision
+	colls    []database.CollectionOut
+	access   []database.AccessLogEntry
+
+	// vanished messages are removed concurrently right before the bulk
+	// operation.
+	vanished []string
+
+	// purged lists "<step> <owner id>" of account data removals, the step
+	// equal to failPurge fails.
+	purged    []string
+	failPurge string
+}
+
+func newFakeStorager(users ...database.UserOut) *fakeStorager {
+	s := &fakeStorager{
+		users:    map[string]database.UserOut{},
+		messages: map[string]database.MessageOut{},
+	}
+	for _, u := range users {
+		s.users[u.Id.Hex()] = u
+	}
+	return s
+}
+
+// AddUser keeps usernames and provider subjects unique like the database
+// indexes do.
+func (s *fakeStorager) AddUser(u *database.User) error {
+	if _, err := s.GetUser(u.Username); err == nil {
+		return database.ErrAlreadyExist
+	}
+	if u.OIDCSubject != "" {
+		if _, err := s.GetUserByOIDCSubject(u.OIDCIssuer, u.OIDCSubject); err == nil {
+			return database.ErrAlreadyExist
+		}
+	}
+	id := primitive.NewObjectID()
+	s.users[id.Hex()] = database.UserOut{
+		Id:          id,
+		Username:    u.Username,
+		Email:       u.Email,
+		Password:    u.Password,
+		OIDCIssuer:  u.OIDCIssuer,
+		OIDCSubject: u.OIDCSubject,
+	}
+	return nil
+}
+
+func (s *fakeStorager) GetUserByOIDCSubject(issuer, subject string) (database.UserOut, error) {
+	for _, u := range s.users {
+		if u.OIDCIssuer == issuer && u.OIDCSubject == subject {
+			return u, nil
+		}
+	}
+	return database.UserOut{}, mongo.ErrNoDocuments
+}
+
+func (s *fakeStorager) GetUser(username string) (database.UserOut, error) {
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return database.UserOut{}, mongo.ErrNoDocuments
+}
+
+func (s *fakeStorager) GetUserById(id string) (database.UserOut, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return database.UserOut{}, mongo.ErrNoDocuments
+	}
+	return u, nil
+}
+
+func (s *fakeStorager) UpdateLastLogin(userId string, l database.LoginInfo) error {
+	u := s.users[userId]
+	u.LastLogin = l
+	s.users[userId] = u
+	return nil
+}
+
+func (s *fakeStorager) UseTOTPStep(userId string, step int64) (bool, error) {
+	u := s.users[userId]
+	if u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	s.users[userId] = u
+	return true, nil
+}
+
+func (s *fakeStorager) GetAPIKey(hashedKey string) (database.APIKeyOut, error) {
+	for _, k := range s.apiKeys {
+		if k.HashedKey == hashedKey {
+			return k, nil
+		}
+	}
+	return database.APIKeyOut{}, mongo.ErrNoDocuments
+}
+
+func (s *fakeStorager) TouchAPIKey(id string, at time.Time) error {
+	for i, k := range s.apiKeys {
+		if k.Id.Hex() == id {
+			s.apiKeys[i].LastUsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *fakeStorager) addMessages(msgs ...database.MessageOut) {
+	for _, msg := range msgs {
+		s.messages[msg.Id.Hex()] = msg
+	}
+}
+
+func (s *fakeStorager) GetMessage(id string) (database.MessageOut, error) {
+	msg, ok := s.messages[id]
+	if !ok {
+		return database.MessageOut{}, mongo.ErrNoDocuments
+	}
+	return msg, nil
+}
+
+// UpdateMessage replaces content of the message when its version is equal
+// to the expected one.
+func (s *fakeStorager) UpdateMessage(id string, version int64, m *database.Message) error {
+	msg, ok := s.messages[id]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	if msg.Version != version {
+		return database.ErrVersionConflict
+	}
+	msg.Content = m.Content
+	msg.EncodingType = m.EncodingType
+	msg.Password = m.Password
+	msg.IsPrivate = m.IsPrivate
+	msg.Version++
+	s.messages[id] = msg
+	return nil
+}
+
+func (s *fakeStorager) AddRevision(r *database.Revision) (int, error) {
+	r.Number = len(s.revs) + 1
+	s.revs = append(s.revs, *r)
+	return r.Number, nil
+}
+
+func (s *fakeStorager) PruneRevisions(messageId string, keep int) error {
+	return nil
+}
+
+// SearchMessages matches the text in titles, tags and content of public
+// plaintext messages and messages of the owner, like the text index does.
+func (s *fakeStorager) SearchMessages(q database.SearchQuery) (database.MessagesOut, int64, error) {
+	messages := database.MessagesOut{}
+	for _, msg := range s.messages {
+		public := msg.EncodingType == "plaintext" && !msg.IsPrivate &&
+			!msg.IsOneTime && !msg.OnlyOwnerView
+		if !public && (q.OwnerId == "" || msg.OwnerId != q.OwnerId) {
+			continue
+		}
+		if strings.Contains(msg.Title, q.Text) || strings.Contains(msg.Content, q.Text) ||
+			slices.Contains(msg.Tags, q.Text) {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Id.Hex() < messages[j].Id.Hex()
+	})
+	return messages, int64(len(messages)), nil
+}
+
+func (s *fakeStorager) GetMessageByPublicId(publicId string) (database.MessageOut, error) {
+	for _, msg := range s.messages {
+		if msg.PublicId == publicId {
+			return msg, nil
+		}
+	}
+	return database.MessageOut{}, mongo.ErrNoDocuments
+}
+
+func (s *fakeStorager) CountMessageRead(id string, at time.Time) error {
+	msg, ok := s.messages[id]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	msg.ReadCount++
+	msg.LastReadAt = &at
+	s.messages[id] = msg
+	return nil
+}
+
+func (s *fakeStorager) GetUserMessages(ownerId string, f database.MessageFilter) (database.MessagesOut, error) {
+	messages := database.MessagesOut{}
+	for _, msg := range s.messages {
+		if msg.OwnerId == ownerId {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (s *fakeStorager) AddAccessLogEntry(e *database.AccessLogEntry) error {
+	s.access = append(s.access, *e)
+	return nil
+}
+
+func (s *fakeStorager) GetMessagesByIds(ids []string) (database.MessagesOut, error) {
+	messages := database.MessagesOut{}
+	for _, msg := range s.messages {
+		if slices.Contains(ids, msg.Id.Hex()) || slices.Contains(ids, msg.PublicId) {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (s *fakeStorager) BulkMessages(ownerId string, ops []database.BulkMessageOp) (map[int]error, error) {
+	for _, id := range s.vanished {
+		delete(s.messages, id)
+	}
+
+	failed := map[int]error{}
+	for i, op := range ops {
+		msg, ok := s.messages[op.Id]
+		if !ok || msg.OwnerId != ownerId {
+			failed[i] = mongo.ErrNoDocuments
+			continue
+		}
+		if op.Delete {
+			delete(s.messages, op.Id)
+			continue
+		}
+		msg.Version++
+		s.messages[op.Id] = msg
+	}
+	return failed, nil
+}
+
+func (s *fakeStorager) GetEventWebhooks(ownerId, event string) ([]database.WebhookOut, error) {
+	return nil, nil
+}
+
+func (s *fakeStorager) TouchMessage(id string, at time.Time) error {
+	msg, ok := s.messages[id]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	msg.LastAccessedAt = &at
+	s.messages[id] = msg
+	return nil
+}
+
+func (s *fakeStorager) DeleteMessage(id string) error {
+	if _, ok := s.messages[id]; !ok {
+		return mongo.ErrNoDocuments
+	}
+	delete(s.messages, id)
+	return nil
+}
+
+func (s *fakeStorager) GetCollection(ownerId, id string) (database.CollectionOut, error) {
+	for _, coll := range s.colls {
+		if coll.OwnerId == ownerId && coll.Id.Hex() == id {
+			return coll, nil
+		}
+	}
+	return database.CollectionOut{}, mongo.ErrNoDocuments
+}
+
+func (s *fakeStorager) GetCollections(ownerId string) ([]database.CollectionOut, error) {
+	colls := []database.CollectionOut{}
+	for _, coll := range s.colls {
+		if coll.OwnerId == ownerId {
+			colls = append(colls, coll)
+		}
+	}
+	return colls, nil
+}
+
+func (s *fakeStorager) GetUsersToDelete(before time.Time) ([]database.UserOut, error) {
+	users := []database.UserOut{}
+	for _, u := range s.users {
+		if u.DeletionScheduledAt != nil && !u.DeletionScheduledAt.After(before) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (s *fakeStorager) purge(step, ownerId string) error {
+	if step == s.failPurge {
+		return errors.New("can't delete " + step)
+	}
+	s.purged = append(s.purged, step+" "+ownerId)
+	return nil
+}
+
+func (s *fakeStorager) DeleteUserMessages(ownerId string) (int64, error) {
+	return 0, s.purge("messages", ownerId)
+}
+
+func (s *fakeStorager) DeleteUserAPIKeys(ownerId string) error {
+	return s.purge("apikeys", ownerId)
+}
+
+func (s *fakeStorager) DeleteUserCollections(ownerId string) error {
+	return s.purge("collections", ownerId)
+}
+
+func (s *fakeStorager) DeleteUserAccessLog(ownerId string) error {
+	return s.purge("accesslog", ownerId)
+}
+
+func (s *fakeStorager) DeleteUserWebhooks(ownerId string) error {
+	return s.purge("webhooks", ownerId)
+}
+
+func (s *fakeStorager) DeleteUser(userId string) error {
+	if err := s.purge("user", userId); err != nil {
+		return err
+	}
+	delete(s.users, userId)
+	return nil
+}
+
+// fakeCacher keeps failed logins and published events in memory.
+type fakeCacher struct {
+	database.Cacher
+	failedLogins map[string]int64
+	challenges   map[string]string
+	signups      map[string]*database.User
+	revoked      map[string]time.Time
+	events       []database.UserEvent
+	failures     map[string]int64
+	tickets      map[string]string
+}
+
+func newFakeCacher() *fakeCacher {
+	return &fakeCacher{
+		failedLogins: map[string]int64{},
+		challenges:   map[string]string{},
+		signups:      map[string]*database.User{},
+		revoked:      map[string]time.Time{},
+		failures:     map[string]int64{},
+		tickets:      map[string]string{},
+	}
+}
+
+func (c *fakeCacher) GetUser(token string) (*database.User, error) {
+	u, ok := c.signups[token]
+	if !ok {
+		return nil, database.ErrTokenNotFound
+	}
+	delete(c.signups, token)
+	return u, nil
+}
+
+func (c *fakeCacher) AddFailedLogin(username string, lockout time.Duration) (int64, error) {
+	c.failedLogins[username]++
+	return c.failedLogins[username], nil
+}
+
+func (c *fakeCacher) GetFailedLogins(username string) (int64, error) {
+	return c.failedLogins[username], nil
+}
+
+func (c *fakeCacher) ResetFailedLogins(username string) error {
+	delete(c.failedLogins, username)
+	return nil
+}
+
+func (c *fakeCacher) AddAccessFailure(messageId, ip string, window time.Duration) (int64, error) {
+	c.failures[messageId+":"+ip]++
+	return c.failures[messageId+":"+ip], nil
+}
+
+func (c *fakeCacher) GetLoginChallenge(token string) (string, error) {
+	userId, ok := c.challenges[token]
+	if !ok {
+		return "", database.ErrTokenNotFound
+	}
+	return userId, nil
+}
+
+func (c *fakeCacher) RevokeTokens(userId string, at time.Time) error {
+	c.revoked[userId] = at
+	return nil
+}
+
+func (c *fakeCacher) GetTokensRevokedAt(userId string) (time.Time, error) {
+	return c.revoked[userId], nil
+}
+
+func (c *fakeCacher) AddEventsTicket(userId string, ttl time.Duration) (string, error) {
+	ticket := primitive.NewObjectID().Hex()
+	c.tickets[ticket] = userId
+	return ticket, nil
+}
+
+func (c *fakeCacher) GetEventsTicket(ticket string) (string, error) {
+	userId, ok := c.tickets[ticket]
+	if !ok {
+		return "", database.ErrTokenNotFound
+	}
+	delete(c.tickets, ticket)
+	return userId, nil
+}
+
+func (c *fakeCacher) PublishUserEvent(userId string, event string) error {
+	c.events = append(c.events, database.UserEvent{UserId: userId, Payload: event})
+	return nil
+}
+
+type notification struct {
+	Username string
+	Subject  string
+}
+
+// fakeNotifier remembers sent notifications.
+type fakeNotifier struct {
+	sent []notification
+}
+
+func (n *fakeNotifier) Notify(u database.UserOut, subject, text string) error {
+	n.sent = append(n.sent, notification{Username: u.Username, Subject: subject})
+	return nil
+}
+
+// authenticate puts the user token into the context like echojwt does.
+func authenticate(c echo.Context, userId, role string) {
+	c.Set("user", &jwt.Token{
+		Claims: &jwtCustomClaims{
+			RegisteredClaims: jwt.RegisteredClaims{ID: userId},
+			Role:             role,
+		},
+		Valid: true,
+	})
+}
+
+// newTestServer creates server with the fake databases and notifier and
+// the file auditor in the test directory.
+func newTestServer(t *testing.T, db *fakeStorager, cache *fakeCacher) (*Server, *fakeNotifier) {
+	auditor, err := audit.NewFileAuditor(filepath.Join(t.TempDir(), "audit.log"),
+		[]byte("audit-test-key"), "")
+	assert.Nil(t, err)
+	t.Cleanup(func() { auditor.Close() })
+
+	e := echo.New()
+	e.IPExtractor = newIPExtractor(nil)
+
+	notifier := new(fakeNotifier)
+	return &Server{
+		e:        e,
+		db:       db,
+		cachedb:  cache,
+		notifier: notifier,
+		auditor:  auditor,
+		jwtKeys:  newHMACKeySet("secret"),
+	}, notifier
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/importers"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MAX_IMPORT_SIZE    = 8 << 20
+	MAX_IMPORT_RECORDS = 1000
+
+	IMPORT_STATUS_CREATED = "created"
+	IMPORT_STATUS_VALID   = "valid"
+	IMPORT_STATUS_INVALID = "invalid"
+	IMPORT_STATUS_FAILED  = "failed"
+)
+
+// ImportOptions are applied to every imported record. Imported messages are
+// always private and visible to the owner only, so secrets never show up in
+// the public list.
+type ImportOptions struct {
+	EncodingType string
+	Password     string
+	CollectionId string
+	DryRun       bool
+}
+
+type ImportResult struct {
+	Record int    `json:"record"`
+	Title  string `json:"title"`
+	Id     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// invalidReason explains the most common reasons why imported record
+// can't be a message.
+func invalidReason(msg *Message) string {
+	switch {
+	case msg.Content == "":
+		return "value is empty"
+	case len(msg.Content) > MAX_CONTENT_SIZE:
+		return "value is longer than " + strconv.Itoa(MAX_CONTENT_SIZE) + " symbols"
+	case len(msg.Title) > MAX_TITLE_SIZE:
+		return "title is longer than " + strconv.Itoa(MAX_TITLE_SIZE) + " symbols"
+	case (msg.EncodingType == "internal" || msg.EncodingType == "aes") &&
+		len(msg.Content) < MIN_CONTENT_SIZE:
+		return "value is shorter than " + strconv.Itoa(MIN_CONTENT_SIZE) +
+			" symbols required by " + msg.EncodingType + " encoding"
+	default:
+		return "message is invalid"
+	}
+}
+
+func importMessage(r importers.Record, opts ImportOptions) *Message {
+	return &Message{
+		Content:       r.Content,
+		IsPrivate:     true,
+		EncodingType:  opts.EncodingType,
+		Password:      opts.Password,
+		OnlyOwnerView: true,
+		Title:         r.Title,
+		Tags:          r.Tags,
+		CollectionId:  opts.CollectionId,
+	}
+}
+
+// ImportRecords creates messages of the owner from imported records. In dry
+// run records are only validated. Import stops on the first failed record,
+// results of processed records are returned with the error, so created
+// messages are still reported.
+func ImportRecords(db database.Storager, ownerId string, records []importers.Record,
+	opts ImportOptions) ([]ImportResult, error) {
+	coll, err := checkCollection(db, ownerId, opts.CollectionId)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ImportResult, 0, len(records))
+	for i, r := range records {
+		result := ImportResult{Record: i + 1, Title: r.Title, Status: IMPORT_STATUS_VALID}
+
+		msg := importMessage(r, opts)
+		applyDefaults(msg, coll.Defaults)
+		if !msg.isValid() {
+			result.Status = IMPORT_STATUS_INVALID
+			result.Reason = invalidReason(msg)
+			results = append(results, result)
+			continue
+		}
+
+		if opts.DryRun {
+			results = append(results, result)
+			continue
+		}
+
+		err = msg.formatToEncodingType()
+		if err == nil {
+			result.Id, err = db.AddMessage(msg.toDatabaseFormat(ownerId))
+		}
+		if err != nil {
+			result.Status = IMPORT_STATUS_FAILED
+			result.Reason = "message is not created"
+			return append(results, result), err
+		}
+		result.Status = IMPORT_STATUS_CREATED
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ImportMessages reads multipart form with the file exported from other
+// tool, format, encoding_type, password, collection_id and dry_run fields.
+func (s *Server) ImportMessages(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	dryRun, _ := strconv.ParseBool(c.FormValue("dry_run"))
+	opts := ImportOptions{
+		EncodingType: c.FormValue("encoding_type"),
+		Password:     c.FormValue("password"),
+		CollectionId: c.FormValue("collection_id"),
+		DryRun:       dryRun,
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp("file is required"))
+	}
+	if file.Size > MAX_IMPORT_SIZE {
+		return c.JSON(http.StatusRequestEntityTooLarge, resp("file is too large"))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, MAX_IMPORT_SIZE))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	records, err := importers.Parse(c.FormValue("format"), bytes.NewReader(data))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp(err.Error()))
+	}
+	if len(records) > MAX_IMPORT_RECORDS {
+		return c.JSON(http.StatusBadRequest, resp("file has too many records"))
+	}
+
+	results, err := ImportRecords(s.db, userId, records, opts)
+	for _, r := range results {
+		if r.Status == IMPORT_STATUS_CREATED {
+			s.recordAudit(c, audit.Event{
+				Type:     AUDIT_MESSAGE_CREATE,
+				TargetId: r.Id,
+				Details:  map[string]string{"owner_id": userId, "source": "import"},
+			})
+		}
+	}
+	if err == errCollectionNotFound {
+		return c.JSON(http.StatusBadRequest, resp(err.Error()))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		// Created messages are reported, so retry can skip them.
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"message": "import is stopped, records after the failed one are not imported",
+			"dry_run": opts.DryRun,
+			"results": results,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dry_run": opts.DryRun,
+		"results": results,
+	})
+}
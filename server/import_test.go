@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/importers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportRecordsDryRun(t *testing.T) {
+	records := []importers.Record{
+		{Title: "DB_PASSWORD", Content: "long enough database password"},
+		{Title: "DEBUG", Content: "true"},
+		{Title: "EMPTY", Content: ""},
+	}
+
+	results, err := ImportRecords(nil, "owner", records,
+		ImportOptions{EncodingType: "internal", DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []ImportResult{
+		{Record: 1, Title: "DB_PASSWORD", Status: IMPORT_STATUS_VALID},
+		{Record: 2, Title: "DEBUG", Status: IMPORT_STATUS_INVALID,
+			Reason: "value is shorter than 16 symbols required by internal encoding"},
+		{Record: 3, Title: "EMPTY", Status: IMPORT_STATUS_INVALID, Reason: "value is empty"},
+	}, results)
+
+	results, err = ImportRecords(nil, "owner", records[1:2],
+		ImportOptions{EncodingType: "plaintext", DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, IMPORT_STATUS_VALID, results[0].Status)
+}
+
+// failingStorager creates the given amount of messages and fails after that.
+type failingStorager struct {
+	database.Storager
+	left int
+}
+
+func (s *failingStorager) AddMessage(m *database.Message) (string, error) {
+	if s.left == 0 {
+		return "", errors.New("database is down")
+	}
+	s.left--
+	return "created", nil
+}
+
+func TestImportRecordsReportsCreatedOnError(t *testing.T) {
+	records := []importers.Record{
+		{Title: "FIRST", Content: "first"},
+		{Title: "SECOND", Content: "second"},
+		{Title: "THIRD", Content: "third"},
+	}
+
+	results, err := ImportRecords(&failingStorager{left: 1}, "owner", records,
+		ImportOptions{EncodingType: "plaintext"})
+	assert.Error(t, err)
+	assert.Equal(t, []ImportResult{
+		{Record: 1, Title: "FIRST", Id: "created", Status: IMPORT_STATUS_CREATED},
+		{Record: 2, Title: "SECOND", Status: IMPORT_STATUS_FAILED, Reason: "message is not created"},
+	}, results)
+}
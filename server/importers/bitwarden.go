@@ -0,0 +1,111 @@
+package importers
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+const (
+	bitwardenLogin = 1 + iota
+	bitwardenSecureNote
+	bitwardenCard
+	bitwardenIdentity
+)
+
+type bitwardenFile struct {
+	Encrypted bool `json:"encrypted"`
+	Folders   []struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"folders"`
+	Items []bitwardenItem `json:"items"`
+}
+
+type bitwardenItem struct {
+	Type     int    `json:"type"`
+	Name     string `json:"name"`
+	Notes    string `json:"notes"`
+	FolderId string `json:"folderId"`
+	Login    *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+		URIs     []struct {
+			URI string `json:"uri"`
+		} `json:"uris"`
+	} `json:"login"`
+	Card *struct {
+		CardholderName string `json:"cardholderName"`
+		Brand          string `json:"brand"`
+		Number         string `json:"number"`
+		ExpMonth       string `json:"expMonth"`
+		ExpYear        string `json:"expYear"`
+		Code           string `json:"code"`
+	} `json:"card"`
+	Identity map[string]interface{} `json:"identity"`
+	Fields   []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+// parseBitwarden reads unencrypted Bitwarden JSON export. Folder of the item
+// becomes its tag.
+func parseBitwarden(r io.Reader) ([]Record, error) {
+	file := bitwardenFile{}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	if file.Encrypted {
+		return nil, ErrEncryptedFile
+	}
+
+	folders := make(map[string]string, len(file.Folders))
+	for _, f := range file.Folders {
+		folders[f.Id] = f.Name
+	}
+
+	records := make([]Record, 0, len(file.Items))
+	for _, item := range file.Items {
+		content := fields{}
+
+		switch {
+		case item.Type == bitwardenLogin && item.Login != nil:
+			content.add("Username", item.Login.Username)
+			content.add("Password", item.Login.Password)
+			content.add("TOTP", item.Login.TOTP)
+			for i, u := range item.Login.URIs {
+				content.add("URI "+strconv.Itoa(i+1), u.URI)
+			}
+		case item.Type == bitwardenCard && item.Card != nil:
+			content.add("Cardholder", item.Card.CardholderName)
+			content.add("Brand", item.Card.Brand)
+			content.add("Number", item.Card.Number)
+			if item.Card.ExpMonth != "" || item.Card.ExpYear != "" {
+				content.add("Expires", item.Card.ExpMonth+"/"+item.Card.ExpYear)
+			}
+			content.add("Code", item.Card.Code)
+		case item.Type == bitwardenIdentity:
+			for _, key := range sortedKeys(item.Identity) {
+				if value, ok := item.Identity[key].(string); ok {
+					content.add(key, value)
+				}
+			}
+		}
+
+		for _, f := range item.Fields {
+			content.add(f.Name, f.Value)
+		}
+		content.add("Notes", item.Notes)
+
+		record := Record{Title: item.Name, Content: content.String()}
+		if folder := folders[item.FolderId]; folder != "" {
+			record.Tags = []string{folder}
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,71 @@
+package importers
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// parseCSV reads name,value rows. Header row is optional and detected by
+// "name" and "value" column names, other columns are added to content.
+func parseCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return []Record{}, nil
+	}
+
+	nameCol, valueCol := 0, 1
+	var header []string
+	if col := columnIndex(rows[0], "name"); col >= 0 {
+		header, nameCol = rows[0], col
+		valueCol = columnIndex(header, "value")
+		if valueCol < 0 {
+			return nil, errors.New("csv header has name column without value column")
+		}
+		rows = rows[1:]
+	}
+
+	records := make([]Record, 0, len(rows))
+	for _, row := range rows {
+		if len(row) <= nameCol || len(row) <= valueCol {
+			return nil, errors.New("csv row should have name and value columns")
+		}
+
+		content := fields{}
+		if header == nil {
+			content = fields{row[valueCol]}
+		} else {
+			content.add("Value", row[valueCol])
+			for i, name := range header {
+				if i != nameCol && i != valueCol && i < len(row) {
+					content.add(name, row[i])
+				}
+			}
+			if len(content) == 1 {
+				content = fields{row[valueCol]}
+			}
+		}
+
+		records = append(records, Record{Title: row[nameCol], Content: content.String()})
+	}
+
+	return records, nil
+}
+
+func columnIndex(row []string, name string) int {
+	for i, col := range row {
+		if strings.EqualFold(strings.TrimSpace(col), name) {
+			return i
+		}
+	}
+	return -1
+}
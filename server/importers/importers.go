@@ -0,0 +1,75 @@
+// Package importers reads secrets exported from other tools and converts
+// them into records which become deepenc messages.
+package importers
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	FORMAT_KEEPASS   = "keepass"
+	FORMAT_BITWARDEN = "bitwarden"
+	FORMAT_ENV       = "env"
+	FORMAT_CSV       = "csv"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown import format, use keepass, bitwarden, env or csv")
+	ErrEncryptedFile = errors.New("encrypted exports are not supported, export without encryption")
+)
+
+// Record is one secret from the imported file.
+type Record struct {
+	Title   string   `json:"title"`
+	Content string   `json:"-"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+type parser func(r io.Reader) ([]Record, error)
+
+var parsers = map[string]parser{
+	FORMAT_KEEPASS:   parseKeePass,
+	FORMAT_BITWARDEN: parseBitwarden,
+	FORMAT_ENV:       parseEnv,
+	FORMAT_CSV:       parseCSV,
+}
+
+// Parse reads all records of the format from r.
+func Parse(format string, r io.Reader) ([]Record, error) {
+	parse, ok := parsers[strings.ToLower(format)]
+	if !ok {
+		return nil, ErrUnknownFormat
+	}
+	return parse(r)
+}
+
+// fields joins non-empty named values into message content, one per line.
+type fields []string
+
+func (f *fields) add(name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	if strings.Contains(value, "\n") {
+		*f = append(*f, name+":\n"+value)
+		return
+	}
+	*f = append(*f, name+": "+value)
+}
+
+func (f fields) String() string {
+	return strings.Join(f, "\n")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
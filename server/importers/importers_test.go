@@ -0,0 +1,148 @@
+package importers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const keePassXML = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Root>
+		<Group>
+			<Name>Database</Name>
+			<Entry>
+				<String><Key>Title</Key><Value>Router</Value></String>
+				<String><Key>Password</Key><Value>admin123</Value></String>
+			</Entry>
+			<Group>
+				<Name>Servers</Name>
+				<Entry>
+					<Tags>prod;db</Tags>
+					<String><Key>Title</Key><Value>Postgres</Value></String>
+					<String><Key>UserName</Key><Value>postgres</Value></String>
+					<String><Key>Password</Key><Value>s3cret</Value></String>
+					<String><Key>Port</Key><Value>5432</Value></String>
+					<String><Key>Notes</Key><Value>primary</Value></String>
+				</Entry>
+			</Group>
+			<Group>
+				<Name>Recycle Bin</Name>
+				<Entry>
+					<String><Key>Title</Key><Value>Deleted</Value></String>
+				</Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>`
+
+func TestParseKeePass(t *testing.T) {
+	records, err := Parse(FORMAT_KEEPASS, strings.NewReader(keePassXML))
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Title: "Router", Content: "Password: admin123", Tags: []string{}},
+		{
+			Title:   "Postgres",
+			Content: "Username: postgres\nPassword: s3cret\nPort: 5432\nNotes: primary",
+			Tags:    []string{"Servers", "prod", "db"},
+		},
+	}, records)
+}
+
+const bitwardenJSON = `{
+	"encrypted": false,
+	"folders": [{"id": "f1", "name": "Work"}],
+	"items": [
+		{
+			"type": 1,
+			"name": "GitHub",
+			"folderId": "f1",
+			"notes": "2fa enabled",
+			"login": {
+				"username": "bob",
+				"password": "hunter2",
+				"uris": [{"uri": "https://github.com"}]
+			},
+			"fields": [{"name": "recovery", "value": "abcd-efgh"}]
+		},
+		{
+			"type": 2,
+			"name": "Wifi",
+			"folderId": null,
+			"notes": "password is on the router"
+		},
+		{
+			"type": 3,
+			"name": "Visa",
+			"card": {"number": "4111111111111111", "expMonth": "12", "expYear": "2030", "code": "123"}
+		}
+	]
+}`
+
+func TestParseBitwarden(t *testing.T) {
+	records, err := Parse(FORMAT_BITWARDEN, strings.NewReader(bitwardenJSON))
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{
+			Title: "GitHub",
+			Content: "Username: bob\nPassword: hunter2\nURI 1: https://github.com\n" +
+				"recovery: abcd-efgh\nNotes: 2fa enabled",
+			Tags: []string{"Work"},
+		},
+		{Title: "Wifi", Content: "Notes: password is on the router"},
+		{Title: "Visa", Content: "Number: 4111111111111111\nExpires: 12/2030\nCode: 123"},
+	}, records)
+}
+
+func TestParseBitwardenEncrypted(t *testing.T) {
+	_, err := Parse(FORMAT_BITWARDEN, strings.NewReader(`{"encrypted": true, "items": []}`))
+	assert.Equal(t, ErrEncryptedFile, err)
+}
+
+func TestParseEnv(t *testing.T) {
+	env := `# database
+DB_HOST=localhost
+export DB_PASSWORD="p@ss word"
+API_KEY='abc#def'
+DEBUG=true # inline comment
+EMPTY=
+`
+	records, err := Parse(FORMAT_ENV, strings.NewReader(env))
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Title: "DB_HOST", Content: "localhost"},
+		{Title: "DB_PASSWORD", Content: "p@ss word"},
+		{Title: "API_KEY", Content: "abc#def"},
+		{Title: "DEBUG", Content: "true"},
+		{Title: "EMPTY", Content: ""},
+	}, records)
+
+	_, err = Parse(FORMAT_ENV, strings.NewReader("NOT A VARIABLE"))
+	assert.Error(t, err)
+}
+
+func TestParseCSV(t *testing.T) {
+	records, err := Parse(FORMAT_CSV, strings.NewReader("db,postgres://db\napi,key-123\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Title: "db", Content: "postgres://db"},
+		{Title: "api", Content: "key-123"},
+	}, records)
+
+	records, err = Parse(FORMAT_CSV,
+		strings.NewReader("Value,Name,Owner\nkey-123,api,ops\nkey-456,web,\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Title: "api", Content: "Value: key-123\nOwner: ops"},
+		{Title: "web", Content: "key-456"},
+	}, records)
+
+	_, err = Parse(FORMAT_CSV, strings.NewReader("name,owner\napi,ops\n"))
+	assert.Error(t, err)
+}
+
+func TestParseUnknownFormat(t *testing.T) {
+	_, err := Parse("lastpass", strings.NewReader(""))
+	assert.Equal(t, ErrUnknownFormat, err)
+}
@@ -0,0 +1,100 @@
+package importers
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// KEEPASS_RECYCLE_BIN is the group with deleted entries which are skipped.
+const KEEPASS_RECYCLE_BIN = "Recycle Bin"
+
+type keePassFile struct {
+	Root struct {
+		Groups []keePassGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type keePassGroup struct {
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	Tags    string `xml:"Tags"`
+	Strings []struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	} `xml:"String"`
+}
+
+// parseKeePass reads KeePass 2 XML export. Names of groups become tags, the
+// top level group is the database itself and is not used.
+func parseKeePass(r io.Reader) ([]Record, error) {
+	file := keePassFile{}
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0)
+	for _, root := range file.Root.Groups {
+		records = appendKeePassEntries(records, root, nil)
+		for _, g := range root.Groups {
+			records = appendKeePassGroup(records, g, nil)
+		}
+	}
+
+	return records, nil
+}
+
+func appendKeePassGroup(records []Record, g keePassGroup, path []string) []Record {
+	if g.Name == KEEPASS_RECYCLE_BIN {
+		return records
+	}
+
+	path = append(path[:len(path):len(path)], g.Name)
+	records = appendKeePassEntries(records, g, path)
+	for _, child := range g.Groups {
+		records = appendKeePassGroup(records, child, path)
+	}
+
+	return records
+}
+
+func appendKeePassEntries(records []Record, g keePassGroup, path []string) []Record {
+	for _, e := range g.Entries {
+		values := make(map[string]string, len(e.Strings))
+		custom := make([]string, 0)
+		for _, s := range e.Strings {
+			values[s.Key] = s.Value
+			switch s.Key {
+			case "Title", "UserName", "Password", "URL", "Notes":
+			default:
+				custom = append(custom, s.Key)
+			}
+		}
+
+		content := fields{}
+		content.add("Username", values["UserName"])
+		content.add("Password", values["Password"])
+		content.add("URL", values["URL"])
+		for _, key := range custom {
+			content.add(key, values[key])
+		}
+		content.add("Notes", values["Notes"])
+
+		tags := append([]string{}, path...)
+		tags = append(tags, strings.FieldsFunc(e.Tags, func(r rune) bool {
+			return r == ';' || r == ','
+		})...)
+
+		records = append(records, Record{
+			Title:   values["Title"],
+			Content: content.String(),
+			Tags:    tags,
+		})
+	}
+
+	return records
+}
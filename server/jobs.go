@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	ACCOUNT_PURGE_INTERVAL   = 10 * time.Minute
+	MESSAGES_EXPIRE_INTERVAL = time.Minute
+	WEBHOOKS_QUEUE_INTERVAL  = 5 * time.Second
+)
+
+func (s *Server) startJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopJobs = cancel
+
+	go s.runPeriodically(ctx, ACCOUNT_PURGE_INTERVAL, s.purgeDeletedAccounts)
+	go s.runPeriodically(ctx, MESSAGES_EXPIRE_INTERVAL, s.deleteExpiredMessages)
+	go s.runPeriodically(ctx, WEBHOOKS_QUEUE_INTERVAL, s.deliverWebhooks)
+}
+
+func (s *Server) runPeriodically(ctx context.Context, interval time.Duration, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job(); err != nil {
+			s.e.Logger.Error(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedAccounts removes accounts whose deletion grace period is over
+// together with every message they own. Failed account is left for the next
+// run and doesn't stop purging of other accounts.
+func (s *Server) purgeDeletedAccounts() error {
+	users, err := s.db.GetUsersToDelete(time.Now())
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, u := range users {
+		if err = s.purgeAccount(u.Id.Hex()); err != nil {
+			s.e.Logger.Errorf("can't purge account %s: %v", u.Id.Hex(), err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// purgeAccount removes account data. The user is removed last, so partially
+// purged account is found and purged again by the next run.
+func (s *Server) purgeAccount(userId string) error {
+	deleted, err := s.db.DeleteUserMessages(userId)
+	if err != nil {
+		return err
+	}
+
+	if err = s.db.DeleteUserAPIKeys(userId); err != nil {
+		return err
+	}
+
+	if err = s.db.DeleteUserCollections(userId); err != nil {
+		return err
+	}
+
+	if err = s.db.DeleteUserAccessLog(userId); err != nil {
+		return err
+	}
+
+	if err = s.db.DeleteUserWebhooks(userId); err != nil {
+		return err
+	}
+
+	if err = s.cachedb.RevokeTokens(userId, time.Now()); err != nil {
+		return err
+	}
+	if err = s.auditor.Record(tokensRevokedEvent(userId, "account deleted")); err != nil {
+		s.e.Logger.Error(err)
+	}
+
+	if err = s.db.DeleteUser(userId); err != nil {
+		return err
+	}
+
+	s.e.Logger.Infof("deleted account %s with %d messages", userId, deleted)
+	return nil
+}
+
+func (s *Server) deleteExpiredMessages() error {
+	deleted, err := s.db.DeleteExpiredMessages(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if len(deleted) > 0 {
+		s.e.Logger.Infof("deleted %d expired messages", len(deleted))
+	}
+
+	for _, msg := range deleted {
+		if err = s.emitMessageEvent(WEBHOOK_EVENT_EXPIRED, msg); err != nil {
+			s.e.Logger.Error(err)
+		}
+	}
+
+	return nil
+}
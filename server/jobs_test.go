@@ -0,0 +1,122 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TestCasePurgeAccount struct {
+	Name            string
+	DeletionAt      *time.Time
+	FailPurge       string
+	ExpectedErr     bool
+	ExpectedPurged  []string
+	ExpectedRevoked bool
+	ExpectedKept    bool
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	cases := []TestCasePurgeAccount{
+		{
+			Name:       "grace period is over",
+			DeletionAt: &past,
+			ExpectedPurged: []string{
+				"messages", "apikeys", "collections", "accesslog", "webhooks", "user",
+			},
+			ExpectedRevoked: true,
+		},
+		{
+			Name:         "grace period is not over",
+			DeletionAt:   &future,
+			ExpectedKept: true,
+		},
+		{
+			Name:         "deletion is not scheduled",
+			ExpectedKept: true,
+		},
+		{
+			Name:           "user data is not deleted",
+			DeletionAt:     &past,
+			FailPurge:      "collections",
+			ExpectedErr:    true,
+			ExpectedPurged: []string{"messages", "apikeys"},
+			ExpectedKept:   true,
+		},
+		{
+			Name:       "user is not deleted",
+			DeletionAt: &past,
+			FailPurge:  "user",
+			ExpectedPurged: []string{
+				"messages", "apikeys", "collections", "accesslog", "webhooks",
+			},
+			ExpectedRevoked: true,
+			ExpectedErr:     true,
+			ExpectedKept:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			u := database.UserOut{
+				Id:                  primitive.NewObjectID(),
+				Username:            "alice",
+				DeletionScheduledAt: tc.DeletionAt,
+			}
+			db := newFakeStorager(u)
+			db.failPurge = tc.FailPurge
+			cache := newFakeCacher()
+			s, _ := newTestServer(t, db, cache)
+
+			err := s.purgeDeletedAccounts()
+			if tc.ExpectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			var purged []string
+			for _, step := range tc.ExpectedPurged {
+				purged = append(purged, step+" "+u.Id.Hex())
+			}
+			assert.Equal(t, purged, db.purged)
+
+			_, kept := db.users[u.Id.Hex()]
+			assert.Equal(t, tc.ExpectedKept, kept)
+
+			// Tokens are revoked before the user is deleted.
+			_, revoked := cache.revoked[u.Id.Hex()]
+			assert.Equal(t, tc.ExpectedRevoked, revoked)
+
+			_, total, err := s.auditor.Query(audit.Filter{Type: AUDIT_TOKENS_REVOKED}, 0, 10)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.ExpectedRevoked, total == 1)
+		})
+	}
+}
+
+func TestPurgeDeletedAccountsRetriesPartialPurge(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	u := database.UserOut{
+		Id:                  primitive.NewObjectID(),
+		Username:            "alice",
+		DeletionScheduledAt: &past,
+	}
+	db := newFakeStorager(u)
+	db.failPurge = "webhooks"
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	assert.Error(t, s.purgeDeletedAccounts())
+	assert.Contains(t, db.users, u.Id.Hex())
+
+	db.failPurge = ""
+	assert.Nil(t, s.purgeDeletedAccounts())
+	assert.NotContains(t, db.users, u.Id.Hex())
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// jwtKeySet holds the key used to sign new tokens and every key accepted
+// for verification. Verification keys are looked up by kid header so keys
+// can be rotated without invalidating issued tokens.
+type jwtKeySet struct {
+	method     jwt.SigningMethod
+	signingKid string
+	signingKey interface{}
+	verifyKeys map[string]interface{}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func newHMACKeySet(secret string) *jwtKeySet {
+	return &jwtKeySet{
+		method:     jwt.SigningMethodHS256,
+		signingKey: []byte(secret),
+		verifyKeys: map[string]interface{}{
+			"": []byte(secret),
+		},
+	}
+}
+
+func newAsymmetricKeySet(method jwt.SigningMethod, kid string, private crypto.Signer) *jwtKeySet {
+	return &jwtKeySet{
+		method:     method,
+		signingKid: kid,
+		signingKey: private,
+		verifyKeys: map[string]interface{}{
+			kid: private.Public(),
+		},
+	}
+}
+
+func (k *jwtKeySet) addVerificationKey(kid string, public interface{}) error {
+	if _, ok := k.verifyKeys[kid]; ok {
+		return fmt.Errorf("jwt key with kid %s is already added", kid)
+	}
+	k.verifyKeys[kid] = public
+	return nil
+}
+
+// loadJWTKeySet builds key set from config, PEM files are read for RS256
+// and EdDSA algorithms.
+func loadJWTKeySet() (*jwtKeySet, error) {
+	if config.JWTAlgorithm == "HS256" {
+		return newHMACKeySet(config.JWTSecret), nil
+	}
+
+	data, err := os.ReadFile(config.JWTSigningKey.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys *jwtKeySet
+	switch config.JWTAlgorithm {
+	case "RS256":
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = newAsymmetricKeySet(jwt.SigningMethodRS256, config.JWTSigningKey.Kid, private)
+	case "EdDSA":
+		private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("ed25519 private key is not a signer")
+		}
+		keys = newAsymmetricKeySet(jwt.SigningMethodEdDSA, config.JWTSigningKey.Kid, signer)
+	default:
+		return nil, errors.New("unknown jwt algorithm " + config.JWTAlgorithm)
+	}
+
+	for _, v := range config.JWTVerificationKeys {
+		data, err := os.ReadFile(v.File)
+		if err != nil {
+			return nil, err
+		}
+
+		var public interface{}
+		if config.JWTAlgorithm == "RS256" {
+			public, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		} else {
+			public, err = jwt.ParseEdPublicKeyFromPEM(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwt verification key %s: %w", v.Kid, err)
+		}
+
+		if err = keys.addVerificationKey(v.Kid, public); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func (k *jwtKeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.signingKid != "" {
+		token.Header["kid"] = k.signingKid
+	}
+	return token.SignedString(k.signingKey)
+}
+
+func (k *jwtKeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	if t.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected jwt signing method %v", t.Header["alg"])
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := k.verifyKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown jwt kid %q", kid)
+	}
+	return key, nil
+}
+
+// jwks returns public verification keys, symmetric keys are never exposed.
+func (k *jwtKeySet) jwks() jwks {
+	set := jwks{
+		Keys: make([]jwk, 0, len(k.verifyKeys)),
+	}
+
+	for kid, key := range k.verifyKeys {
+		switch public := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: jwt.SigningMethodRS256.Alg(),
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kty: "OKP",
+				Use: "sig",
+				Alg: jwt.SigningMethodEdDSA.Alg(),
+				Kid: kid,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return set
+}
+
+func (s *Server) GetJWKS(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(http.StatusOK, s.jwtKeys.jwks())
+}
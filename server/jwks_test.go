@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseTestJWT(keys *jwtKeySet, token string) (*jwtCustomClaims, error) {
+	claims := new(jwtCustomClaims)
+	_, err := jwt.ParseWithClaims(token, claims, keys.keyFunc)
+	return claims, err
+}
+
+func TestJWTKeySetSignAndVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		Name string
+		Keys *jwtKeySet
+	}{
+		{
+			Name: "HS256",
+			Keys: newHMACKeySet("secret"),
+		},
+		{
+			Name: "RS256",
+			Keys: newAsymmetricKeySet(jwt.SigningMethodRS256, "rsa-1", rsaKey),
+		},
+		{
+			Name: "EdDSA",
+			Keys: newAsymmetricKeySet(jwt.SigningMethodEdDSA, "ed-1", edKey),
+		},
+	}
+
+	for _, testCase := range cases {
+		token, err := newJWT("userid", ROLE_USER, testCase.Keys)
+		assert.Nil(t, err, testCase.Name)
+
+		claims, err := parseTestJWT(testCase.Keys, token)
+		assert.Nil(t, err, testCase.Name)
+		assert.Equal(t, "userid", claims.ID, testCase.Name)
+	}
+}
+
+func TestJWTKeySetRotation(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	oldKeys := newAsymmetricKeySet(jwt.SigningMethodEdDSA, "old", oldKey)
+	oldToken, err := newJWT("userid", ROLE_USER, oldKeys)
+	assert.Nil(t, err)
+
+	keys := newAsymmetricKeySet(jwt.SigningMethodEdDSA, "new", newKey)
+	_, err = parseTestJWT(keys, oldToken)
+	assert.NotNil(t, err, "old kid is unknown")
+
+	assert.Nil(t, keys.addVerificationKey("old", oldKey.Public()))
+	_, err = parseTestJWT(keys, oldToken)
+	assert.Nil(t, err, "old kid is added for verification")
+
+	assert.NotNil(t, keys.addVerificationKey("new", oldKey.Public()), "duplicated kid")
+
+	hmacToken, err := newJWT("userid", ROLE_USER, newHMACKeySet("secret"))
+	assert.Nil(t, err)
+	_, err = parseTestJWT(keys, hmacToken)
+	assert.NotNil(t, err, "signing method is not allowed")
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	keys := newAsymmetricKeySet(jwt.SigningMethodRS256, "rsa-1", rsaKey)
+	set := keys.jwks()
+	if assert.Len(t, set.Keys, 1) {
+		assert.Equal(t, "RSA", set.Keys[0].Kty)
+		assert.Equal(t, "rsa-1", set.Keys[0].Kid)
+		assert.Equal(t, "AQAB", set.Keys[0].E)
+	}
+
+	assert.Empty(t, newHMACKeySet("secret").jwks().Keys, "secret is never exposed")
+}
@@ -0,0 +1,18 @@
+package mailer
+
+// LogMailer writes emails to the log instead of sending them. It is used
+// for development when smtp is not configured.
+type LogMailer struct {
+	logf func(format string, args ...interface{})
+}
+
+func NewLogMailer(logf func(format string, args ...interface{})) *LogMailer {
+	return &LogMailer{
+		logf: logf,
+	}
+}
+
+func (m LogMailer) Send(to, subject, body string) error {
+	m.logf("email to %s: %s\n%s", to, subject, body)
+	return nil
+}
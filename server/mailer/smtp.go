@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) (*SMTPMailer, error) {
+	if host == "" {
+		return nil, errors.New("smtp host is empty")
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, err
+	}
+
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m, nil
+}
+
+func (m SMTPMailer) Send(to, subject, body string) error {
+	fromAddr, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	toAddr, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+
+	msg := buildMessage(m.from, toAddr.String(), subject, body)
+	return smtp.SendMail(m.addr, m.auth, fromAddr.Address, []string{toAddr.Address}, msg)
+}
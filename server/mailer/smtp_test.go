@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakeSMTP accepts a single SMTP session and sends received DATA to
+// the returned channel.
+func startFakeSMTP(t *testing.T) (host string, port int, data <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		write("220 localhost fake smtp")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				write("250 ok")
+			case cmd == "DATA":
+				write("354 go ahead")
+				var msg strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				received <- msg.String()
+				write("250 queued")
+			case cmd == "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("250 ok")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	host, port, data := startFakeSMTP(t)
+
+	m, err := NewSMTPMailer(host, port, "", "", "deepenc <no-reply@example.com>")
+	assert.Nil(t, err)
+
+	err = m.Send("user@example.com", "Confirm\r\nBcc: evil@example.com", "follow the link")
+	assert.Nil(t, err)
+
+	msg := <-data
+	assert.Contains(t, msg, "To: <user@example.com>\r\n")
+	assert.Contains(t, msg, "Subject: ConfirmBcc: evil@example.com\r\n")
+	assert.Contains(t, msg, "\r\n\r\nfollow the link\r\n")
+	assert.NotContains(t, msg, "\r\nBcc:")
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	_, err := NewSMTPMailer("", 25, "", "", "no-reply@example.com")
+	assert.NotNil(t, err, "empty host")
+
+	_, err = NewSMTPMailer("localhost", 25, "", "", "not an address")
+	assert.NotNil(t, err, "invalid from")
+
+	m, err := NewSMTPMailer("localhost", 2525, "", "", "no-reply@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:"+strconv.Itoa(2525), m.addr)
+}
@@ -3,8 +3,12 @@ package server
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/audit"
 	"github.com/arimatakao/deepenc/server/database"
 	"github.com/arimatakao/deepenc/utils"
 	"github.com/labstack/echo/v4"
@@ -17,6 +21,16 @@ const (
 	MIN_PASSWORD_SIZE = 8
 
 	MAX_CONTENT_SIZE = 2000
+	MAX_TTL          = 365 * 24 * 60 * 60
+
+	MESSAGE_PASSWORD_HEADER = "X-Message-Password"
+	ETAG_HEADER             = "ETag"
+	IF_MATCH_HEADER         = "If-Match"
+)
+
+var (
+	errWrongPassword = errors.New("message password is wrong")
+	errBadIfMatch    = errors.New("If-Match header is malformed")
 )
 
 type Message struct {
@@ -27,27 +41,81 @@ type Message struct {
 	OnlyOwnerView bool   `json:"only_owner_view"`
 	IsAnon        bool   `json:"is_anon"`
 	IsOneTime     bool   `json:"is_one_time"`
+
+	Title string `json:"title,omitempty"`
+	// EncryptTitle keeps title encrypted with internal key, only owner
+	// sees it then.
+	EncryptTitle bool     `json:"encrypt_title,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// CollectionId is used on creation only, messages are moved between
+	// collections with PATCH.
+	CollectionId string `json:"collection_id,omitempty"`
+
+	// TTL is the message lifetime in seconds from creation or update, zero
+	// means the message doesn't expire.
+	TTL       int        `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Fields below are set in responses only, updates use If-Match header
+	// for version.
+	Version        int64      `json:"version,omitempty"`
+	PublicId       string     `json:"public_id,omitempty"`
+	CreatedAt      *time.Time `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
 }
 
 func (m Message) toDatabaseFormat(userId string) *database.Message {
+	now := time.Now().UTC()
 	return &database.Message{
-		OwnerId:       userId,
-		Content:       m.Content,
-		IsPrivate:     m.IsPrivate,
-		EncodingType:  m.EncodingType,
-		Password:      m.Password,
-		OnlyOwnerView: m.OnlyOwnerView,
-		IsAnon:        m.IsAnon,
-		IsOneTime:     m.IsOneTime,
+		OwnerId:        userId,
+		Content:        m.Content,
+		IsPrivate:      m.IsPrivate,
+		EncodingType:   m.EncodingType,
+		Password:       m.Password,
+		OnlyOwnerView:  m.OnlyOwnerView,
+		IsAnon:         m.IsAnon,
+		IsOneTime:      m.IsOneTime,
+		Title:          m.Title,
+		TitleEncrypted: m.EncryptTitle,
+		Tags:           m.Tags,
+		CollectionId:   m.CollectionId,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ExpiresAt:      expiresAt(m.TTL),
 	}
 }
 
+func expiresAt(ttl int) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	at := time.Now().UTC().Add(time.Duration(ttl) * time.Second)
+	return &at
+}
+
+func isExpired(msg database.MessageOut) bool {
+	return msg.ExpiresAt != nil && !msg.ExpiresAt.After(time.Now())
+}
+
 func (m Message) isValid() bool {
 	if len(m.Content) > MAX_CONTENT_SIZE ||
 		m.Content == "" {
 		return false
 	}
 
+	if m.TTL < 0 || m.TTL > MAX_TTL {
+		return false
+	}
+
+	if len(m.Title) > MAX_TITLE_SIZE {
+		return false
+	}
+
+	if _, ok := normalizeTags(m.Tags); !ok {
+		return false
+	}
+
 	switch m.EncodingType {
 	case "plaintext":
 		if m.Password != "" {
@@ -76,6 +144,14 @@ func (m Message) isValid() bool {
 }
 
 func (m *Message) formatToEncodingType() error {
+	m.Tags, _ = normalizeTags(m.Tags)
+
+	title, encrypted, err := sealTitle(m.Title, m.EncryptTitle)
+	if err != nil {
+		return err
+	}
+	m.Title, m.EncryptTitle = title, encrypted
+
 	switch m.EncodingType {
 	case "plaintext":
 		m.Password = ""
@@ -109,15 +185,166 @@ func (m *Message) formatToEncodingType() error {
 	return nil
 }
 
-func toOutputFormat(dbmsg database.MessageOut) *Message {
-	return &Message{
+// timeOrNil returns nil for zero time which messages created before
+// timestamps were added have.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// PublicMessage is the message as other users see it. Internal id is not
+// shown, links and API calls use the random public id. Read receipts,
+// collection and version are seen by the owner only.
+type PublicMessage struct {
+	PublicId      string   `json:"public_id"`
+	OwnerId       string   `json:"owner_id,omitempty"`
+	Content       string   `json:"content"`
+	IsPrivate     bool     `json:"is_private"`
+	EncodingType  string   `json:"encoding_type"`
+	OnlyOwnerView bool     `json:"only_owner_view"`
+	IsAnon        bool     `json:"is_anon"`
+	IsOneTime     bool     `json:"is_one_time"`
+	Title         string   `json:"title,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Score is a relevance of the message in search results.
+	Score float64 `json:"score,omitempty"`
+}
+
+// toPublicFormat hides the owner of anonymous message and encrypted title,
+// content is copied as it is.
+func toPublicFormat(dbmsg database.MessageOut) PublicMessage {
+	hideTitle(&dbmsg)
+	if dbmsg.IsAnon {
+		dbmsg.OwnerId = ""
+	}
+
+	return PublicMessage{
+		PublicId:      dbmsg.PublicId,
+		OwnerId:       dbmsg.OwnerId,
 		Content:       dbmsg.Content,
 		IsPrivate:     dbmsg.IsPrivate,
 		EncodingType:  dbmsg.EncodingType,
-		Password:      dbmsg.Password,
 		OnlyOwnerView: dbmsg.OnlyOwnerView,
 		IsAnon:        dbmsg.IsAnon,
 		IsOneTime:     dbmsg.IsOneTime,
+		Title:         dbmsg.Title,
+		Tags:          dbmsg.Tags,
+		CreatedAt:     timeOrNil(dbmsg.CreatedAt),
+		UpdatedAt:     timeOrNil(dbmsg.UpdatedAt),
+		ExpiresAt:     dbmsg.ExpiresAt,
+		Score:         dbmsg.Score,
+	}
+}
+
+// loadMessage returns message by internal or public id, expired messages
+// which are not removed by the cleanup job yet are treated as not existing.
+func (s *Server) loadMessage(id string) (database.MessageOut, error) {
+	return unlessExpired(s.db.GetMessage(id))
+}
+
+// loadPublicMessage is loadMessage for routes without authentication, they
+// accept only random public ids because internal ids can be enumerated.
+func (s *Server) loadPublicMessage(publicId string) (database.MessageOut, error) {
+	return unlessExpired(s.db.GetMessageByPublicId(publicId))
+}
+
+func unlessExpired(msg database.MessageOut, err error) (database.MessageOut, error) {
+	if err != nil {
+		return database.MessageOut{}, err
+	}
+
+	if isExpired(msg) {
+		return database.MessageOut{}, mongo.ErrNoDocuments
+	}
+
+	return msg, nil
+}
+
+// openMessage checks the password and decrypts content of the message in
+// place. Owner doesn't need password for "password" messages because their
+// content is stored as is.
+func openMessage(msg *database.MessageOut, password string, isOwner bool) error {
+	switch msg.EncodingType {
+	case "password":
+		if !isOwner {
+			err := bcrypt.CompareHashAndPassword([]byte(msg.Password), []byte(password))
+			if err != nil {
+				return errWrongPassword
+			}
+		}
+	case "internal":
+		decrypted, err := utils.DecryptAES256(config.AESInternalKey, msg.Content)
+		if err != nil {
+			return err
+		}
+		msg.Content = decrypted
+	case "aes":
+		decrypted, err := utils.DecryptAES256([]byte(password), msg.Content)
+		if err != nil {
+			return errWrongPassword
+		}
+		msg.Content = decrypted
+	}
+
+	msg.Password = ""
+	return nil
+}
+
+// consumeMessage removes one-time message after it is read. Error means
+// the message is already consumed by concurrent request.
+func (s *Server) consumeMessage(msg database.MessageOut) error {
+	if !msg.IsOneTime {
+		return nil
+	}
+	return s.db.DeleteMessage(msg.Id.Hex())
+}
+
+// messageETag returns strong entity tag of the message version.
+func messageETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// expectedVersion returns message version the client wants to change. Version
+// from If-Match header must be equal to the current one, without the header
+// or with "*" the current version is used, so concurrent writes between
+// loading and saving of the message are still detected.
+func expectedVersion(c echo.Context, current int64) (int64, error) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get(IF_MATCH_HEADER))
+	if ifMatch == "" || ifMatch == "*" {
+		return current, nil
+	}
+
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			return 0, errBadIfMatch
+		}
+		if version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil &&
+			version == current {
+			return current, nil
+		}
+	}
+
+	return 0, database.ErrVersionConflict
+}
+
+// versionStatus converts error of expectedVersion or versioned update into
+// response status.
+func versionStatus(err error) int {
+	switch err {
+	case errBadIfMatch:
+		return http.StatusBadRequest
+	case database.ErrVersionConflict:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
@@ -136,6 +363,16 @@ func (s *Server) CreateMessage(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "")
 	}
 
+	coll, err := checkCollection(s.db, userId, msg.CollectionId)
+	if err == errCollectionNotFound {
+		return c.JSON(http.StatusBadRequest, resp(err.Error()))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	applyDefaults(msg, coll.Defaults)
+
 	if !msg.isValid() {
 		return c.String(http.StatusBadRequest, "")
 	}
@@ -154,8 +391,16 @@ func (s *Server) CreateMessage(c echo.Context) error {
 	}
 
 	c.Logger().Info("added new message: " + resultId)
-
-	return c.String(http.StatusCreated, "")
+	s.recordAudit(c, audit.Event{
+		Type:     AUDIT_MESSAGE_CREATE,
+		TargetId: resultId,
+		Details:  map[string]string{"public_id": mFormat.PublicId, "owner_id": userId},
+	})
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"id":        resultId,
+		"public_id": mFormat.PublicId,
+	})
 }
 
 func (s *Server) GetPublicMessage(c echo.Context) error {
@@ -164,7 +409,7 @@ func (s *Server) GetPublicMessage(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	msg, err := s.db.GetMessage(msgId)
+	msg, err := s.loadPublicMessage(msgId)
 	if err == mongo.ErrNoDocuments {
 		return c.String(http.StatusNotFound, "")
 	}
@@ -173,25 +418,26 @@ func (s *Server) GetPublicMessage(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "")
 	}
 
+	if err = authorize(subjectFromContext(c), actionRead, msg); err != nil {
+		s.logAccess(c, msg, ACCESS_OUTCOME_DENIED)
+		return c.String(authorizationStatus(err), "")
+	}
+
 	if msg.IsPrivate ||
 		msg.Password != "" ||
-		msg.EncodingType != "plaintext" ||
-		msg.OnlyOwnerView {
+		msg.EncodingType != "plaintext" {
+		s.logAccess(c, msg, ACCESS_OUTCOME_DENIED)
 		return c.String(http.StatusNotFound, "")
 	}
 
-	if msg.IsAnon {
-		msg.OwnerId = ""
-	}
-
-	if msg.IsOneTime {
-		if err = s.db.DeleteMessage(msgId); err != nil {
-			c.Logger().Error(err)
-			return c.JSON(http.StatusNotFound, "")
-		}
+	if err = s.consumeMessage(msg); err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusNotFound, "")
 	}
+	s.logAccess(c, msg, ACCESS_OUTCOME_READ)
+	s.markAccessed(c, &msg)
 
-	return c.JSON(http.StatusOK, msg)
+	return c.JSON(http.StatusOK, toPublicFormat(msg))
 }
 
 func (s *Server) GetUserMessagesList(c echo.Context) error {
@@ -200,7 +446,11 @@ func (s *Server) GetUserMessagesList(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	messages, err := s.db.GetUserMessages(userId)
+	filter := database.MessageFilter{
+		Tag: strings.ToLower(strings.TrimSpace(c.QueryParam("tag"))),
+	}
+
+	messages, err := s.db.GetUserMessages(userId, filter)
 	if err == mongo.ErrNoDocuments {
 		return c.String(http.StatusNotFound, "")
 	}
@@ -209,6 +459,14 @@ func (s *Server) GetUserMessagesList(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "")
 	}
 
+	messages = filterAuthorized(subjectFromContext(c), actionList, messages)
+	for i := range messages {
+		if err = openTitle(&messages[i]); err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+	}
+
 	return c.JSON(http.StatusOK, messages)
 }
 
@@ -221,21 +479,16 @@ func (s *Server) GetPublicMessagesList(c echo.Context) error {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
-	for _, v := range messages {
-		if v.IsAnon {
-			v.OwnerId = ""
-		}
+	messages = filterAuthorized(subjectFromContext(c), actionRead, messages)
+	public := make([]PublicMessage, 0, len(messages))
+	for _, msg := range messages {
+		public = append(public, toPublicFormat(msg))
 	}
 
-	return c.JSON(http.StatusOK, messages)
+	return c.JSON(http.StatusOK, public)
 }
 
 func (s *Server) UpdateMessage(c echo.Context) error {
-	userId, err := getUserIdFromJWT(c)
-	if err != nil {
-		return c.String(http.StatusBadRequest, "")
-	}
-
 	msgId := c.Param("id")
 	if msgId == "" {
 		return c.String(http.StatusBadRequest, "")
@@ -250,7 +503,7 @@ func (s *Server) UpdateMessage(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	_, err = s.db.GetMessage(msgId)
+	current, err := s.loadMessage(msgId)
 	if err == mongo.ErrNoDocuments {
 		return c.String(http.StatusNotFound, "")
 	}
@@ -259,34 +512,144 @@ func (s *Server) UpdateMessage(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "")
 	}
 
+	if err = authorize(subjectFromContext(c), actionUpdate, current); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	version, err := expectedVersion(c, current.Version)
+	if err != nil {
+		return c.String(versionStatus(err), "")
+	}
+
 	if err = msg.formatToEncodingType(); err != nil {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	mFormat := msg.toDatabaseFormat(userId)
+	mFormat := msg.toDatabaseFormat(current.OwnerId)
+	mFormat.CollectionId = current.CollectionId
+	if !current.CreatedAt.IsZero() {
+		mFormat.CreatedAt = current.CreatedAt
+	}
 
-	err = s.db.UpdateMessage(msgId, mFormat)
+	err = s.db.UpdateMessage(current.Id.Hex(), version, mFormat)
+	if err == database.ErrVersionConflict {
+		return c.String(http.StatusPreconditionFailed, "")
+	}
 	if err != nil {
 		c.Logger().Error(err)
 		return c.String(http.StatusBadRequest, "")
 	}
 
+	s.saveRevision(c, current)
+	s.recordAudit(c, messageEvent(AUDIT_MESSAGE_UPDATE, current))
+
+	c.Response().Header().Set(ETAG_HEADER, messageETag(version+1))
 	return c.String(http.StatusNoContent, "")
 }
 
-func (s *Server) DeleteMessage(c echo.Context) error {
-	userId, err := getUserIdFromJWT(c)
-	if err != nil {
-		return c.String(http.StatusBadRequest, "")
+// MessagePatch changes message metadata only, content and password stay
+// encoded as they are.
+type MessagePatch struct {
+	OnlyOwnerView *bool `json:"only_owner_view"`
+	IsAnon        *bool `json:"is_anon"`
+	IsOneTime     *bool `json:"is_one_time"`
+
+	Title        *string   `json:"title"`
+	EncryptTitle *bool     `json:"encrypt_title"`
+	Tags         *[]string `json:"tags"`
+	// CollectionId moves message to the collection, empty value moves it
+	// to the top level.
+	CollectionId *string `json:"collection_id"`
+
+	// TTL sets new lifetime from now, zero removes expiration.
+	TTL *int `json:"ttl"`
+}
+
+func (p MessagePatch) isValid() bool {
+	if p.OnlyOwnerView == nil && p.IsAnon == nil && p.IsOneTime == nil &&
+		p.Title == nil && p.EncryptTitle == nil && p.Tags == nil &&
+		p.CollectionId == nil && p.TTL == nil {
+		return false
+	}
+
+	if p.TTL != nil && (*p.TTL < 0 || *p.TTL > MAX_TTL) {
+		return false
+	}
+
+	if p.Title != nil && len(*p.Title) > MAX_TITLE_SIZE {
+		return false
 	}
 
+	if p.Tags != nil {
+		if _, ok := normalizeTags(*p.Tags); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toDatabaseFormat builds patch for the current message. Title is sealed
+// again when either the title or its encryption changes.
+func (p MessagePatch) toDatabaseFormat(current database.MessageOut) (database.MessagePatch, error) {
+	patch := database.MessagePatch{
+		OnlyOwnerView: p.OnlyOwnerView,
+		IsAnon:        p.IsAnon,
+		IsOneTime:     p.IsOneTime,
+		CollectionId:  p.CollectionId,
+		UpdatedAt:     time.Now().UTC(),
+	}
+
+	if p.Title != nil || p.EncryptTitle != nil {
+		if err := openTitle(&current); err != nil {
+			return database.MessagePatch{}, err
+		}
+
+		title, encrypt := current.Title, current.TitleEncrypted
+		if p.Title != nil {
+			title = *p.Title
+		}
+		if p.EncryptTitle != nil {
+			encrypt = *p.EncryptTitle
+		}
+
+		title, encrypted, err := sealTitle(title, encrypt)
+		if err != nil {
+			return database.MessagePatch{}, err
+		}
+		patch.Title, patch.TitleEncrypted = &title, &encrypted
+	}
+
+	if p.Tags != nil {
+		tags, _ := normalizeTags(*p.Tags)
+		patch.Tags = &tags
+	}
+
+	if p.TTL != nil {
+		patch.ExpiresAt = expiresAt(*p.TTL)
+		patch.ClearExpiresAt = patch.ExpiresAt == nil
+	}
+
+	return patch, nil
+}
+
+func (s *Server) PatchMessage(c echo.Context) error {
 	msgId := c.Param("id")
 	if msgId == "" {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	msg, err := s.db.GetMessage(msgId)
+	patch := new(MessagePatch)
+	if err := c.Bind(patch); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !patch.isValid() {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	current, err := s.loadMessage(msgId)
 	if err == mongo.ErrNoDocuments {
 		return c.String(http.StatusNotFound, "")
 	}
@@ -295,16 +658,76 @@ func (s *Server) DeleteMessage(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	if msg.OwnerId != userId {
+	if err = authorize(subjectFromContext(c), actionUpdate, current); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	version, err := expectedVersion(c, current.Version)
+	if err != nil {
+		return c.String(versionStatus(err), "")
+	}
+
+	if patch.CollectionId != nil {
+		_, err = checkCollection(s.db, current.OwnerId, *patch.CollectionId)
+		if err == errCollectionNotFound {
+			return c.JSON(http.StatusBadRequest, resp(err.Error()))
+		}
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+	}
+
+	dbPatch, err := patch.toDatabaseFormat(current)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	err = s.db.PatchMessage(current.Id.Hex(), version, dbPatch)
+	if err == database.ErrVersionConflict {
+		return c.String(http.StatusPreconditionFailed, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	s.recordAudit(c, messageEvent(AUDIT_MESSAGE_UPDATE, current))
+
+	c.Response().Header().Set(ETAG_HEADER, messageETag(version+1))
+	return c.String(http.StatusNoContent, "")
+}
+
+func (s *Server) DeleteMessage(c echo.Context) error {
+	msgId := c.Param("id")
+	if msgId == "" {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	err = s.db.DeleteMessage(msgId)
+	msg, err := s.loadMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
 	if err != nil {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
+	if err = authorize(subjectFromContext(c), actionDelete, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	err = s.db.DeleteMessage(msg.Id.Hex())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	s.recordAudit(c, messageEvent(AUDIT_MESSAGE_DELETE, msg))
+
+	if err = s.emitMessageEvent(WEBHOOK_EVENT_DELETED, msg); err != nil {
+		c.Logger().Error(err)
+	}
+
 	return c.String(http.StatusNoContent, "")
 }
 
@@ -319,7 +742,7 @@ func (s *Server) GetPrivateMessage(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	msg, err := s.db.GetMessage(msgId)
+	msg, err := s.loadPublicMessage(msgId)
 	if err == mongo.ErrNoDocuments {
 		return c.String(http.StatusNotFound, "")
 	}
@@ -328,45 +751,81 @@ func (s *Server) GetPrivateMessage(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	if !msg.IsPrivate || msg.OnlyOwnerView {
+	if err = authorize(subjectFromContext(c), actionRead, msg); err != nil {
+		s.logAccess(c, msg, ACCESS_OUTCOME_DENIED)
+		return c.String(authorizationStatus(err), "")
+	}
+
+	if !msg.IsPrivate {
+		s.logAccess(c, msg, ACCESS_OUTCOME_DENIED)
 		return c.String(http.StatusNotFound, "")
 	}
 
-	switch msg.EncodingType {
-	case "password":
-		err = bcrypt.CompareHashAndPassword([]byte(msg.Password), []byte(input.Password))
-		if err != nil {
-			return c.String(http.StatusNotFound, "")
-		}
-	case "internal":
-		decrypted, err := utils.DecryptAES256(config.AESInternalKey, msg.Content)
-		if err != nil {
-			c.Logger().Error(err)
-			return c.String(http.StatusInternalServerError, "")
-		}
-		msg.Content = decrypted
-	case "aes":
-		decrypted, err := utils.DecryptAES256([]byte(input.Password), msg.Content)
-		if err != nil {
-			c.Logger().Warn(err)
-			return c.String(http.StatusNotFound, "")
-		}
-		msg.Content = decrypted
-		msg.Password = ""
+	err = openMessage(&msg, input.Password, false)
+	if err == errWrongPassword {
+		s.logAccess(c, msg, ACCESS_OUTCOME_WRONG_PASSWORD)
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
 	}
 
-	msgResp := toOutputFormat(msg)
+	if err = s.consumeMessage(msg); err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusNotFound, "")
+	}
+	s.logAccess(c, msg, ACCESS_OUTCOME_READ)
+	s.markAccessed(c, &msg)
+
+	return c.JSON(http.StatusOK, toPublicFormat(msg))
+}
 
-	if msgResp.IsAnon {
-		msg.OwnerId = ""
+func (s *Server) GetOwnMessage(c echo.Context) error {
+	msgId := c.Param("id")
+	if msgId == "" {
+		return c.String(http.StatusBadRequest, "")
 	}
 
-	if msgResp.IsOneTime {
-		if err = s.db.DeleteMessage(msgId); err != nil {
-			c.Logger().Error(err)
-			return c.JSON(http.StatusNotFound, "")
-		}
+	msg, err := s.loadMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
 	}
 
-	return c.JSON(http.StatusOK, msgResp)
+	if err = authorize(subjectFromContext(c), actionOwnerRead, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	password := c.Request().Header.Get(MESSAGE_PASSWORD_HEADER)
+	if msg.EncodingType == "aes" && password == "" {
+		return c.JSON(http.StatusBadRequest,
+			resp("password is required in "+MESSAGE_PASSWORD_HEADER+" header"))
+	}
+
+	err = openMessage(&msg, password, true)
+	if err == errWrongPassword {
+		s.auditFailure(c, messageEvent(AUDIT_MESSAGE_READ, msg), ACCESS_OUTCOME_WRONG_PASSWORD)
+		return c.JSON(http.StatusForbidden, resp("password is wrong"))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = openTitle(&msg); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	// One-time messages are consumed only by recipients, the owner can
+	// check the content before sending the link.
+	s.recordAudit(c, messageEvent(AUDIT_MESSAGE_READ, msg))
+	s.markAccessed(c, &msg)
+
+	c.Response().Header().Set(ETAG_HEADER, messageETag(msg.Version))
+	return c.JSON(http.StatusOK, msg)
 }
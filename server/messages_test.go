@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TestCaseIfMatch struct {
+	Name            string
+	IfMatch         string
+	ExpectedVersion int64
+	ExpectedErr     error
+}
+
+func TestExpectedVersion(t *testing.T) {
+	const current = 3
+
+	cases := []TestCaseIfMatch{
+		{"without header", "", current, nil},
+		{"any version", "*", current, nil},
+		{"current version", `"3"`, current, nil},
+		{"weak tag", `W/"3"`, current, nil},
+		{"one of tags", `"2", "3"`, current, nil},
+		{"stale version", `"2"`, 0, database.ErrVersionConflict},
+		{"not a number", `"abc"`, 0, database.ErrVersionConflict},
+		{"unquoted tag", "3", 0, errBadIfMatch},
+	}
+
+	e := echo.New()
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/", nil)
+			if tc.IfMatch != "" {
+				req.Header.Set(IF_MATCH_HEADER, tc.IfMatch)
+			}
+			c := e.NewContext(req, httptest.NewRecorder())
+
+			version, err := expectedVersion(c, current)
+			assert.Equal(t, tc.ExpectedErr, err)
+			assert.Equal(t, tc.ExpectedVersion, version)
+		})
+	}
+}
+
+func TestMessageETag(t *testing.T) {
+	assert.Equal(t, `"7"`, messageETag(7))
+}
+
+func TestMessagePatchIsValid(t *testing.T) {
+	yes := true
+	ttl := 60
+	negative := -1
+
+	assert.False(t, MessagePatch{}.isValid())
+	assert.True(t, MessagePatch{IsAnon: &yes}.isValid())
+	assert.True(t, MessagePatch{TTL: &ttl}.isValid())
+	assert.False(t, MessagePatch{TTL: &negative}.isValid())
+}
+
+type TestCaseTags struct {
+	Name         string
+	Tags         []string
+	ExpectedTags []string
+	ExpectedOk   bool
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tooMany := make([]string, MAX_TAGS+1)
+	for i := range tooMany {
+		tooMany[i] = strconv.Itoa(i)
+	}
+
+	cases := []TestCaseTags{
+		{"empty", nil, []string{}, true},
+		{"lowercase and trim", []string{" Prod ", "DB"}, []string{"prod", "db"}, true},
+		{"duplicates", []string{"prod", "PROD", ""}, []string{"prod"}, true},
+		{"long tag", []string{strings.Repeat("a", MAX_TAG_SIZE+1)}, nil, false},
+		{"too many tags", tooMany, nil, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tags, ok := normalizeTags(tc.Tags)
+			assert.Equal(t, tc.ExpectedOk, ok)
+			assert.Equal(t, tc.ExpectedTags, tags)
+		})
+	}
+}
+
+func TestSealTitle(t *testing.T) {
+	internalKey := config.AESInternalKey
+	t.Cleanup(func() { config.AESInternalKey = internalKey })
+	config.AESInternalKey = []byte("0123456789abcdef0123456789abcdef")
+
+	title, encrypted, err := sealTitle("db password", false)
+	assert.NoError(t, err)
+	assert.False(t, encrypted)
+	assert.Equal(t, "db password", title)
+
+	_, encrypted, err = sealTitle("", true)
+	assert.NoError(t, err)
+	assert.False(t, encrypted)
+
+	title, encrypted, err = sealTitle("db password", true)
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+	assert.NotEqual(t, "db password", title)
+
+	msg := database.MessageOut{Title: title, TitleEncrypted: true}
+	assert.NoError(t, openTitle(&msg))
+	assert.Equal(t, "db password", msg.Title)
+
+	msg = database.MessageOut{Title: title, TitleEncrypted: true}
+	hideTitle(&msg)
+	assert.Equal(t, "", msg.Title)
+}
+
+type TestCaseOwnMessageRead struct {
+	Name           string
+	UserId         string
+	ExpectedStatus int
+}
+
+func TestGetOwnMessageKeepsOneTimeMessage(t *testing.T) {
+	owner := primitive.NewObjectID().Hex()
+	msg := database.MessageOut{
+		Id:           primitive.NewObjectID(),
+		OwnerId:      owner,
+		Content:      "secret",
+		EncodingType: "plaintext",
+		IsOneTime:    true,
+	}
+	db := newFakeStorager()
+	db.addMessages(msg)
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	cases := []TestCaseOwnMessageRead{
+		{"owner reads", owner, http.StatusOK},
+		{"owner reads again", owner, http.StatusOK},
+		{"other user", primitive.NewObjectID().Hex(), http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := s.e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(msg.Id.Hex())
+		authenticate(c, tc.UserId, ROLE_USER)
+
+		s.GetOwnMessage(c)
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, tc.Name)
+		assert.Contains(t, db.messages, msg.Id.Hex(), tc.Name)
+		if tc.ExpectedStatus == http.StatusOK {
+			assert.Contains(t, rec.Body.String(), `"content":"secret"`, tc.Name)
+		}
+	}
+}
+
+func TestGetPublicMessageHidesInternalId(t *testing.T) {
+	now := time.Now().UTC()
+	msg := database.MessageOut{
+		Id:           primitive.NewObjectID(),
+		PublicId:     "public",
+		OwnerId:      primitive.NewObjectID().Hex(),
+		Content:      "shared knowledge",
+		EncodingType: "plaintext",
+		IsAnon:       true,
+		CollectionId: primitive.NewObjectID().Hex(),
+		Version:      3,
+		ReadCount:    5,
+		LastReadAt:   &now,
+	}
+	db := newFakeStorager()
+	db.addMessages(msg)
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := s.e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(msg.PublicId)
+
+	s.GetPublicMessage(c)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body map[string]interface{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "public", body["public_id"])
+	assert.Equal(t, "shared knowledge", body["content"])
+	assert.NotContains(t, body, "id")
+	assert.NotContains(t, body, "_id")
+	assert.NotContains(t, body, "owner_id")
+	assert.NotContains(t, body, "password")
+	assert.NotContains(t, body, "collection_id")
+	assert.NotContains(t, body, "version")
+	assert.NotContains(t, body, "read_count")
+	assert.NotContains(t, body, "last_read_at")
+	assert.NotContains(t, body, "last_accessed_at")
+	assert.NotContains(t, rec.Body.String(), msg.Id.Hex())
+}
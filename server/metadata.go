@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MAX_TITLE_SIZE = 200
+	MAX_TAGS       = 20
+	MAX_TAG_SIZE   = 32
+)
+
+// normalizeTags lowercases and trims tags and drops empty and duplicated
+// ones. It returns false if tags exceed the limits.
+func normalizeTags(tags []string) ([]string, bool) {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > MAX_TAG_SIZE {
+			return nil, false
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > MAX_TAGS {
+		return nil, false
+	}
+
+	return normalized, true
+}
+
+// sealTitle trims title and encrypts it with internal key if requested.
+// Empty titles are never marked as encrypted.
+func sealTitle(title string, encrypt bool) (string, bool, error) {
+	title = strings.TrimSpace(title)
+	if !encrypt || title == "" {
+		return title, false, nil
+	}
+
+	encrypted, err := utils.EncryptAES256(config.AESInternalKey, title)
+	if err != nil {
+		return "", false, err
+	}
+
+	return encrypted, true, nil
+}
+
+// openTitle decrypts title of the message for its owner.
+func openTitle(msg *database.MessageOut) error {
+	if !msg.TitleEncrypted {
+		return nil
+	}
+
+	decrypted, err := utils.DecryptAES256(config.AESInternalKey, msg.Title)
+	if err != nil {
+		return err
+	}
+	// Short plaintexts are padded with spaces before encryption, titles are
+	// trimmed before sealing so the padding is removed safely.
+	msg.Title = strings.TrimRight(decrypted, " ")
+
+	return nil
+}
+
+// hideTitle removes encrypted title from message shown to other users.
+func hideTitle(msg *database.MessageOut) {
+	if msg.TitleEncrypted {
+		msg.Title = ""
+	}
+}
+
+// markAccessed saves time of the message read. One-time messages are
+// already removed at this point.
+func (s *Server) markAccessed(c echo.Context, msg *database.MessageOut) {
+	if msg.IsOneTime {
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := s.db.TouchMessage(msg.Id.Hex(), now); err != nil {
+		c.Logger().Error(err)
+		return
+	}
+	msg.LastAccessedAt = &now
+}
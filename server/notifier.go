@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/mailer"
+	"github.com/labstack/echo/v4"
+)
+
+// Notifier delivers security notifications (account lockout, sign in from
+// a new device, etc.) to the owner of the account.
+type Notifier interface {
+	Notify(u database.UserOut, subject, text string) error
+}
+
+type logNotifier struct {
+	logger echo.Logger
+}
+
+func newLogNotifier(logger echo.Logger) *logNotifier {
+	return &logNotifier{
+		logger: logger,
+	}
+}
+
+func (n logNotifier) Notify(u database.UserOut, subject, text string) error {
+	n.logger.Infof("notification for %s: %s - %s", u.Username, subject, text)
+	return nil
+}
+
+// mailNotifier sends notifications to the user email and falls back to
+// the log for accounts without email.
+type mailNotifier struct {
+	mailer   mailer.Mailer
+	fallback Notifier
+}
+
+func newMailNotifier(m mailer.Mailer, fallback Notifier) *mailNotifier {
+	return &mailNotifier{
+		mailer:   m,
+		fallback: fallback,
+	}
+}
+
+func (n mailNotifier) Notify(u database.UserOut, subject, text string) error {
+	if u.Email == "" {
+		return n.fallback.Notify(u, subject, text)
+	}
+	return n.mailer.Send(u.Email, "deepenc: "+subject, text)
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/oidc"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	OIDC_STATE_TTL          = 10 * time.Minute
+	OIDC_USERNAME_ATTEMPTS  = 5
+	OIDC_DEFAULT_USERNAME   = "sso"
+	MAX_OIDC_USERNAME_SIZE  = 32
+	OIDC_EXCHANGE_TIMEOUT   = 15 * time.Second
+	OIDC_USERNAME_SEPARATOR = "-"
+)
+
+func (s *Server) OIDCLogin(c echo.Context) error {
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	nonce, err := utils.RandomToken(16)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	state, err := s.cachedb.AddOIDCState(verifier, nonce, OIDC_STATE_TTL)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.Redirect(http.StatusFound, s.oidc.AuthCodeURL(state, nonce, challenge))
+}
+
+func (s *Server) OIDCCallback(c echo.Context) error {
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return c.JSON(http.StatusUnauthorized,
+			resp(providerErr+": "+c.QueryParam("error_description")))
+	}
+
+	code := c.QueryParam("code")
+	state := c.QueryParam("state")
+	if code == "" || state == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	verifier, nonce, err := s.cachedb.GetOIDCState(state)
+	if err == database.ErrTokenNotFound {
+		return c.JSON(http.StatusBadRequest, resp("login state is expired, try again"))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), OIDC_EXCHANGE_TIMEOUT)
+	defer cancel()
+
+	idToken, err := s.oidc.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		c.Logger().Warn(err)
+		s.auditFailure(c, audit.Event{Type: AUDIT_SIGNIN}, "single sign-on token is not valid")
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	u, err := s.db.GetUserByOIDCSubject(idToken.Issuer, idToken.Subject)
+	if err == mongo.ErrNoDocuments {
+		u, err = s.provisionOIDCUser(idToken)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return s.completeSignIn(c, u)
+}
+
+// provisionOIDCUser creates local account linked to the provider subject.
+// Username is taken from the token and gets random suffix when it is
+// already used by another account.
+func (s *Server) provisionOIDCUser(t *oidc.IDToken) (database.UserOut, error) {
+	base := t.PreferredUsername
+	if base == "" && t.Email != "" {
+		base, _, _ = strings.Cut(t.Email, "@")
+	}
+	if base == "" {
+		base = OIDC_DEFAULT_USERNAME
+	}
+	if len(base) > MAX_OIDC_USERNAME_SIZE {
+		base = base[:MAX_OIDC_USERNAME_SIZE]
+	}
+
+	u := &database.User{
+		Username:    base,
+		OIDCIssuer:  t.Issuer,
+		OIDCSubject: t.Subject,
+	}
+	if t.EmailVerified {
+		u.Email = t.Email
+	}
+
+	// Usernames are unique in the database, so the username taken by
+	// another user or reserved by a pending signup concurrently is
+	// reported by AddUser and the next suffix is tried.
+	for i := 0; i < OIDC_USERNAME_ATTEMPTS; i++ {
+		err := s.db.AddUser(u)
+		if err == nil {
+			return s.db.GetUserByOIDCSubject(t.Issuer, t.Subject)
+		}
+		if err != database.ErrAlreadyExist {
+			return database.UserOut{}, err
+		}
+
+		// The same user may be provisioned by concurrent sign in.
+		existing, err := s.db.GetUserByOIDCSubject(t.Issuer, t.Subject)
+		if err == nil {
+			return existing, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return database.UserOut{}, err
+		}
+
+		suffix, err := utils.RandomToken(3)
+		if err != nil {
+			return database.UserOut{}, err
+		}
+		u.Username = base + OIDC_USERNAME_SEPARATOR + suffix
+	}
+
+	return database.UserOut{}, errors.New("can't find free username for oidc user " + base)
+}
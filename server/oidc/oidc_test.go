@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testClientId    = "deepenc"
+	testRedirectURL = "http://localhost:1234/api/oidc/callback"
+)
+
+// mockProvider is a minimal OpenID Connect provider, id token claims for
+// the next token request are set in claims field.
+type mockProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	claims    jwt.MapClaims
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockProvider{key: key}
+	mux := http.NewServeMux()
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwkSet{Keys: []jwk{{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: "test",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "good-code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims)
+		token.Header["kid"] = "test"
+		signed, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(tokenResponse{IDToken: signed})
+	})
+
+	return m
+}
+
+func (m *mockProvider) validClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                m.server.URL,
+		"aud":                testClientId,
+		"sub":                "subject-1",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"nonce":              nonce,
+		"email":              "user@example.com",
+		"preferred_username": "user",
+	}
+}
+
+func TestProviderAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+
+	p, err := NewProvider(context.Background(), m.server.URL, testClientId, "", testRedirectURL, nil)
+	assert.Nil(t, err)
+
+	u, err := url.Parse(p.AuthCodeURL("state", "nonce", "challenge"))
+	assert.Nil(t, err)
+	assert.Equal(t, "/authorize", u.Path)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "challenge", u.Query().Get("code_challenge"))
+	assert.Equal(t, "openid profile email", u.Query().Get("scope"))
+}
+
+func TestProviderExchange(t *testing.T) {
+	m := newMockProvider(t)
+
+	p, err := NewProvider(context.Background(), m.server.URL, testClientId, "secret",
+		testRedirectURL, nil)
+	assert.Nil(t, err)
+
+	verifier, challenge, err := NewPKCE()
+	assert.Nil(t, err)
+	m.challenge = challenge
+
+	cases := []struct {
+		Name      string
+		Code      string
+		Verifier  string
+		Nonce     string
+		Claims    func(c jwt.MapClaims)
+		WithError bool
+	}{
+		{
+			Name:     "valid token",
+			Code:     "good-code",
+			Verifier: verifier,
+			Nonce:    "nonce",
+		},
+		{
+			Name:      "wrong code verifier",
+			Code:      "good-code",
+			Verifier:  "other",
+			Nonce:     "nonce",
+			WithError: true,
+		},
+		{
+			Name:      "nonce mismatch",
+			Code:      "good-code",
+			Verifier:  verifier,
+			Nonce:     "other",
+			WithError: true,
+		},
+		{
+			Name:      "wrong audience",
+			Code:      "good-code",
+			Verifier:  verifier,
+			Nonce:     "nonce",
+			Claims:    func(c jwt.MapClaims) { c["aud"] = "another-client" },
+			WithError: true,
+		},
+		{
+			Name:      "expired token",
+			Code:      "good-code",
+			Verifier:  verifier,
+			Nonce:     "nonce",
+			Claims:    func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+			WithError: true,
+		},
+	}
+
+	for _, testCase := range cases {
+		m.claims = m.validClaims("nonce")
+		if testCase.Claims != nil {
+			testCase.Claims(m.claims)
+		}
+
+		token, err := p.Exchange(context.Background(), testCase.Code, testCase.Verifier,
+			testCase.Nonce)
+
+		if testCase.WithError {
+			assert.Nil(t, token, testCase.Name)
+			assert.NotNil(t, err, testCase.Name)
+		} else {
+			assert.Nil(t, err, testCase.Name)
+			if assert.NotNil(t, token, testCase.Name) {
+				assert.Equal(t, "subject-1", token.Subject, testCase.Name)
+				assert.Equal(t, "user", token.PreferredUsername, testCase.Name)
+				assert.Equal(t, "user@example.com", token.Email, testCase.Name)
+			}
+		}
+	}
+}
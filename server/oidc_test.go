@@ -0,0 +1,42 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/oidc"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProvisionOIDCUser(t *testing.T) {
+	taken := database.UserOut{Id: primitive.NewObjectID(), Username: "alice"}
+	db := newFakeStorager(taken)
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	token := &oidc.IDToken{
+		Issuer:            "https://idp.example.com",
+		Subject:           "subject",
+		Email:             "alice@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "alice",
+	}
+
+	u, err := s.provisionOIDCUser(token)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(u.Username, "alice"+OIDC_USERNAME_SEPARATOR), u.Username)
+	assert.Equal(t, "alice@example.com", u.Email)
+	assert.Equal(t, token.Subject, u.OIDCSubject)
+
+	// The subject provisioned concurrently is returned, not duplicated.
+	again, err := s.provisionOIDCUser(token)
+	assert.Nil(t, err)
+	assert.Equal(t, u.Id, again.Id)
+	assert.Len(t, db.users, 2)
+
+	u, err = s.provisionOIDCUser(&oidc.IDToken{Issuer: token.Issuer, Subject: "other"})
+	assert.Nil(t, err)
+	assert.Equal(t, OIDC_DEFAULT_USERNAME, u.Username)
+	assert.Empty(t, u.Email)
+}
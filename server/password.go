@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type InputChangePassword struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type InputForgotPassword struct {
+	Username string `json:"username"`
+}
+
+type InputResetPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// setPassword stores new password hash and revokes every token issued
+// before the change.
+func (s *Server) setPassword(u database.UserOut, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err = s.db.UpdatePassword(u.Id.Hex(), string(hashedPassword)); err != nil {
+		return err
+	}
+
+	return s.cachedb.RevokeTokens(u.Id.Hex(), time.Now())
+}
+
+func (s *Server) ChangePassword(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputChangePassword)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if input.OldPassword == "" || !isValidUserPassword(input.NewPassword) {
+		return c.JSON(http.StatusBadRequest, resp(INVALID_USER_PASSWORD_TEXT))
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(input.OldPassword))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp("old password is wrong"))
+	}
+
+	if err = s.setPassword(u, input.NewPassword); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	s.recordAudit(c, tokensRevokedEvent(userId, "password changed"))
+
+	if err = s.notifier.Notify(u, "password changed",
+		fmt.Sprintf("password was changed from %s", c.RealIP())); err != nil {
+		c.Logger().Warn(err)
+	}
+
+	token, err := newJWT(userId, userRole(u), s.jwtKeys)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"token": token,
+	})
+}
+
+func (s *Server) ForgotPassword(c echo.Context) error {
+	input := new(InputForgotPassword)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if input.Username == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	// The same response is returned for unknown users so the route can't
+	// be used to enumerate accounts.
+	sent := resp("if the account has an email, reset instructions are sent to it")
+
+	u, err := s.db.GetUser(input.Username)
+	if err == mongo.ErrNoDocuments {
+		return c.JSON(http.StatusOK, sent)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if u.Email == "" || u.Disabled {
+		return c.JSON(http.StatusOK, sent)
+	}
+
+	token, err := s.cachedb.AddPasswordResetToken(u.Id.Hex(), config.PasswordResetTTL)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	text := fmt.Sprintf("Hello, %s!\n\nSomebody requested a password reset for your deepenc "+
+		"account. If it was not you, ignore this email.\n\nReset token (expires in %s):\n%s\n\n"+
+		"Send it with the new password to POST %s/api/password/reset\n",
+		u.Username, config.PasswordResetTTL, token, config.BaseURL)
+	if err = s.mailer.Send(u.Email, "deepenc: password reset", text); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, sent)
+}
+
+func (s *Server) ResetPassword(c echo.Context) error {
+	input := new(InputResetPassword)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if input.Token == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !isValidUserPassword(input.Password) {
+		return c.JSON(http.StatusBadRequest, resp(INVALID_USER_PASSWORD_TEXT))
+	}
+
+	userId, err := s.cachedb.GetPasswordResetToken(input.Token)
+	if err == database.ErrTokenNotFound {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.setPassword(u, input.Password); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	s.recordAudit(c, tokensRevokedEvent(u.Id.Hex(), "password reset"))
+
+	if err = s.cachedb.ResetFailedLogins(u.Username); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.notifier.Notify(u, "password reset",
+		fmt.Sprintf("password was reset from %s", c.RealIP())); err != nil {
+		c.Logger().Warn(err)
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+)
+
+type action int
+
+const (
+	actionRead action = iota
+	actionUpdate
+	actionDelete
+	actionList
+	actionOwnerRead
+	actionShare
+)
+
+var (
+	errNotFound  = errors.New("message is not found")
+	errForbidden = errors.New("action on message is forbidden")
+)
+
+// subject is the caller of a message action. Anonymous callers have empty
+// user id.
+type subject struct {
+	userId string
+	role   string
+}
+
+func (s subject) isAnonymous() bool {
+	return s.userId == ""
+}
+
+func (s subject) isAdmin() bool {
+	return !s.isAnonymous() && s.role == ROLE_ADMIN
+}
+
+func (s subject) owns(msg database.MessageOut) bool {
+	return !s.isAnonymous() && msg.OwnerId == s.userId
+}
+
+// subjectFromContext returns caller authenticated by JWT or API key, or
+// anonymous subject on public routes.
+func subjectFromContext(c echo.Context) subject {
+	claims, err := getClaimsFromJWT(c)
+	if err != nil {
+		return subject{}
+	}
+	return subject{
+		userId: claims.ID,
+		role:   getRoleFromJWT(c),
+	}
+}
+
+type policy func(sub subject, msg database.MessageOut) error
+
+// canRead is the read policy. Content of private messages is protected by
+// the password checked in handlers, so only owner-only messages are hidden
+// here.
+func canRead(sub subject, msg database.MessageOut) error {
+	if msg.OnlyOwnerView && !sub.owns(msg) {
+		return errNotFound
+	}
+	return nil
+}
+
+var messagePolicies = map[action]policy{
+	actionRead: canRead,
+	actionUpdate: func(sub subject, msg database.MessageOut) error {
+		if sub.owns(msg) {
+			return nil
+		}
+		return denied(sub, msg)
+	},
+	actionDelete: func(sub subject, msg database.MessageOut) error {
+		if sub.owns(msg) || sub.isAdmin() {
+			return nil
+		}
+		return denied(sub, msg)
+	},
+	// Owner reads content without message password, even admin can't.
+	actionOwnerRead: func(sub subject, msg database.MessageOut) error {
+		if sub.owns(msg) {
+			return nil
+		}
+		return denied(sub, msg)
+	},
+	// Share links give access to the message bypassing its visibility, only
+	// owner can create them.
+	actionShare: func(sub subject, msg database.MessageOut) error {
+		if sub.owns(msg) {
+			return nil
+		}
+		return denied(sub, msg)
+	},
+	actionList: func(sub subject, msg database.MessageOut) error {
+		if sub.owns(msg) || sub.isAdmin() {
+			return nil
+		}
+		return errNotFound
+	},
+}
+
+// denied hides messages the subject can't read at all and forbids the
+// action on messages the subject can see.
+func denied(sub subject, msg database.MessageOut) error {
+	if canRead(sub, msg) != nil {
+		return errNotFound
+	}
+	return errForbidden
+}
+
+func authorize(sub subject, act action, msg database.MessageOut) error {
+	p, ok := messagePolicies[act]
+	if !ok {
+		return errForbidden
+	}
+	return p(sub, msg)
+}
+
+func authorizationStatus(err error) int {
+	if err == errNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusForbidden
+}
+
+func filterAuthorized(sub subject, act action, messages database.MessagesOut) database.MessagesOut {
+	allowed := make(database.MessagesOut, 0, len(messages))
+	for _, msg := range messages {
+		if authorize(sub, act, msg) == nil {
+			allowed = append(allowed, msg)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestCasePolicy struct {
+	Name        string
+	Subject     subject
+	Action      action
+	Message     database.MessageOut
+	ExpectedErr error
+}
+
+func TestAuthorize(t *testing.T) {
+	owner := subject{userId: "owner", role: ROLE_USER}
+	other := subject{userId: "other", role: ROLE_USER}
+	admin := subject{userId: "admin", role: ROLE_ADMIN}
+	anonymous := subject{}
+	fakeAdmin := subject{role: ROLE_ADMIN}
+
+	public := database.MessageOut{OwnerId: "owner", EncodingType: "plaintext"}
+	private := database.MessageOut{OwnerId: "owner", EncodingType: "aes", IsPrivate: true}
+	ownerOnly := database.MessageOut{OwnerId: "owner", EncodingType: "internal",
+		IsPrivate: true, OnlyOwnerView: true}
+
+	cases := []TestCasePolicy{
+		{"owner reads public", owner, actionRead, public, nil},
+		{"owner reads owner-only", owner, actionRead, ownerOnly, nil},
+		{"non-owner reads public", other, actionRead, public, nil},
+		{"non-owner reads private", other, actionRead, private, nil},
+		{"non-owner reads owner-only", other, actionRead, ownerOnly, errNotFound},
+		{"anonymous reads public", anonymous, actionRead, public, nil},
+		{"anonymous reads private", anonymous, actionRead, private, nil},
+		{"anonymous reads owner-only", anonymous, actionRead, ownerOnly, errNotFound},
+		{"admin reads owner-only", admin, actionRead, ownerOnly, errNotFound},
+
+		{"owner updates", owner, actionUpdate, private, nil},
+		{"owner updates owner-only", owner, actionUpdate, ownerOnly, nil},
+		{"non-owner updates public", other, actionUpdate, public, errForbidden},
+		{"non-owner updates owner-only", other, actionUpdate, ownerOnly, errNotFound},
+		{"anonymous updates public", anonymous, actionUpdate, public, errForbidden},
+		{"admin updates public", admin, actionUpdate, public, errForbidden},
+		{"admin updates owner-only", admin, actionUpdate, ownerOnly, errNotFound},
+
+		{"owner deletes", owner, actionDelete, public, nil},
+		{"non-owner deletes public", other, actionDelete, public, errForbidden},
+		{"non-owner deletes owner-only", other, actionDelete, ownerOnly, errNotFound},
+		{"anonymous deletes private", anonymous, actionDelete, private, errForbidden},
+		{"admin deletes public", admin, actionDelete, public, nil},
+		{"admin deletes owner-only", admin, actionDelete, ownerOnly, nil},
+		{"admin role without user id deletes", fakeAdmin, actionDelete, public, errForbidden},
+
+		{"owner lists", owner, actionList, ownerOnly, nil},
+		{"non-owner lists", other, actionList, public, errNotFound},
+		{"anonymous lists", anonymous, actionList, public, errNotFound},
+		{"admin lists", admin, actionList, ownerOnly, nil},
+
+		{"owner reads own content", owner, actionOwnerRead, ownerOnly, nil},
+		{"non-owner reads own content of public", other, actionOwnerRead, public, errForbidden},
+		{"non-owner reads own content of owner-only", other, actionOwnerRead, ownerOnly, errNotFound},
+		{"anonymous reads own content", anonymous, actionOwnerRead, private, errForbidden},
+		{"admin reads own content", admin, actionOwnerRead, ownerOnly, errNotFound},
+
+		{"owner shares", owner, actionShare, ownerOnly, nil},
+		{"non-owner shares public", other, actionShare, public, errForbidden},
+		{"non-owner shares owner-only", other, actionShare, ownerOnly, errNotFound},
+		{"admin shares public", admin, actionShare, public, errForbidden},
+
+		{"unknown action", owner, action(100), public, errForbidden},
+	}
+
+	for _, testCase := range cases {
+		err := authorize(testCase.Subject, testCase.Action, testCase.Message)
+		assert.Equal(t, testCase.ExpectedErr, err, testCase.Name)
+	}
+}
+
+func TestFilterAuthorized(t *testing.T) {
+	messages := database.MessagesOut{
+		{OwnerId: "owner"},
+		{OwnerId: "other"},
+		{OwnerId: "owner", OnlyOwnerView: true},
+	}
+
+	owned := filterAuthorized(subject{userId: "owner"}, actionList, messages)
+	assert.Len(t, owned, 2)
+
+	assert.Empty(t, filterAuthorized(subject{}, actionList, messages))
+}
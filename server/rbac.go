@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ROLE_USER  = "user"
+	ROLE_ADMIN = "admin"
+
+	ACCOUNT_DISABLED_TEXT = "account is disabled"
+)
+
+var roles = map[string]bool{
+	ROLE_USER:  true,
+	ROLE_ADMIN: true,
+}
+
+// userRole returns stored role, accounts created before roles were added
+// have none and are regular users.
+func userRole(u database.UserOut) string {
+	if u.Role == "" {
+		return ROLE_USER
+	}
+	return u.Role
+}
+
+func getRoleFromJWT(c echo.Context) string {
+	claims, err := getClaimsFromJWT(c)
+	if err != nil || claims.Role == "" {
+		return ROLE_USER
+	}
+	return claims.Role
+}
+
+func requireRole(allowed ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			role := getRoleFromJWT(c)
+			for _, v := range allowed {
+				if v == role {
+					return next(c)
+				}
+			}
+			return c.String(http.StatusForbidden, "")
+		}
+	}
+}
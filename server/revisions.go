@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// addRevision stores message content as a new revision.
+func (s *Server) addRevision(msg database.MessageOut) error {
+	_, err := s.db.AddRevision(&database.Revision{
+		MessageId:    msg.Id.Hex(),
+		OwnerId:      msg.OwnerId,
+		Content:      msg.Content,
+		EncodingType: msg.EncodingType,
+		Password:     msg.Password,
+		IsPrivate:    msg.IsPrivate,
+		CreatedAt:    time.Now().UTC(),
+	})
+	return err
+}
+
+// saveRevision keeps replaced content of the message and drops revisions
+// above retention limit. It is called after the versioned update succeeds,
+// so the loser of concurrent updates leaves no revision. Errors are only
+// logged because the content is already replaced, extra revisions are
+// dropped by the next update.
+func (s *Server) saveRevision(c echo.Context, replaced database.MessageOut) {
+	if err := s.addRevision(replaced); err != nil {
+		c.Logger().Error(err)
+	}
+	if err := s.db.PruneRevisions(replaced.Id.Hex(), config.RevisionsRetention); err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+func (s *Server) GetMessageRevisions(c echo.Context) error {
+	msgId := c.Param("id")
+	if msgId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	msg, err := s.loadMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = authorize(subjectFromContext(c), actionOwnerRead, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	revisions, err := s.db.GetRevisions(msg.Id.Hex())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+func (s *Server) RestoreMessageRevision(c echo.Context) error {
+	msgId := c.Param("id")
+	if msgId == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil || number < 1 {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	msg, err := s.loadMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = authorize(subjectFromContext(c), actionUpdate, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	revision, err := s.db.GetRevision(msg.Id.Hex(), number)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	version, err := expectedVersion(c, msg.Version)
+	if err != nil {
+		return c.String(versionStatus(err), "")
+	}
+
+	err = s.db.UpdateMessage(msg.Id.Hex(), version, &database.Message{
+		OwnerId:        msg.OwnerId,
+		Content:        revision.Content,
+		IsPrivate:      revision.IsPrivate,
+		EncodingType:   revision.EncodingType,
+		Password:       revision.Password,
+		OnlyOwnerView:  msg.OnlyOwnerView,
+		IsAnon:         msg.IsAnon,
+		IsOneTime:      msg.IsOneTime,
+		Title:          msg.Title,
+		TitleEncrypted: msg.TitleEncrypted,
+		Tags:           msg.Tags,
+		CollectionId:   msg.CollectionId,
+		CreatedAt:      msg.CreatedAt,
+		UpdatedAt:      time.Now().UTC(),
+		ExpiresAt:      msg.ExpiresAt,
+	})
+	if err == database.ErrVersionConflict {
+		return c.String(http.StatusPreconditionFailed, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	// Restoring is an update too, so the replaced content becomes a revision
+	// and the restore can be undone.
+	s.saveRevision(c, msg)
+	s.recordAudit(c, messageEvent(AUDIT_MESSAGE_UPDATE, msg))
+
+	c.Response().Header().Set(ETAG_HEADER, messageETag(version+1))
+	return c.String(http.StatusNoContent, "")
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TestCaseUpdateRevision struct {
+	Name              string
+	IfMatch           string
+	Content           string
+	ExpectedStatus    int
+	ExpectedRevisions []string
+}
+
+func TestUpdateMessageSavesRevisionAfterUpdate(t *testing.T) {
+	owner := primitive.NewObjectID().Hex()
+	msg := database.MessageOut{
+		Id:           primitive.NewObjectID(),
+		OwnerId:      owner,
+		Content:      "first",
+		EncodingType: "plaintext",
+		Version:      1,
+	}
+	db := newFakeStorager()
+	db.addMessages(msg)
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	// Updates are made one after another.
+	cases := []TestCaseUpdateRevision{
+		{"current version", `"1"`, "second", http.StatusNoContent, []string{"first"}},
+		{"stale version", `"1"`, "lost", http.StatusPreconditionFailed, []string{"first"}},
+		{"without version", "", "third", http.StatusNoContent, []string{"first", "second"}},
+	}
+
+	for _, tc := range cases {
+		body := `{"content":"` + tc.Content + `","encoding_type":"plaintext"}`
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if tc.IfMatch != "" {
+			req.Header.Set(IF_MATCH_HEADER, tc.IfMatch)
+		}
+		rec := httptest.NewRecorder()
+		c := s.e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(msg.Id.Hex())
+		authenticate(c, owner, ROLE_USER)
+
+		s.UpdateMessage(c)
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, tc.Name)
+
+		var revisions []string
+		for _, r := range db.revs {
+			revisions = append(revisions, r.Content)
+		}
+		assert.Equal(t, tc.ExpectedRevisions, revisions, tc.Name)
+	}
+	assert.Equal(t, "third", db.messages[msg.Id.Hex()].Content)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+)
+
+const MAX_SEARCH_QUERY_SIZE = 256
+
+// SearchMessages looks for the query in public plaintext messages and in
+// messages of the caller. Results are ordered by relevance.
+func (s *Server) SearchMessages(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	text := strings.TrimSpace(c.QueryParam("q"))
+	if text == "" || len(text) > MAX_SEARCH_QUERY_SIZE {
+		return c.JSON(http.StatusBadRequest, resp("q query param is empty or too long"))
+	}
+
+	skip, limit := parsePagination(c)
+
+	messages, total, err := s.db.SearchMessages(database.SearchQuery{
+		Text:    text,
+		OwnerId: userId,
+		Skip:    skip,
+		Limit:   limit,
+	})
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	results := make([]PublicMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.OwnerId != userId {
+			results = append(results, toPublicFormat(msg))
+			continue
+		}
+
+		// The owner sees own titles and authorship.
+		if err = openTitle(&msg); err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		result := toPublicFormat(msg)
+		result.Title, result.OwnerId = msg.Title, msg.OwnerId
+		results = append(results, result)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total":    total,
+		"messages": results,
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setInternalKey sets internal encryption key for the test.
+func setInternalKey(t *testing.T) {
+	key := config.AESInternalKey
+	config.AESInternalKey = []byte("internal-test-key")
+	t.Cleanup(func() { config.AESInternalKey = key })
+}
+
+type TestCaseSearchVisibility struct {
+	Name          string
+	Message       database.MessageOut
+	ExpectedFound bool
+	ExpectedTitle string
+	ExpectedOwner string
+}
+
+func TestSearchMessagesVisibility(t *testing.T) {
+	setInternalKey(t)
+
+	caller := primitive.NewObjectID().Hex()
+	other := primitive.NewObjectID().Hex()
+
+	sealed, _, err := sealTitle("needle sealed", true)
+	assert.Nil(t, err)
+
+	plaintext := func(owner string) database.MessageOut {
+		return database.MessageOut{
+			Id:           primitive.NewObjectID(),
+			OwnerId:      owner,
+			Content:      "needle",
+			EncodingType: "plaintext",
+		}
+	}
+
+	cases := []TestCaseSearchVisibility{
+		{"public message of other user", plaintext(other), true, "", other},
+		{"anonymous message of other user", func() database.MessageOut {
+			m := plaintext(other)
+			m.IsAnon = true
+			return m
+		}(), true, "", ""},
+		{"private message of other user", func() database.MessageOut {
+			m := plaintext(other)
+			m.IsPrivate = true
+			m.EncodingType = "password"
+			m.Password = "hashed"
+			return m
+		}(), false, "", ""},
+		{"one-time message of other user", func() database.MessageOut {
+			m := plaintext(other)
+			m.IsOneTime = true
+			return m
+		}(), false, "", ""},
+		{"owner-only message of other user", func() database.MessageOut {
+			m := plaintext(other)
+			m.OnlyOwnerView = true
+			return m
+		}(), false, "", ""},
+		{"encrypted title of other user", func() database.MessageOut {
+			m := plaintext(other)
+			m.Title, m.TitleEncrypted = sealed, true
+			return m
+		}(), true, "", other},
+		{"private message of the caller", func() database.MessageOut {
+			m := plaintext(caller)
+			m.IsPrivate = true
+			m.EncodingType = "password"
+			m.Password = "hashed"
+			return m
+		}(), true, "", caller},
+		{"encrypted title of the caller", func() database.MessageOut {
+			m := plaintext(caller)
+			m.Title, m.TitleEncrypted = sealed, true
+			m.OnlyOwnerView = true
+			return m
+		}(), true, "needle sealed", caller},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			db := newFakeStorager()
+			db.addMessages(tc.Message)
+			s, _ := newTestServer(t, db, newFakeCacher())
+
+			req := httptest.NewRequest(http.MethodGet, "/?q="+url.QueryEscape("needle"), nil)
+			rec := httptest.NewRecorder()
+			c := s.e.NewContext(req, rec)
+			authenticate(c, caller, ROLE_USER)
+
+			s.SearchMessages(c)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			var result struct {
+				Total    int64                    `json:"total"`
+				Messages []map[string]interface{} `json:"messages"`
+			}
+			assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+
+			if !tc.ExpectedFound {
+				assert.Equal(t, int64(0), result.Total)
+				assert.Empty(t, result.Messages)
+				return
+			}
+
+			assert.Equal(t, int64(1), result.Total)
+			if assert.Len(t, result.Messages, 1) {
+				// Empty fields may be omitted.
+				m := result.Messages[0]
+				title, _ := m["title"].(string)
+				owner, _ := m["owner_id"].(string)
+				assert.Equal(t, tc.ExpectedTitle, title)
+				assert.Equal(t, tc.ExpectedOwner, owner)
+				assert.Empty(t, m["password"])
+			}
+		})
+	}
+}
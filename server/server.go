@@ -2,10 +2,15 @@ package server
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/audit"
 	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/mailer"
+	"github.com/arimatakao/deepenc/server/oidc"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -13,41 +18,141 @@ import (
 )
 
 type Server struct {
-	e       *echo.Echo
-	db      database.Storager
-	cachedb database.Cacher
+	e        *echo.Echo
+	db       database.Storager
+	cachedb  database.Cacher
+	mailer   mailer.Mailer
+	notifier Notifier
+	auditor  audit.Auditor
+	jwtKeys  *jwtKeySet
+	oidc     *oidc.Provider
+	events   *eventHub
+	stopJobs context.CancelFunc
 }
 
 func (s *Server) Init() error {
 	s.e = echo.New()
 	s.e.HideBanner = true
+	s.e.IPExtractor = newIPExtractor(config.TrustedProxies)
 
 	s.e.Pre(middleware.RemoveTrailingSlash())
-	s.e.Use(middleware.Logger())
+	s.e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		// Requests with ticket in the query are not logged to keep the
+		// ticket out of logs.
+		Skipper: isEventsTicketRequest,
+	}))
 	s.e.Logger.SetLevel(log.INFO)
 
 	s.e.RouteNotFound("/*", func(c echo.Context) error {
 		return c.NoContent(http.StatusNotFound)
 	})
 
+	jwtKeys, err := loadJWTKeySet()
+	if err != nil {
+		return err
+	}
+	s.jwtKeys = jwtKeys
+
+	s.e.GET("/.well-known/jwks.json", s.GetJWKS) // Public keys for JWT verification
+
 	basePath := s.e.Group("/api")
 
 	// Public routes
-	basePath.POST("/signup", s.SignUp)                       // Registration
-	basePath.GET("/verify/:token", s.VerifySignUp)           // Verification
-	basePath.POST("/signin", s.SignIn)                       // Login
-	basePath.GET("/messages/public/:id", s.GetPublicMessage) // Get public message by id
-	basePath.POST("/messages/:id", s.GetPrivateMessage)      // Get private message by id
+	basePath.POST("/signup", s.SignUp)                            // Registration
+	basePath.GET("/verify/:token", s.VerifySignUp)                // Verification
+	basePath.POST("/signin", s.SignIn)                            // Login
+	basePath.POST("/signin/2fa", s.SignInSecondFactor)            // Second login step with TOTP code
+	basePath.POST("/password/forgot", s.ForgotPassword)           // Send password reset token by email
+	basePath.POST("/password/reset", s.ResetPassword)             // Set new password by reset token
+	basePath.GET("/oidc/login", s.OIDCLogin, s.requireOIDC)       // Redirect to single sign-on provider
+	basePath.GET("/oidc/callback", s.OIDCCallback, s.requireOIDC) // Sign in by single sign-on provider
+	basePath.GET("/messages/public/:id", s.GetPublicMessage)      // Get public message by public id
+	basePath.POST("/messages/:id", s.GetPrivateMessage)           // Get private message by public id
+	basePath.GET("/share/:token", s.GetSharedMessage)             // Get message by share link
+	basePath.POST("/share/:token", s.GetSharedMessage)            // Get message by share link with password
+
+	eventsJWTConfig := newJWTConfig(s.jwtKeys)
+	eventsJWTConfig.Skipper = isEventsTicketRequest
+
+	basePath.POST("/events/ticket", s.CreateEventsTicket, echojwt.WithConfig(newJWTConfig(s.jwtKeys)),
+		s.checkTokenRevocation) // Get one-time ticket to open event stream
+	basePath.GET("/events", s.StreamEvents, s.authenticateEventsTicket, echojwt.WithConfig(eventsJWTConfig),
+		s.checkTokenRevocation) // Stream of user events, JWT in header or ticket param
 
 	// JWT Auth routes
+	accountPath := basePath.Group("/account")
+	accountPath.Use(echojwt.WithConfig(newJWTConfig(s.jwtKeys)), s.checkTokenRevocation)
+
+	accountPath.POST("/2fa", s.EnrollTOTP)             // Start TOTP enrollment
+	accountPath.POST("/2fa/confirm", s.ConfirmTOTP)    // Confirm TOTP enrollment by code
+	accountPath.DELETE("/2fa", s.DisableTOTP)          // Disable TOTP
+	accountPath.PUT("/password", s.ChangePassword)     // Change password and revoke tokens
+	accountPath.DELETE("", s.DeleteAccount)            // Schedule account deletion with messages
+	accountPath.POST("/restore", s.RestoreAccount)     // Cancel scheduled account deletion
+	accountPath.GET("/export", s.ExportAccount)        // Download profile and messages archive
+	accountPath.POST("/apikeys", s.CreateAPIKey)       // Create API key, the key is shown once
+	accountPath.GET("/apikeys", s.GetAPIKeysList)      // Get list of user API keys
+	accountPath.DELETE("/apikeys/:id", s.DeleteAPIKey) // Revoke API key
+	accountPath.POST("/vault/export", s.ExportVault)   // Download passphrase-encrypted archive of messages
+	accountPath.POST("/vault/import", s.ImportVault)   // Recreate messages from the archive
+
+	accountPath.POST("/webhooks", s.CreateWebhook)                      // Subscribe url to message events, the secret is shown once
+	accountPath.GET("/webhooks", s.GetWebhooksList)                     // Get list of user webhooks
+	accountPath.DELETE("/webhooks/:id", s.DeleteWebhook)                // Remove webhook
+	accountPath.GET("/webhooks/:id/deliveries", s.GetWebhookDeliveries) // Get delivery log of the webhook
+
+	// JWT or API key Auth routes
+	messagesJWTConfig := newJWTConfig(s.jwtKeys)
+	messagesJWTConfig.Skipper = isAPIKeyAuthenticated
+
 	messagePath := basePath.Group("/messages")
-	messagePath.Use(echojwt.WithConfig(newJWTConfig(config.JWTSecret)))
+	messagePath.Use(s.authenticateAPIKey, echojwt.WithConfig(messagesJWTConfig),
+		s.checkTokenRevocation)
+
+	read := requireScope(SCOPE_MESSAGES_READ)
+	write := requireScope(SCOPE_MESSAGES_WRITE)
 
-	messagePath.GET("/public", s.GetPublicMessagesList) // Get list of public messages with text
-	messagePath.GET("", s.GetUserMessagesList)          // Get list of user id messages
-	messagePath.POST("", s.CreateMessage)               // Create message
-	messagePath.PUT("/:id", s.UpdateMessage)            // Update message
-	messagePath.DELETE("/:id", s.DeleteMessage)         // Delete message by hand if ttl not set
+	messagePath.GET("/public", s.GetPublicMessagesList, read) // Get list of public messages with text
+	messagePath.GET("", s.GetUserMessagesList, read)          // Get list of user id messages
+	messagePath.GET("/search", s.SearchMessages, read)        // Search public and own messages
+	messagePath.GET("/:id", s.GetOwnMessage, read)            // Get own message with decrypted content
+	messagePath.POST("", s.CreateMessage, write)              // Create message
+	messagePath.PUT("/:id", s.UpdateMessage, write)           // Update message
+	messagePath.PATCH("/:id", s.PatchMessage, write)          // Update message metadata
+	messagePath.DELETE("/:id", s.DeleteMessage, write)        // Delete message by hand if ttl not set
+	messagePath.POST("/bulk", s.BulkMessages, write)          // Delete or change many messages at once
+	messagePath.POST("/import", s.ImportMessages, write)      // Import secrets exported from other tools
+
+	messagePath.GET("/:id/revisions", s.GetMessageRevisions, read)                      // Get list of message revisions
+	messagePath.POST("/:id/revisions/:number/restore", s.RestoreMessageRevision, write) // Restore message revision
+	messagePath.GET("/:id/access-log", s.GetMessageAccessLog, read)                     // Get reads of the message by other users
+	messagePath.POST("/:id/shares", s.CreateShareLink, write)                           // Create share link, the token is shown once
+	messagePath.GET("/:id/shares", s.GetShareLinksList, read)                           // Get list of active share links
+	messagePath.DELETE("/:id/shares/:shareId", s.DeleteShareLink, write)                // Revoke share link
+
+	collectionPath := basePath.Group("/collections")
+	collectionPath.Use(s.authenticateAPIKey, echojwt.WithConfig(messagesJWTConfig),
+		s.checkTokenRevocation)
+
+	collectionPath.GET("", s.GetCollectionsList, read)                 // Get list of user collections
+	collectionPath.GET("/:id/messages", s.GetCollectionMessages, read) // Get collection content
+	collectionPath.POST("", s.CreateCollection, write)                 // Create collection
+	collectionPath.PUT("/:id", s.UpdateCollection, write)              // Rename, move or change defaults
+	collectionPath.DELETE("/:id", s.DeleteCollection, write)           // Delete collection, content goes to parent
+
+	// Admin routes
+	adminPath := basePath.Group("/admin")
+	adminPath.Use(echojwt.WithConfig(newJWTConfig(s.jwtKeys)), s.checkTokenRevocation,
+		requireRole(ROLE_ADMIN))
+
+	adminPath.GET("/users", s.AdminGetUsers)                // Get list of users
+	adminPath.PUT("/users/:id/disable", s.AdminDisableUser) // Disable account and revoke tokens
+	adminPath.PUT("/users/:id/enable", s.AdminEnableUser)   // Enable account
+	adminPath.PUT("/users/:id/role", s.AdminSetUserRole)    // Change user role
+	adminPath.DELETE("/messages/:id", s.AdminDeleteMessage) // Delete any message
+	adminPath.GET("/stats", s.AdminGetStats)                // Get system stats
+	adminPath.GET("/audit", s.AdminGetAuditLog)             // Get security events
+	adminPath.GET("/audit/verify", s.AdminVerifyAuditLog)   // Check audit log for tampering
 
 	// Connect to DB
 	db, err := database.NewMainDB(config.MongoURL)
@@ -63,14 +168,90 @@ func (s *Server) Init() error {
 	}
 	s.cachedb = cachedb
 
+	auditor, err := NewAuditor()
+	if err != nil {
+		return err
+	}
+	s.auditor = auditor
+
+	if config.SMTPHost != "" {
+		m, err := mailer.NewSMTPMailer(config.SMTPHost, config.SMTPPort,
+			config.SMTPUsername, config.SMTPPassword, config.SMTPFrom)
+		if err != nil {
+			return err
+		}
+		s.mailer = m
+	} else {
+		s.e.Logger.Warn("smtp host is not set, emails will be written to the log")
+		s.mailer = mailer.NewLogMailer(s.e.Logger.Infof)
+	}
+
+	s.notifier = newMailNotifier(s.mailer, newLogNotifier(s.e.Logger))
+
+	if config.OIDCIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		provider, err := oidc.NewProvider(ctx, config.OIDCIssuer, config.OIDCClientId,
+			config.OIDCClientSecret, config.OIDCRedirectURL, config.OIDCScopes)
+		if err != nil {
+			return err
+		}
+		s.oidc = provider
+	}
+
 	return nil
 }
 
+func (s *Server) requireOIDC(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.oidc == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return next(c)
+	}
+}
+
+// newIPExtractor takes client IP from X-Forwarded-For only when the request
+// comes from trusted proxy, otherwise headers set by the client are ignored.
+func newIPExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range trustedProxies {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (s *Server) Run() error {
+	sub, err := s.cachedb.SubscribeUserEvents(context.Background())
+	if err != nil {
+		return err
+	}
+	s.events = newEventHub(sub)
+
+	s.startJobs()
 	return s.e.Start(":" + config.Port)
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.stopJobs != nil {
+		s.stopJobs()
+	}
+	// Open event streams would hold the shutdown until the timeout.
+	if s.events != nil {
+		if err := s.events.Close(); err != nil {
+			return err
+		}
+	}
 	if err := s.e.Shutdown(ctx); err != nil {
 		return err
 	}
@@ -80,5 +261,8 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	if err := s.cachedb.Shutdown(ctx); err != nil {
 		return err
 	}
+	if err := s.auditor.Close(); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIPExtractor(t *testing.T) {
+	type TestCaseNewIPExtractor struct {
+		Name           string
+		TrustedProxies []string
+		RemoteAddr     string
+		Expected       string
+	}
+
+	testCases := []TestCaseNewIPExtractor{
+		{
+			Name:       "direct client",
+			RemoteAddr: "203.0.113.7:52000",
+			Expected:   "203.0.113.7",
+		},
+		{
+			Name:       "private address without trusted proxies",
+			RemoteAddr: "10.0.0.5:52000",
+			Expected:   "10.0.0.5",
+		},
+		{
+			Name:           "trusted proxy",
+			TrustedProxies: []string{"10.0.0.0/8"},
+			RemoteAddr:     "10.0.0.5:52000",
+			Expected:       "198.51.100.1",
+		},
+		{
+			Name:           "untrusted proxy",
+			TrustedProxies: []string{"10.0.0.0/8"},
+			RemoteAddr:     "192.168.1.5:52000",
+			Expected:       "192.168.1.5",
+		},
+	}
+
+	for _, tc := range testCases {
+		trusted := []*net.IPNet{}
+		for _, cidr := range tc.TrustedProxies {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			assert.Nil(t, err)
+			trusted = append(trusted, ipNet)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.RemoteAddr
+		req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+		req.Header.Set(echo.HeaderXRealIP, "198.51.100.2")
+
+		assert.Equal(t, tc.Expected, newIPExtractor(trusted)(req), tc.Name)
+	}
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SHARE_TOKEN_PREFIX = "dps_"
+
+	DEFAULT_SHARE_TTL     = 7 * 24 * 60 * 60
+	MAX_SHARE_VIEWS       = 1000
+	MAX_SHARE_LINKS_COUNT = 20
+
+	// Limit of recipient notifications sent by one user.
+	MAX_SHARE_NOTIFICATIONS    = 30
+	SHARE_NOTIFICATIONS_WINDOW = time.Hour
+)
+
+type InputShareLink struct {
+	// TTL is the link lifetime in seconds, zero means default lifetime.
+	TTL int `json:"ttl"`
+	// MaxViews limits how many times the link can be opened, zero means
+	// without limit.
+	MaxViews int    `json:"max_views"`
+	Password string `json:"password"`
+	// Recipient is username of the user notified about the link. Unknown
+	// recipient is skipped silently, so usernames can't be checked by it.
+	Recipient string `json:"recipient"`
+}
+
+func (i InputShareLink) isValid() bool {
+	if i.TTL < 0 || i.TTL > MAX_TTL {
+		return false
+	}
+
+	if i.MaxViews < 0 || i.MaxViews > MAX_SHARE_VIEWS {
+		return false
+	}
+
+	return i.Password == "" || len(i.Password) >= MIN_PASSWORD_SIZE
+}
+
+// InputSharedMessage holds password of the share link and password of aes
+// message content, which the link can't replace.
+type InputSharedMessage struct {
+	Password        string `json:"password"`
+	MessagePassword string `json:"message_password"`
+}
+
+func shareURL(token string) string {
+	return config.BaseURL + "/api/share/" + token
+}
+
+// CreateShareLink creates link to the message, the token is shown once and
+// only its hash is stored.
+func (s *Server) CreateShareLink(c echo.Context) error {
+	msgId := c.Param("id")
+
+	input := new(InputShareLink)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !input.isValid() {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	msg, err := s.loadMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = authorize(subjectFromContext(c), actionShare, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	links, err := s.db.GetShareLinks(msg.Id.Hex())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if len(links) >= MAX_SHARE_LINKS_COUNT {
+		return c.JSON(http.StatusConflict, resp("share links limit is reached"))
+	}
+
+	var recipient *database.UserOut
+	if input.Recipient != "" {
+		sent, err := s.cachedb.AddShareNotification(subjectFromContext(c).userId,
+			SHARE_NOTIFICATIONS_WINDOW)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		if sent > MAX_SHARE_NOTIFICATIONS {
+			return c.JSON(http.StatusTooManyRequests, resp("recipient notifications limit is reached"))
+		}
+
+		u, err := s.db.GetUser(input.Recipient)
+		if err == nil {
+			recipient = &u
+		} else if err != mongo.ErrNoDocuments {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+	}
+
+	secret, err := utils.RandomToken(32)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	token := SHARE_TOKEN_PREFIX + secret
+
+	hashedPassword := ""
+	if input.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		hashedPassword = string(hashed)
+	}
+
+	ttl := input.TTL
+	if ttl == 0 {
+		ttl = DEFAULT_SHARE_TTL
+	}
+
+	l := &database.ShareLink{
+		OwnerId:        msg.OwnerId,
+		MessageId:      msg.Id.Hex(),
+		HashedToken:    hashToken(token),
+		HashedPassword: hashedPassword,
+		MaxViews:       input.MaxViews,
+		CreatedAt:      time.Now().UTC(),
+		ExpiresAt:      *expiresAt(ttl),
+	}
+
+	id, err := s.db.AddShareLink(l)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if recipient != nil {
+		if err = s.notifyRecipient(*recipient, msg.OwnerId, token, l); err != nil {
+			c.Logger().Error(err)
+		}
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":         id,
+		"token":      token,
+		"url":        shareURL(token),
+		"max_views":  l.MaxViews,
+		"expires_at": l.ExpiresAt,
+	})
+}
+
+// notifyRecipient pushes the link to connected clients of the recipient.
+func (s *Server) notifyRecipient(recipient database.UserOut, ownerId, token string,
+	l *database.ShareLink) error {
+	owner, err := s.db.GetUserById(ownerId)
+	if err != nil {
+		return err
+	}
+
+	return s.publishUserEvent(recipient.Id.Hex(), EVENT_MESSAGE_SHARED, map[string]interface{}{
+		"from":         owner.Username,
+		"url":          shareURL(token),
+		"has_password": l.HashedPassword != "",
+		"max_views":    l.MaxViews,
+		"expires_at":   l.ExpiresAt,
+	})
+}
+
+func (s *Server) GetShareLinksList(c echo.Context) error {
+	msgId := c.Param("id")
+
+	msg, err := s.loadMessage(msgId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = authorize(subjectFromContext(c), actionShare, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	links, err := s.db.GetShareLinks(msg.Id.Hex())
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, links)
+}
+
+// DeleteShareLink revokes the link, the message stays as is.
+func (s *Server) DeleteShareLink(c echo.Context) error {
+	msg, err := s.loadMessage(c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = authorize(subjectFromContext(c), actionShare, msg); err != nil {
+		return c.String(authorizationStatus(err), "")
+	}
+
+	err = s.db.DeleteShareLink(msg.OwnerId, msg.Id.Hex(), c.Param("shareId"))
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+// GetSharedMessage opens the message by share link token. Link password and
+// password of aes content are read from the request body only, so they don't
+// end up in access logs. A view is counted only after both are checked.
+func (s *Server) GetSharedMessage(c echo.Context) error {
+	token := c.Param("token")
+	if token == "" {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputSharedMessage)
+	if err := (&echo.DefaultBinder{}).BindBody(c, input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	link, err := s.db.GetShareLink(hashToken(token))
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if link.MaxViews > 0 && link.Views >= link.MaxViews {
+		return c.String(http.StatusNotFound, "")
+	}
+
+	if link.HasPassword {
+		if input.Password == "" {
+			return c.JSON(http.StatusUnauthorized, resp("share link password is required"))
+		}
+		err = bcrypt.CompareHashAndPassword([]byte(link.HashedPassword), []byte(input.Password))
+		if err != nil {
+			return c.JSON(http.StatusForbidden, resp("share link password is wrong"))
+		}
+	}
+
+	msg, err := s.loadMessage(link.MessageId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if msg.EncodingType == "aes" && input.MessagePassword == "" {
+		return c.JSON(http.StatusUnauthorized, resp("message password is required"))
+	}
+
+	// The link is made by the owner, so it replaces the message password
+	// except aes encryption key.
+	err = openMessage(&msg, input.MessagePassword, true)
+	if err == errWrongPassword {
+		s.logAccess(c, msg, ACCESS_OUTCOME_WRONG_PASSWORD)
+		return c.JSON(http.StatusForbidden, resp("message password is wrong"))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	err = s.db.UseShareLink(link.Id.Hex(), time.Now())
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.consumeMessage(msg); err != nil {
+		c.Logger().Error(err)
+		return c.JSON(http.StatusNotFound, "")
+	}
+	s.logAccess(c, msg, ACCESS_OUTCOME_READ)
+	s.markAccessed(c, &msg)
+
+	return c.JSON(http.StatusOK, toPublicFormat(msg))
+}
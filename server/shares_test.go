@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TestCaseShareLink struct {
+	Name     string
+	Input    InputShareLink
+	Expected bool
+}
+
+func TestInputShareLinkIsValid(t *testing.T) {
+	cases := []TestCaseShareLink{
+		{"defaults", InputShareLink{}, true},
+		{"limited", InputShareLink{TTL: 3600, MaxViews: 3, Password: "password123"}, true},
+		{"negative ttl", InputShareLink{TTL: -1}, false},
+		{"ttl over max", InputShareLink{TTL: MAX_TTL + 1}, false},
+		{"negative views", InputShareLink{MaxViews: -1}, false},
+		{"too many views", InputShareLink{MaxViews: MAX_SHARE_VIEWS + 1}, false},
+		{"short password", InputShareLink{Password: "short"}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Expected, tc.Input.isValid())
+		})
+	}
+}
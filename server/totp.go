@@ -0,0 +1,261 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	TOTP_ISSUER           = "deepenc"
+	LOGIN_CHALLENGE_TTL   = 5 * time.Minute
+	RECOVERY_CODES_AMOUNT = 10
+)
+
+type InputTOTPCode struct {
+	Code string `json:"code"`
+}
+
+type InputSecondFactor struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func generateRecoveryCodes() (codes []string, hashedCodes []string, err error) {
+	for i := 0; i < RECOVERY_CODES_AMOUNT; i++ {
+		code, err := utils.RandomToken(10)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashedCodes = append(hashedCodes, hashRecoveryCode(code))
+	}
+	return codes, hashedCodes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.TrimSpace(code))))
+}
+
+// useTOTPCode checks the code and marks its time step as used, so the same
+// code can't be accepted twice while it's still within the window.
+func (s *Server) useTOTPCode(u database.UserOut, code string) (bool, error) {
+	step, ok := utils.MatchTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.db.UseTOTPStep(u.Id.Hex(), step)
+}
+
+func (s *Server) EnrollTOTP(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if u.TOTPEnabled {
+		return c.JSON(http.StatusConflict, resp("two-factor authentication is already enabled"))
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.db.SetTOTP(userId, secret, false); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret": secret,
+		"uri":    utils.TOTPProvisioningURI(TOTP_ISSUER, u.Username, secret),
+	})
+}
+
+func (s *Server) ConfirmTOTP(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputTOTPCode)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if u.TOTPEnabled {
+		return c.JSON(http.StatusConflict, resp("two-factor authentication is already enabled"))
+	}
+
+	if u.TOTPSecret == "" {
+		return c.JSON(http.StatusBadRequest, resp("two-factor enrollment is not started"))
+	}
+
+	valid, err := s.useTOTPCode(u, input.Code)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, resp("code is not valid"))
+	}
+
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.db.SetRecoveryCodes(userId, hashedCodes); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.db.SetTOTP(userId, u.TOTPSecret, true); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+func (s *Server) DisableTOTP(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputTOTPCode)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if !u.TOTPEnabled {
+		return c.JSON(http.StatusBadRequest, resp("two-factor authentication is not enabled"))
+	}
+
+	valid, err := s.useTOTPCode(u, input.Code)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, resp("code is not valid"))
+	}
+
+	if err = s.db.SetTOTP(userId, "", false); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err = s.db.SetRecoveryCodes(userId, []string{}); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+func (s *Server) SignInSecondFactor(c echo.Context) error {
+	input := new(InputSecondFactor)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if input.Challenge == "" || (input.Code == "" && input.RecoveryCode == "") {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	userId, err := s.cachedb.GetLoginChallenge(input.Challenge)
+	if err == database.ErrTokenNotFound {
+		return c.String(http.StatusUnauthorized, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	u, err := s.db.GetUserById(userId)
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusUnauthorized, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	attempts, err := s.cachedb.GetFailedLogins(u.Username)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if attempts >= config.LoginMaxAttempts {
+		s.auditFailure(c, signInEvent(u), "account is locked")
+		return c.JSON(http.StatusTooManyRequests,
+			resp("account is temporarily locked, try again later"))
+	}
+
+	if input.RecoveryCode != "" {
+		used, err := s.db.UseRecoveryCode(userId, hashRecoveryCode(input.RecoveryCode))
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+		if !used {
+			return s.failSignIn(c, u)
+		}
+		return s.completeSignIn(c, u)
+	}
+
+	valid, err := s.useTOTPCode(u, input.Code)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !valid {
+		return s.failSignIn(c, u)
+	}
+
+	return s.completeSignIn(c, u)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TestCaseSecondFactor struct {
+	Name           string
+	Code           string
+	ExpectedStatus int
+}
+
+func TestSignInSecondFactorRejectsReplay(t *testing.T) {
+	setLoginLimits(t, 10, time.Minute)
+
+	secret, err := utils.GenerateTOTPSecret()
+	assert.Nil(t, err)
+
+	now := time.Now()
+	current, err := utils.TOTPCode(secret, now)
+	assert.Nil(t, err)
+	previous, err := utils.TOTPCode(secret, now.Add(-utils.TOTP_PERIOD*time.Second))
+	assert.Nil(t, err)
+	wrong := "000000"
+	if wrong == current {
+		wrong = "111111"
+	}
+
+	u := database.UserOut{
+		Id:          primitive.NewObjectID(),
+		Username:    "alice",
+		TOTPSecret:  secret,
+		TOTPEnabled: true,
+	}
+	cache := newFakeCacher()
+	cache.challenges["challenge"] = u.Id.Hex()
+	s, _ := newTestServer(t, newFakeStorager(u), cache)
+
+	// Codes are sent one after another with the same challenge.
+	cases := []TestCaseSecondFactor{
+		{"current code", current, http.StatusOK},
+		{"replayed code", current, http.StatusBadRequest},
+		{"code of the previous step", previous, http.StatusBadRequest},
+		{"wrong code", wrong, http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		body := `{"challenge":"challenge","code":"` + tc.Code + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		s.SignInSecondFactor(s.e.NewContext(req, rec))
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, tc.Name)
+	}
+}
@@ -3,8 +3,11 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"net/mail"
+	"time"
 
 	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/audit"
 	"github.com/arimatakao/deepenc/server/database"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -15,6 +18,17 @@ func EmptyHandler(c echo.Context) error {
 	return c.String(http.StatusOK, "empty handler")
 }
 
+const (
+	MIN_USER_PASSWORD_SIZE = 8
+	MAX_USER_PASSWORD_SIZE = 72
+
+	INVALID_USER_PASSWORD_TEXT = "password should contain from 8 to 72 symbols"
+)
+
+func isValidUserPassword(password string) bool {
+	return len(password) >= MIN_USER_PASSWORD_SIZE && len(password) <= MAX_USER_PASSWORD_SIZE
+}
+
 type systemMessage struct {
 	Message string `json:"message"`
 }
@@ -32,21 +46,31 @@ func (s *Server) SignUp(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	if u.Username == "" || u.Password == "" {
+	if u.Username == "" || u.Password == "" || u.Email == "" {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	_, err := s.db.GetUser(u.Username)
-	if err != mongo.ErrNoDocuments {
+	email, err := mail.ParseAddress(u.Email)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp("email is not valid"))
+	}
+
+	event := audit.Event{
+		Type:    AUDIT_SIGNUP,
+		Details: map[string]string{"username": u.Username},
+	}
+
+	_, err = s.db.GetUser(u.Username)
+	if err == nil {
+		s.auditFailure(c, event, "user is already exist")
 		return c.JSON(http.StatusConflict, resp("user is already exist"))
-	} else if err != nil && err != mongo.ErrNoDocuments {
+	} else if err != mongo.ErrNoDocuments {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	if len(u.Password) < 8 && len(u.Password) > 33 {
-		return c.JSON(http.StatusBadRequest,
-			resp("password should contain more than 7 symbols"))
+	if !isValidUserPassword(u.Password) {
+		return c.JSON(http.StatusBadRequest, resp(INVALID_USER_PASSWORD_TEXT))
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
@@ -55,14 +79,32 @@ func (s *Server) SignUp(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	token, err := s.cachedb.AddUser(u.Username, string(hashedPassword))
+	token, err := s.cachedb.AddUser(u.Username, email.Address, string(hashedPassword),
+		config.VerificationTTL)
+	if err == database.ErrAlreadyExist {
+		s.auditFailure(c, event, "user is already waiting for verification")
+		return c.JSON(http.StatusConflict, resp("user is already waiting for verification"))
+	}
 	if err != nil {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	messageText := fmt.Sprintf("confirm your username by route - /api/verify/%s", token)
-	return c.JSON(http.StatusOK, resp(messageText))
+	text := fmt.Sprintf("Hello, %s!\n\nConfirm your deepenc account by following the link "+
+		"below. The link expires in %s.\n\n%s/api/verify/%s\n",
+		u.Username, config.VerificationTTL, config.BaseURL, token)
+	if err = s.mailer.Send(email.Address, "deepenc: confirm your account", text); err != nil {
+		c.Logger().Error(err)
+		// The username is released, so the user can sign up again.
+		if err = s.cachedb.DeletePendingUser(token, u.Username); err != nil {
+			c.Logger().Error(err)
+		}
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	s.recordAudit(c, event)
+
+	return c.JSON(http.StatusOK, resp("verification link is sent to "+email.Address))
 }
 
 func (s *Server) VerifySignUp(c echo.Context) error {
@@ -72,16 +114,30 @@ func (s *Server) VerifySignUp(c echo.Context) error {
 	}
 
 	u, err := s.cachedb.GetUser(confirmToken)
+	// Unknown tokens are not audited, anyone can send them without limit
+	// and they are not related to any account.
+	if err == database.ErrTokenNotFound {
+		return c.String(http.StatusNotFound, "")
+	}
 	if err != nil {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
 	}
 
-	if err = s.db.AddUser(u); err != nil {
+	err = s.db.AddUser(u)
+	if err == database.ErrAlreadyExist {
+		return c.JSON(http.StatusConflict, resp("user is already exist"))
+	}
+	if err != nil {
 		c.Logger().Error(err)
-		c.String(http.StatusInternalServerError, "")
+		return c.String(http.StatusInternalServerError, "")
 	}
 
+	s.recordAudit(c, audit.Event{
+		Type:    AUDIT_VERIFY,
+		Details: map[string]string{"username": u.Username},
+	})
+
 	return c.String(http.StatusCreated, "")
 }
 
@@ -97,19 +153,113 @@ func (s *Server) SignIn(c echo.Context) error {
 	}
 
 	userDocument, err := s.db.GetUser(u.Username)
+	// Unknown usernames are not audited for the same reason as unknown
+	// verification tokens.
 	if err == mongo.ErrNoDocuments {
 		return c.JSON(http.StatusNotFound, "")
 	} else if err != nil {
 		c.Logger().Error(err)
-		c.String(http.StatusInternalServerError, "")
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	attempts, err := s.cachedb.GetFailedLogins(userDocument.Username)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if attempts >= config.LoginMaxAttempts {
+		s.auditFailure(c, signInEvent(userDocument), "account is locked")
+		return c.JSON(http.StatusTooManyRequests,
+			resp("account is temporarily locked, try again later"))
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(userDocument.Password), []byte(u.Password))
 	if err != nil {
-		return c.String(http.StatusBadRequest, "")
+		return s.failSignIn(c, userDocument)
+	}
+
+	if userDocument.TOTPEnabled {
+		challenge, err := s.cachedb.AddLoginChallenge(userDocument.Id.Hex(), LOGIN_CHALLENGE_TTL)
+		if err != nil {
+			c.Logger().Error(err)
+			return c.String(http.StatusInternalServerError, "")
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"challenge": challenge,
+		})
 	}
 
-	token, err := newJWT(userDocument.Id.Hex(), config.JWTSecret)
+	return s.completeSignIn(c, userDocument)
+}
+
+// signInEvent is audit event of sign in to the account.
+func signInEvent(u database.UserOut) audit.Event {
+	return audit.Event{
+		Type:     AUDIT_SIGNIN,
+		ActorId:  u.Id.Hex(),
+		TargetId: u.Id.Hex(),
+		Details:  map[string]string{"username": u.Username},
+	}
+}
+
+func (s *Server) failSignIn(c echo.Context, u database.UserOut) error {
+	s.auditFailure(c, signInEvent(u), "wrong credentials")
+
+	attempts, err := s.cachedb.AddFailedLogin(u.Username, config.LoginLockoutTime)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if attempts == config.LoginMaxAttempts {
+		text := fmt.Sprintf("%d failed sign in attempts, the last one from %s. "+
+			"Account is locked for %s.", attempts, c.RealIP(), config.LoginLockoutTime)
+		if err = s.notifier.Notify(u, "account locked", text); err != nil {
+			c.Logger().Warn(err)
+		}
+	}
+
+	return c.String(http.StatusBadRequest, "")
+}
+
+func (s *Server) completeSignIn(c echo.Context, u database.UserOut) error {
+	if u.Disabled {
+		s.auditFailure(c, signInEvent(u), "account is disabled")
+		return c.JSON(http.StatusForbidden, resp(ACCOUNT_DISABLED_TEXT))
+	}
+
+	if err := s.cachedb.ResetFailedLogins(u.Username); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	login := database.LoginInfo{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+		At:        time.Now().UTC(),
+	}
+
+	if isNewDevice(u.LastLogin, login) {
+		text := fmt.Sprintf("new sign in from %s (%s)", login.IP, login.UserAgent)
+		if err := s.notifier.Notify(u, "sign in from a new device", text); err != nil {
+			c.Logger().Warn(err)
+		}
+	}
+
+	if err := s.db.UpdateLastLogin(u.Id.Hex(), login); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if err := s.publishUserEvent(u.Id.Hex(), EVENT_ACCOUNT_LOGIN, login); err != nil {
+		c.Logger().Warn(err)
+	}
+
+	s.recordAudit(c, signInEvent(u))
+
+	token, err := newJWT(u.Id.Hex(), userRole(u), s.jwtKeys)
 	if err != nil {
 		c.Logger().Error(err)
 		return c.String(http.StatusInternalServerError, "")
@@ -119,3 +269,13 @@ func (s *Server) SignIn(c echo.Context) error {
 		"token": token,
 	})
 }
+
+// isNewDevice reports whether the current sign in comes from a different
+// IP or user agent than the last successful one. The very first sign in
+// is not treated as a new device.
+func isNewDevice(last, current database.LoginInfo) bool {
+	if last.At.IsZero() {
+		return false
+	}
+	return last.IP != current.IP || last.UserAgent != current.UserAgent
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setLoginLimits sets lockout config for the test.
+func setLoginLimits(t *testing.T, attempts int64, lockout time.Duration) {
+	prevAttempts, prevLockout := config.LoginMaxAttempts, config.LoginLockoutTime
+	config.LoginMaxAttempts, config.LoginLockoutTime = attempts, lockout
+	t.Cleanup(func() {
+		config.LoginMaxAttempts, config.LoginLockoutTime = prevAttempts, prevLockout
+	})
+}
+
+func signInRequest(s *Server, username, password string) *httptest.ResponseRecorder {
+	body := `{"username":"` + username + `","password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/signin", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = "203.0.113.7:52000"
+	rec := httptest.NewRecorder()
+
+	c := s.e.NewContext(req, rec)
+	s.SignIn(c)
+	return rec
+}
+
+type TestCaseSignInAttempt struct {
+	Password              string
+	ExpectedStatus        int
+	ExpectedFailedLogins  int64
+	ExpectedNotifications []string
+}
+
+func TestSignInLockout(t *testing.T) {
+	setLoginLimits(t, 3, time.Minute)
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	u := database.UserOut{
+		Id:       primitive.NewObjectID(),
+		Username: "alice",
+		Password: string(hashed),
+	}
+	cache := newFakeCacher()
+	s, notifier := newTestServer(t, newFakeStorager(u), cache)
+
+	// Attempts are made one after another by the same user.
+	cases := []TestCaseSignInAttempt{
+		{"wrong password", http.StatusBadRequest, 1, nil},
+		{"right password", http.StatusOK, 0, nil},
+		{"wrong password", http.StatusBadRequest, 1, nil},
+		{"wrong password", http.StatusBadRequest, 2, nil},
+		{"wrong password", http.StatusBadRequest, 3, []string{"account locked"}},
+		{"wrong password", http.StatusTooManyRequests, 3, []string{"account locked"}},
+		{"right password", http.StatusTooManyRequests, 3, []string{"account locked"}},
+	}
+
+	for i, tc := range cases {
+		rec := signInRequest(s, u.Username, tc.Password)
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, i)
+		assert.Equal(t, tc.ExpectedFailedLogins, cache.failedLogins[u.Username], i)
+
+		var subjects []string
+		for _, n := range notifier.sent {
+			assert.Equal(t, u.Username, n.Username, i)
+			subjects = append(subjects, n.Subject)
+		}
+		assert.Equal(t, tc.ExpectedNotifications, subjects, i)
+	}
+
+	// Lockout is over.
+	delete(cache.failedLogins, u.Username)
+	rec := signInRequest(s, u.Username, "right password")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSignInNewDeviceNotification(t *testing.T) {
+	setLoginLimits(t, 3, time.Minute)
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	u := database.UserOut{
+		Id:       primitive.NewObjectID(),
+		Username: "alice",
+		Password: string(hashed),
+	}
+	db := newFakeStorager(u)
+	s, notifier := newTestServer(t, db, newFakeCacher())
+
+	// The first sign in is not a new device.
+	assert.Equal(t, http.StatusOK, signInRequest(s, u.Username, "right password").Code)
+	assert.Empty(t, notifier.sent)
+	assert.Equal(t, "203.0.113.7", db.users[u.Id.Hex()].LastLogin.IP)
+
+	assert.Equal(t, http.StatusOK, signInRequest(s, u.Username, "right password").Code)
+	assert.Empty(t, notifier.sent)
+
+	last := db.users[u.Id.Hex()]
+	last.LastLogin.IP = "198.51.100.1"
+	db.users[u.Id.Hex()] = last
+
+	assert.Equal(t, http.StatusOK, signInRequest(s, u.Username, "right password").Code)
+	assert.Equal(t, []notification{{"alice", "sign in from a new device"}}, notifier.sent)
+}
+
+type TestCaseVerifySignUp struct {
+	Name           string
+	Token          string
+	ExpectedStatus int
+}
+
+func TestVerifySignUp(t *testing.T) {
+	cache := newFakeCacher()
+	cache.signups["first"] = &database.User{Username: "alice", Password: "hashed"}
+	cache.signups["second"] = &database.User{Username: "alice", Password: "hashed"}
+	db := newFakeStorager()
+	s, _ := newTestServer(t, db, cache)
+
+	cases := []TestCaseVerifySignUp{
+		{"new user", "first", http.StatusCreated},
+		{"used token", "first", http.StatusNotFound},
+		{"unknown token", "unknown", http.StatusNotFound},
+		{"username is taken", "second", http.StatusConflict},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := s.e.NewContext(req, rec)
+		c.SetParamNames("token")
+		c.SetParamValues(tc.Token)
+
+		s.VerifySignUp(c)
+		assert.Equal(t, tc.ExpectedStatus, rec.Code, tc.Name)
+	}
+	assert.Len(t, db.users, 1)
+
+	// Only the verified user is audited, unknown tokens are not.
+	_, total, err := s.auditor.Query(audit.Filter{Type: AUDIT_VERIFY}, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestSignInUnknownUserIsNotAudited(t *testing.T) {
+	s, _ := newTestServer(t, newFakeStorager(), newFakeCacher())
+
+	rec := signInRequest(s, "nobody", "password")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	_, total, err := s.auditor.Query(audit.Filter{}, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), total)
+}
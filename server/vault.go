@@ -0,0 +1,386 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/vault"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// VAULT_CONFLICT_NEW imports message with a new id if its id is taken.
+	VAULT_CONFLICT_NEW = "new"
+	// VAULT_CONFLICT_SKIP doesn't import message if its id is taken.
+	VAULT_CONFLICT_SKIP = "skip"
+	// VAULT_CONFLICT_OVERWRITE replaces own message with the same id, message
+	// of other user is imported with a new id.
+	VAULT_CONFLICT_OVERWRITE = "overwrite"
+
+	VAULT_STATUS_CREATED     = "created"
+	VAULT_STATUS_RENAMED     = "renamed"
+	VAULT_STATUS_OVERWRITTEN = "overwritten"
+	VAULT_STATUS_SKIPPED     = "skipped"
+	VAULT_STATUS_EXPIRED     = "expired"
+	VAULT_STATUS_INVALID     = "invalid"
+
+	MAX_VAULT_SIZE = 32 << 20
+
+	// AES_GCM_OVERHEAD is nonce and tag size added to encrypted content.
+	AES_GCM_OVERHEAD = 12 + 16
+)
+
+type VaultImportResult struct {
+	SourceId string `json:"source_id"`
+	Id       string `json:"id,omitempty"`
+	Status   string `json:"status"`
+}
+
+func isValidConflictMode(mode string) bool {
+	switch mode {
+	case VAULT_CONFLICT_NEW, VAULT_CONFLICT_SKIP, VAULT_CONFLICT_OVERWRITE:
+		return true
+	}
+	return false
+}
+
+// VaultEntries returns all not expired messages of the owner prepared for
+// the vault archive.
+func VaultEntries(db database.Storager, ownerId string) ([]vault.Entry, error) {
+	messages, err := db.GetUserMessages(ownerId, database.MessageFilter{})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	entries := make([]vault.Entry, 0, len(messages))
+	for _, msg := range messages {
+		if isExpired(msg) {
+			continue
+		}
+
+		if err = openTitle(&msg); err != nil {
+			return nil, err
+		}
+
+		content := msg.Content
+		if msg.EncodingType == "internal" {
+			content, err = utils.DecryptAES256(config.AESInternalKey, msg.Content)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, vault.Entry{
+			Id:             msg.Id.Hex(),
+			EncodingType:   msg.EncodingType,
+			IsPrivate:      msg.IsPrivate,
+			OnlyOwnerView:  msg.OnlyOwnerView,
+			IsAnon:         msg.IsAnon,
+			IsOneTime:      msg.IsOneTime,
+			Title:          msg.Title,
+			TitleEncrypted: msg.TitleEncrypted,
+			Tags:           msg.Tags,
+			CollectionId:   msg.CollectionId,
+			CreatedAt:      msg.CreatedAt,
+			UpdatedAt:      msg.UpdatedAt,
+			ExpiresAt:      msg.ExpiresAt,
+			Secret: vault.Secret{
+				Content:  content,
+				Password: msg.Password,
+			},
+		})
+	}
+
+	return entries, nil
+}
+
+// vaultMessage converts vault entry into message of the owner, content of
+// internal messages is encrypted with the key of this instance.
+func vaultMessage(db database.Storager, ownerId string, e vault.Entry) (*database.Message, error) {
+	content := e.Secret.Content
+	if e.EncodingType == "internal" {
+		encrypted, err := utils.EncryptAES256(config.AESInternalKey, content)
+		if err != nil {
+			return nil, err
+		}
+		content = encrypted
+	}
+
+	title, titleEncrypted, err := sealTitle(e.Title, e.TitleEncrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, _ := normalizeTags(e.Tags)
+
+	// Collections are not moved with messages, the message goes to the top
+	// level if the owner doesn't have the same collection.
+	collectionId := e.CollectionId
+	if _, err := checkCollection(db, ownerId, collectionId); err != nil {
+		collectionId = ""
+	}
+
+	now := time.Now().UTC()
+	createdAt, updatedAt := e.CreatedAt, e.UpdatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	if updatedAt.IsZero() {
+		updatedAt = now
+	}
+
+	id, _ := primitive.ObjectIDFromHex(e.Id)
+
+	return &database.Message{
+		Id:             id,
+		OwnerId:        ownerId,
+		Content:        content,
+		IsPrivate:      e.IsPrivate,
+		EncodingType:   e.EncodingType,
+		Password:       e.Secret.Password,
+		OnlyOwnerView:  e.OnlyOwnerView,
+		IsAnon:         e.IsAnon,
+		IsOneTime:      e.IsOneTime,
+		Title:          title,
+		TitleEncrypted: titleEncrypted,
+		Tags:           tags,
+		CollectionId:   collectionId,
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+		ExpiresAt:      e.ExpiresAt,
+	}, nil
+}
+
+// aesContentSize returns size of stored aes content encrypted from content
+// of the given size.
+func aesContentSize(size int) int {
+	return base64.StdEncoding.EncodedLen(AES_GCM_OVERHEAD + size)
+}
+
+// isValidVaultEntry applies limits of created messages. Content of aes
+// messages is kept encrypted, so its size is checked in encrypted form.
+func isValidVaultEntry(e vault.Entry) bool {
+	size := len(e.Secret.Content)
+	if size == 0 || len(e.Title) > MAX_TITLE_SIZE {
+		return false
+	}
+
+	switch e.EncodingType {
+	case "plaintext":
+		if size > MAX_CONTENT_SIZE {
+			return false
+		}
+	case "internal":
+		if size < MIN_CONTENT_SIZE || size > MAX_CONTENT_SIZE {
+			return false
+		}
+	case "aes":
+		if size < aesContentSize(MIN_CONTENT_SIZE) || size > aesContentSize(MAX_CONTENT_SIZE) {
+			return false
+		}
+	case "password":
+		if e.Secret.Password == "" || size > MAX_CONTENT_SIZE {
+			return false
+		}
+	default:
+		return false
+	}
+
+	_, ok := normalizeTags(e.Tags)
+	return ok
+}
+
+// ImportVaultEntries recreates messages from the vault for the owner. Ids
+// of messages are kept when possible, mode says what to do when the id is
+// already taken.
+func ImportVaultEntries(db database.Storager, ownerId string, entries []vault.Entry,
+	mode string) ([]VaultImportResult, error) {
+	if !isValidConflictMode(mode) {
+		return nil, fmt.Errorf("unknown conflict mode %q", mode)
+	}
+
+	results := make([]VaultImportResult, 0, len(entries))
+	now := time.Now()
+
+	for _, e := range entries {
+		result := VaultImportResult{SourceId: e.Id}
+
+		if e.ExpiresAt != nil && !e.ExpiresAt.After(now) {
+			result.Status = VAULT_STATUS_EXPIRED
+			results = append(results, result)
+			continue
+		}
+
+		if !isValidVaultEntry(e) {
+			result.Status = VAULT_STATUS_INVALID
+			results = append(results, result)
+			continue
+		}
+
+		m, err := vaultMessage(db, ownerId, e)
+		if err != nil {
+			return results, err
+		}
+
+		result.Status = VAULT_STATUS_CREATED
+		id, err := db.AddMessage(m)
+		if err == database.ErrAlreadyExist {
+			id, result.Status, err = resolveVaultConflict(db, ownerId, m, mode)
+		}
+		if err != nil {
+			return results, err
+		}
+
+		result.Id = id
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func resolveVaultConflict(db database.Storager, ownerId string, m *database.Message,
+	mode string) (string, string, error) {
+	if mode == VAULT_CONFLICT_SKIP {
+		return "", VAULT_STATUS_SKIPPED, nil
+	}
+
+	if mode == VAULT_CONFLICT_OVERWRITE {
+		existing, err := db.GetMessage(m.Id.Hex())
+		if err != nil && err != mongo.ErrNoDocuments {
+			return "", "", err
+		}
+
+		if err == nil && existing.OwnerId == ownerId {
+			id := m.Id.Hex()
+			m.Id = primitive.NilObjectID
+			err = db.UpdateMessage(id, existing.Version, m)
+			return id, VAULT_STATUS_OVERWRITTEN, err
+		}
+	}
+
+	m.Id = primitive.NilObjectID
+	id, err := db.AddMessage(m)
+	return id, VAULT_STATUS_RENAMED, err
+}
+
+type InputVaultExport struct {
+	Passphrase string `json:"passphrase"`
+}
+
+func (s *Server) ExportVault(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputVaultExport)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if len(input.Passphrase) < vault.MIN_PASSPHRASE_SIZE {
+		return c.JSON(http.StatusBadRequest, resp(vault.ErrShortPassphrase.Error()))
+	}
+
+	entries, err := VaultEntries(s.db, userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	buf := new(bytes.Buffer)
+	if err = vault.Write(buf, input.Passphrase, entries); err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	s.recordAudit(c, exportEvent(userId, "vault", len(entries)))
+
+	filename := fmt.Sprintf("deepenc-vault-%s.zip", time.Now().UTC().Format("20060102"))
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=%q", filename))
+
+	return c.Blob(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// ImportVault reads multipart form with vault file, passphrase and conflict
+// mode fields.
+func (s *Server) ImportVault(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	mode := c.FormValue("conflict")
+	if mode == "" {
+		mode = VAULT_CONFLICT_NEW
+	}
+	if !isValidConflictMode(mode) {
+		return c.JSON(http.StatusBadRequest, resp("conflict should be new, skip or overwrite"))
+	}
+
+	file, err := c.FormFile("vault")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, resp("vault file is required"))
+	}
+	if file.Size > MAX_VAULT_SIZE {
+		return c.JSON(http.StatusRequestEntityTooLarge, resp("vault file is too large"))
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, MAX_VAULT_SIZE))
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	entries, err := vault.Read(bytes.NewReader(data), int64(len(data)), c.FormValue("passphrase"))
+	if err == vault.ErrWrongPassphrase || err == vault.ErrBadArchive {
+		return c.JSON(http.StatusBadRequest, resp(err.Error()))
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	results, err := ImportVaultEntries(s.db, userId, entries, mode)
+	for _, r := range results {
+		eventType := AUDIT_MESSAGE_CREATE
+		switch r.Status {
+		case VAULT_STATUS_OVERWRITTEN:
+			eventType = AUDIT_MESSAGE_UPDATE
+		case VAULT_STATUS_CREATED, VAULT_STATUS_RENAMED:
+		default:
+			continue
+		}
+		s.recordAudit(c, audit.Event{
+			Type:     eventType,
+			TargetId: r.Id,
+			Details:  map[string]string{"owner_id": userId, "source": "vault"},
+		})
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"results": results,
+	})
+}
@@ -0,0 +1,335 @@
+// Package vault reads and writes portable archives with user messages. The
+// archive is a zip with a plain header, an encrypted manifest of message
+// metadata and an encrypted blob with secret fields of every message. All
+// encrypted parts use a key derived from the passphrase with argon2id.
+package vault
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/arimatakao/deepenc/utils"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	FORMAT  = "deepenc-vault"
+	VERSION = 1
+
+	MIN_PASSPHRASE_SIZE = 12
+
+	KDF_ARGON2ID = "argon2id"
+
+	// Argon2id parameters of every archive. The header is not authenticated,
+	// so other values are rejected instead of being trusted.
+	KDF_TIME      = 3
+	KDF_MEMORY    = 64 * 1024
+	KDF_THREADS   = 4
+	KDF_SALT_SIZE = 16
+
+	HEADER_FILE   = "vault.json"
+	MANIFEST_FILE = "manifest.enc"
+	BLOBS_DIR     = "blobs/"
+
+	// Upper bound of an encrypted part, protects reader from zip bombs.
+	MAX_PART_SIZE = 16 << 20
+	// Upper bounds of one secret blob and all of them together. Secrets of
+	// messages are a few kilobytes at most.
+	MAX_BLOB_SIZE    = 16 << 10
+	MAX_SECRETS_SIZE = 32 << 20
+
+	MAX_ENTRIES = 10000
+)
+
+var (
+	ErrWrongPassphrase = errors.New("vault passphrase is wrong")
+	ErrShortPassphrase = fmt.Errorf("vault passphrase is shorter than %d symbols",
+		MIN_PASSPHRASE_SIZE)
+	ErrBadArchive = errors.New("file is not a deepenc vault")
+)
+
+// Entry is a message in the vault. Secret fields are kept in a separate
+// blob and are not part of the manifest.
+type Entry struct {
+	Id            string `json:"id"`
+	EncodingType  string `json:"encoding_type"`
+	IsPrivate     bool   `json:"is_private"`
+	OnlyOwnerView bool   `json:"only_owner_view"`
+	IsAnon        bool   `json:"is_anon"`
+	IsOneTime     bool   `json:"is_one_time"`
+	Title         string `json:"title,omitempty"`
+	// TitleEncrypted asks to encrypt title on import, the title itself is
+	// stored decrypted.
+	TitleEncrypted bool       `json:"title_encrypted,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	CollectionId   string     `json:"collection_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+
+	Secret Secret `json:"-"`
+}
+
+// Secret holds message content and password hash in the form they are
+// stored in, except internal encoding which is decrypted because other
+// instance has another internal key.
+type Secret struct {
+	Content  string `json:"content"`
+	Password string `json:"password,omitempty"`
+}
+
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+type header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	KDF       kdfParams `json:"kdf"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// manifestEntry points to the blob of the entry. Blobs are encrypted
+// separately, so the hash binds the blob to its entry and blobs can't be
+// swapped between entries.
+type manifestEntry struct {
+	Entry
+	Blob     string `json:"blob"`
+	BlobHash string `json:"blob_hash"`
+}
+
+func blobHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newKDFParams() (kdfParams, error) {
+	salt := make([]byte, KDF_SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return kdfParams{}, err
+	}
+
+	return kdfParams{
+		Name:    KDF_ARGON2ID,
+		Salt:    salt,
+		Time:    KDF_TIME,
+		Memory:  KDF_MEMORY,
+		Threads: KDF_THREADS,
+	}, nil
+}
+
+func (p kdfParams) deriveKey(passphrase string) ([]byte, error) {
+	if p.Name != KDF_ARGON2ID || len(p.Salt) != KDF_SALT_SIZE || p.Time != KDF_TIME ||
+		p.Memory != KDF_MEMORY || p.Threads != KDF_THREADS {
+		return nil, ErrBadArchive
+	}
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, 32), nil
+}
+
+// Write stores entries into encrypted archive.
+func Write(w io.Writer, passphrase string, entries []Entry) error {
+	if len(passphrase) < MIN_PASSPHRASE_SIZE {
+		return ErrShortPassphrase
+	}
+
+	params, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+
+	key, err := params.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+
+	err = writeJSON(archive, HEADER_FILE, header{
+		Format:    FORMAT,
+		Version:   VERSION,
+		KDF:       params,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest := make([]manifestEntry, 0, len(entries))
+	for i, e := range entries {
+		blob := fmt.Sprintf("%s%d.enc", BLOBS_DIR, i)
+		data, err := writeEncrypted(archive, blob, key, e.Secret)
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, manifestEntry{Entry: e, Blob: blob, BlobHash: blobHash(data)})
+	}
+
+	if _, err = writeEncrypted(archive, MANIFEST_FILE, key, manifest); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// Read opens encrypted archive and returns its entries with secrets.
+func Read(r io.ReaderAt, size int64, passphrase string) ([]Entry, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrBadArchive
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	h := header{}
+	if err = readJSON(files[HEADER_FILE], MAX_BLOB_SIZE, &h); err != nil {
+		return nil, ErrBadArchive
+	}
+	if h.Format != FORMAT || h.Version != VERSION {
+		return nil, ErrBadArchive
+	}
+
+	key, err := h.KDF.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := make([]manifestEntry, 0)
+	err = readEncrypted(files[MANIFEST_FILE], MAX_PART_SIZE, key, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest) > MAX_ENTRIES {
+		return nil, ErrBadArchive
+	}
+
+	// Every entry has its own blob, otherwise one blob is read again and
+	// again for every entry pointing to it.
+	seen := make(map[string]bool, len(manifest))
+	var total uint64
+
+	entries := make([]Entry, 0, len(manifest))
+	for _, m := range manifest {
+		f := files[m.Blob]
+		if f == nil || !strings.HasPrefix(m.Blob, BLOBS_DIR) || seen[m.Blob] {
+			return nil, ErrBadArchive
+		}
+		seen[m.Blob] = true
+
+		total += f.UncompressedSize64
+		if total > MAX_SECRETS_SIZE {
+			return nil, ErrBadArchive
+		}
+
+		data, err := readPart(f, MAX_BLOB_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare([]byte(blobHash(data)), []byte(m.BlobHash)) != 1 {
+			return nil, ErrBadArchive
+		}
+
+		e := m.Entry
+		if err = decryptJSON(data, key, &e.Secret); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func writeJSON(archive *zip.Writer, name string, v interface{}) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(f).Encode(v)
+}
+
+// writeEncrypted stores encrypted v and returns the stored data.
+func writeEncrypted(archive *zip.Writer, name string, key []byte, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := utils.EncryptAES256(key, string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := archive.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.WriteString(f, encrypted); err != nil {
+		return nil, err
+	}
+	return []byte(encrypted), nil
+}
+
+// readPart reads the file which is not bigger than limit. Size in zip header
+// can't be trusted, so the content is limited too.
+func readPart(f *zip.File, limit int64) ([]byte, error) {
+	if f == nil || f.UncompressedSize64 > uint64(limit) {
+		return nil, ErrBadArchive
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, ErrBadArchive
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil || int64(len(data)) > limit {
+		return nil, ErrBadArchive
+	}
+
+	return data, nil
+}
+
+func readJSON(f *zip.File, limit int64, v interface{}) error {
+	data, err := readPart(f, limit)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func readEncrypted(f *zip.File, limit int64, key []byte, v interface{}) error {
+	data, err := readPart(f, limit)
+	if err != nil {
+		return err
+	}
+	return decryptJSON(data, key, v)
+}
+
+func decryptJSON(data []byte, key []byte, v interface{}) error {
+	decrypted, err := utils.DecryptAES256(key, string(data))
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+
+	if err = json.Unmarshal([]byte(decrypted), v); err != nil {
+		return ErrBadArchive
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package vault
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPassphrase = "correct horse battery"
+
+func testEntries() []Entry {
+	created := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	expires := created.Add(time.Hour)
+	return []Entry{
+		{
+			Id:           "65f1c0a0e4b0a1b2c3d4e5f6",
+			EncodingType: "plaintext",
+			Title:        "staging db",
+			Tags:         []string{"db", "staging"},
+			CreatedAt:    created,
+			ExpiresAt:    &expires,
+			Secret:       Secret{Content: "postgres://user:pass@db"},
+		},
+		{
+			Id:           "65f1c0a0e4b0a1b2c3d4e5f7",
+			EncodingType: "password",
+			IsPrivate:    true,
+			Secret:       Secret{Content: "root password", Password: "$2a$10$hash"},
+		},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, testPassphrase, testEntries()))
+
+	entries, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()), testPassphrase)
+	assert.NoError(t, err)
+	assert.Equal(t, testEntries(), entries)
+}
+
+func TestSecretsAreEncrypted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, testPassphrase, testEntries()))
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	for _, f := range archive.File {
+		data, err := readPart(f, MAX_PART_SIZE)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(data), "postgres://")
+		assert.NotContains(t, string(data), "staging db")
+	}
+}
+
+func TestReadWrongPassphrase(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, testPassphrase, testEntries()))
+
+	_, err := Read(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "wrong passphrase")
+	assert.Equal(t, ErrWrongPassphrase, err)
+}
+
+func TestWriteShortPassphrase(t *testing.T) {
+	assert.Equal(t, ErrShortPassphrase, Write(new(bytes.Buffer), "short", nil))
+}
+
+func TestReadBadArchive(t *testing.T) {
+	data := []byte("not a zip")
+	_, err := Read(bytes.NewReader(data), int64(len(data)), testPassphrase)
+	assert.Equal(t, ErrBadArchive, err)
+}
+
+func TestDeriveKeyRejectsOtherParams(t *testing.T) {
+	params, err := newKDFParams()
+	assert.NoError(t, err)
+
+	_, err = params.deriveKey(testPassphrase)
+	assert.NoError(t, err)
+
+	huge := params
+	huge.Memory = 4294967295
+	_, err = huge.deriveKey(testPassphrase)
+	assert.Equal(t, ErrBadArchive, err)
+
+	slow := params
+	slow.Time = 1000
+	_, err = slow.deriveKey(testPassphrase)
+	assert.Equal(t, ErrBadArchive, err)
+}
+
+// writeManifest makes archive with the manifest and blobs as they are.
+// Entries without blob hash get the hash of their blob.
+func writeManifest(t *testing.T, manifest []manifestEntry, blobs []string) []byte {
+	params, err := newKDFParams()
+	assert.NoError(t, err)
+	key, err := params.deriveKey(testPassphrase)
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	assert.NoError(t, writeJSON(archive, HEADER_FILE, header{Format: FORMAT, Version: VERSION,
+		KDF: params}))
+	hashes := map[string]string{}
+	for _, blob := range blobs {
+		data, err := writeEncrypted(archive, blob, key, Secret{Content: "content"})
+		assert.NoError(t, err)
+		hashes[blob] = blobHash(data)
+	}
+	for i := range manifest {
+		if manifest[i].BlobHash == "" {
+			manifest[i].BlobHash = hashes[manifest[i].Blob]
+		}
+	}
+	_, err = writeEncrypted(archive, MANIFEST_FILE, key, manifest)
+	assert.NoError(t, err)
+	assert.NoError(t, archive.Close())
+
+	return buf.Bytes()
+}
+
+func TestReadRejectsBadBlobs(t *testing.T) {
+	type TestCaseBlobs struct {
+		Name     string
+		Manifest []manifestEntry
+		Blobs    []string
+	}
+
+	cases := []TestCaseBlobs{
+		{"shared blob", []manifestEntry{{Blob: "blobs/0.enc"}, {Blob: "blobs/0.enc"}},
+			[]string{"blobs/0.enc"}},
+		{"missing blob", []manifestEntry{{Blob: "blobs/1.enc"}}, []string{"blobs/0.enc"}},
+		{"blob outside of blobs", []manifestEntry{{Blob: MANIFEST_FILE}}, nil},
+		{"blob of other entry", []manifestEntry{{Blob: "blobs/0.enc", BlobHash: blobHash(nil)}},
+			[]string{"blobs/0.enc"}},
+		{"too many entries", make([]manifestEntry, MAX_ENTRIES+1), nil},
+	}
+
+	for _, tc := range cases {
+		data := writeManifest(t, tc.Manifest, tc.Blobs)
+		_, err := Read(bytes.NewReader(data), int64(len(data)), testPassphrase)
+		assert.Equal(t, ErrBadArchive, err, tc.Name)
+	}
+
+	data := writeManifest(t, []manifestEntry{{Blob: "blobs/0.enc"}}, []string{"blobs/0.enc"})
+	entries, err := Read(bytes.NewReader(data), int64(len(data)), testPassphrase)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", entries[0].Secret.Content)
+}
+
+func TestReadRejectsSwappedBlobs(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Write(buf, testPassphrase, testEntries()))
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	swapped := new(bytes.Buffer)
+	w := zip.NewWriter(swapped)
+	names := map[string]string{"blobs/0.enc": "blobs/1.enc", "blobs/1.enc": "blobs/0.enc"}
+	for _, f := range archive.File {
+		name := f.Name
+		if other, ok := names[name]; ok {
+			name = other
+		}
+
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		dst, err := w.Create(name)
+		assert.NoError(t, err)
+		_, err = io.Copy(dst, rc)
+		assert.NoError(t, err)
+		rc.Close()
+	}
+	assert.NoError(t, w.Close())
+
+	_, err = Read(bytes.NewReader(swapped.Bytes()), int64(swapped.Len()), testPassphrase)
+	assert.Equal(t, ErrBadArchive, err)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arimatakao/deepenc/server/audit"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/server/vault"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIsValidVaultEntry(t *testing.T) {
+	assert.True(t, isValidVaultEntry(vault.Entry{EncodingType: "plaintext",
+		Secret: vault.Secret{Content: "some content"}}))
+	assert.True(t, isValidVaultEntry(vault.Entry{EncodingType: "password",
+		Secret: vault.Secret{Content: "some content", Password: "$2a$10$hash"}}))
+
+	assert.False(t, isValidVaultEntry(vault.Entry{EncodingType: "plaintext"}))
+	assert.False(t, isValidVaultEntry(vault.Entry{EncodingType: "password",
+		Secret: vault.Secret{Content: "some content"}}))
+	assert.False(t, isValidVaultEntry(vault.Entry{EncodingType: "rot13",
+		Secret: vault.Secret{Content: "some content"}}))
+
+	long := strings.Repeat("a", MAX_CONTENT_SIZE+1)
+	assert.False(t, isValidVaultEntry(vault.Entry{EncodingType: "plaintext",
+		Secret: vault.Secret{Content: long}}))
+	assert.False(t, isValidVaultEntry(vault.Entry{EncodingType: "internal",
+		Secret: vault.Secret{Content: "short"}}))
+
+	for _, size := range []int{MIN_CONTENT_SIZE, MAX_CONTENT_SIZE} {
+		encrypted, err := utils.EncryptAES256([]byte("password"), strings.Repeat("a", size))
+		assert.NoError(t, err)
+		assert.True(t, isValidVaultEntry(vault.Entry{EncodingType: "aes",
+			Secret: vault.Secret{Content: encrypted}}))
+	}
+	encrypted, err := utils.EncryptAES256([]byte("password"), long)
+	assert.NoError(t, err)
+	assert.False(t, isValidVaultEntry(vault.Entry{EncodingType: "aes",
+		Secret: vault.Secret{Content: encrypted}}))
+}
+
+func TestImportVaultEntriesUnknownMode(t *testing.T) {
+	_, err := ImportVaultEntries(nil, "owner", nil, "merge")
+	assert.Error(t, err)
+}
+
+type TestCaseExportAudit struct {
+	Name           string
+	Export         func(s *Server, c echo.Context) error
+	Body           string
+	ExpectedFormat string
+}
+
+func TestExportIsAudited(t *testing.T) {
+	u := database.UserOut{Id: primitive.NewObjectID(), Username: "alice"}
+	db := newFakeStorager(u)
+	db.addMessages(database.MessageOut{
+		Id:           primitive.NewObjectID(),
+		PublicId:     "public",
+		OwnerId:      u.Id.Hex(),
+		Content:      "note",
+		EncodingType: "plaintext",
+	})
+	s, _ := newTestServer(t, db, newFakeCacher())
+
+	cases := []TestCaseExportAudit{
+		{"account archive", (*Server).ExportAccount, "", "archive"},
+		{"vault", (*Server).ExportVault, `{"passphrase":"long enough passphrase"}`, "vault"},
+	}
+
+	for i, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.Body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := s.e.NewContext(req, rec)
+		authenticate(c, u.Id.Hex(), ROLE_USER)
+
+		assert.Nil(t, tc.Export(s, c), tc.Name)
+		assert.Equal(t, http.StatusOK, rec.Code, tc.Name)
+
+		events, total, err := s.auditor.Query(audit.Filter{Type: AUDIT_ACCOUNT_EXPORT}, 0, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i+1), total, tc.Name)
+		assert.Equal(t, u.Id.Hex(), events[0].ActorId, tc.Name)
+		assert.Equal(t, u.Id.Hex(), events[0].TargetId, tc.Name)
+		assert.Equal(t, tc.ExpectedFormat, events[0].Details["format"], tc.Name)
+		assert.Equal(t, "1", events[0].Details["messages"], tc.Name)
+	}
+}
@@ -0,0 +1,441 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/arimatakao/deepenc/utils"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	WEBHOOK_EVENT_READ           = "message.read"
+	WEBHOOK_EVENT_BURNED         = "message.burned"
+	WEBHOOK_EVENT_EXPIRED        = "message.expired"
+	WEBHOOK_EVENT_DELETED        = "message.deleted"
+	WEBHOOK_EVENT_DECRYPT_FAILED = "message.decrypt_failed"
+
+	WEBHOOK_SECRET_PREFIX    = "whsec_"
+	WEBHOOK_SIGNATURE_HEADER = "X-Deepenc-Signature"
+	WEBHOOK_TIMESTAMP_HEADER = "X-Deepenc-Timestamp"
+	WEBHOOK_EVENT_HEADER     = "X-Deepenc-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-Deepenc-Delivery"
+
+	MAX_WEBHOOKS_AMOUNT  = 10
+	MAX_WEBHOOK_URL_SIZE = 2048
+
+	WEBHOOK_MAX_ATTEMPTS    = 8
+	WEBHOOK_RETRY_DELAY     = 30 * time.Second
+	WEBHOOK_MAX_RETRY_DELAY = 6 * time.Hour
+	WEBHOOK_TIMEOUT         = 10 * time.Second
+	WEBHOOK_BATCH_SIZE      = 50
+	// Claimed job goes back to the queue when it isn't handled in time, it's
+	// longer than delivery of the whole batch.
+	WEBHOOK_CLAIM_TIMEOUT = 15 * time.Minute
+)
+
+var webhookEvents = map[string]bool{
+	WEBHOOK_EVENT_READ:           true,
+	WEBHOOK_EVENT_BURNED:         true,
+	WEBHOOK_EVENT_EXPIRED:        true,
+	WEBHOOK_EVENT_DELETED:        true,
+	WEBHOOK_EVENT_DECRYPT_FAILED: true,
+}
+
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// Shared address space of carrier-grade NAT, not covered by net.IP methods.
+var sharedAddressSpace = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+// isPublicIP reports whether webhooks can be sent to the address. Internal
+// services and cloud metadata endpoints are not reachable by users.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// webhookDialControl checks the address after DNS resolution, so host names
+// resolving to internal addresses are rejected too.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	if config.InsecureWebhooks {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errWebhookAddress
+	}
+
+	return nil
+}
+
+// webhookClient doesn't follow redirects, the receiver must answer on the
+// registered url. Proxy from environment is not used, otherwise addresses
+// are checked for the proxy instead of the receiver.
+var webhookClient = &http.Client{
+	Timeout: WEBHOOK_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: WEBHOOK_TIMEOUT,
+			Control: webhookDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: WEBHOOK_TIMEOUT,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+type InputWebhook struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func isValidWebhookURL(raw string) bool {
+	if raw == "" || len(raw) > MAX_WEBHOOK_URL_SIZE {
+		return false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+
+	if config.InsecureWebhooks {
+		return u.Scheme == "https" || u.Scheme == "http"
+	}
+
+	// Addresses of host names are checked on every delivery.
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !isPublicIP(ip) {
+		return false
+	}
+
+	return u.Scheme == "https" && u.Hostname() != "localhost"
+}
+
+type eventMessage struct {
+	MessageId string `json:"message_id"`
+	PublicId  string `json:"public_id"`
+}
+
+type webhookPayload struct {
+	Id        string       `json:"id"`
+	Event     string       `json:"event"`
+	CreatedAt time.Time    `json:"created_at"`
+	Data      eventMessage `json:"data"`
+}
+
+// signWebhook returns hex encoded HMAC-SHA256 of the timestamp and the body
+// joined by dot. The timestamp is signed too, so receivers can reject
+// replayed requests.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay returns delay before the next attempt, it doubles after
+// every failed attempt.
+func webhookRetryDelay(attempt int) time.Duration {
+	delay := WEBHOOK_RETRY_DELAY
+	for i := 1; i < attempt && delay < WEBHOOK_MAX_RETRY_DELAY; i++ {
+		delay *= 2
+	}
+	return min(delay, WEBHOOK_MAX_RETRY_DELAY)
+}
+
+// emitMessageEvent pushes the event to connected clients of the message
+// owner and queues it for every webhook subscribed to it.
+func (s *Server) emitMessageEvent(event string, msg database.MessageOut) error {
+	data := eventMessage{
+		MessageId: msg.Id.Hex(),
+		PublicId:  msg.PublicId,
+	}
+
+	if err := s.publishUserEvent(msg.OwnerId, event, data); err != nil {
+		return err
+	}
+
+	webhooks, err := s.db.GetEventWebhooks(msg.OwnerId, event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, w := range webhooks {
+		deliveryId, err := utils.RandomToken(16)
+		if err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(webhookPayload{
+			Id:        deliveryId,
+			Event:     event,
+			CreatedAt: now,
+			Data:      data,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = s.cachedb.EnqueueWebhookJob(database.WebhookJob{
+			DeliveryId: deliveryId,
+			WebhookId:  w.Id.Hex(),
+			OwnerId:    w.OwnerId,
+			Event:      event,
+			Payload:    string(payload),
+		}, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliverWebhooks sends queued events which are due.
+func (s *Server) deliverWebhooks() error {
+	jobs, err := s.cachedb.ClaimWebhookJobs(time.Now(), WEBHOOK_BATCH_SIZE, WEBHOOK_CLAIM_TIMEOUT)
+	for _, j := range jobs {
+		if err := s.deliverWebhook(j); err != nil {
+			s.e.Logger.Error(err)
+		}
+	}
+	return err
+}
+
+// deliverWebhook makes one attempt to deliver the job, records the result in
+// delivery log and puts the job back to the queue if the attempt failed. The
+// claim is acked only after that, the job is claimed again when the attempt
+// is interrupted.
+func (s *Server) deliverWebhook(j database.WebhookJob) error {
+	w, err := s.db.GetWebhook(j.OwnerId, j.WebhookId)
+	if err == mongo.ErrNoDocuments {
+		// Webhook is removed after the event.
+		return s.cachedb.AckWebhookJob(j)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret, err := utils.DecryptAES256(config.AESInternalKey, w.Secret)
+	if err != nil {
+		// Secret can't be decrypted by next attempts either.
+		return errors.Join(err, s.cachedb.AckWebhookJob(j))
+	}
+
+	j.Attempt++
+	delivery := &database.WebhookDelivery{
+		WebhookId:  j.WebhookId,
+		OwnerId:    j.OwnerId,
+		DeliveryId: j.DeliveryId,
+		Event:      j.Event,
+		Attempt:    j.Attempt,
+		At:         time.Now().UTC(),
+	}
+
+	delivery.StatusCode, err = sendWebhook(w.URL, secret, j)
+	delivery.Success = err == nil
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	if err = s.db.AddWebhookDelivery(delivery); err != nil {
+		s.e.Logger.Error(err)
+	}
+
+	if !delivery.Success && j.Attempt < WEBHOOK_MAX_ATTEMPTS {
+		err = s.cachedb.EnqueueWebhookJob(j, time.Now().Add(webhookRetryDelay(j.Attempt)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.cachedb.AckWebhookJob(j)
+}
+
+func sendWebhook(target, secret string, j database.WebhookJob) (int, error) {
+	body := []byte(j.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(WEBHOOK_EVENT_HEADER, j.Event)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, j.DeliveryId)
+	req.Header.Set(WEBHOOK_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+signWebhook(secret, timestamp, body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// CreateWebhook subscribes url to events of user messages. The signing secret
+// is shown only once.
+func (s *Server) CreateWebhook(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	input := new(InputWebhook)
+	if err := c.Bind(input); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	if !isValidWebhookURL(input.URL) || len(input.Events) == 0 {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	for _, event := range input.Events {
+		if !webhookEvents[event] {
+			return c.JSON(http.StatusBadRequest, resp("unknown event "+event))
+		}
+	}
+
+	webhooks, err := s.db.GetWebhooks(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if len(webhooks) >= MAX_WEBHOOKS_AMOUNT {
+		return c.JSON(http.StatusConflict, resp("webhooks limit is reached"))
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	secret := WEBHOOK_SECRET_PREFIX + token
+
+	encrypted, err := utils.EncryptAES256(config.AESInternalKey, secret)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	w := &database.Webhook{
+		OwnerId:   userId,
+		URL:       input.URL,
+		Events:    input.Events,
+		Secret:    encrypted,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	id, err := s.db.AddWebhook(w)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":     id,
+		"url":    w.URL,
+		"events": w.Events,
+		"secret": secret,
+	})
+}
+
+func (s *Server) GetWebhooksList(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	webhooks, err := s.db.GetWebhooks(userId)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// DeleteWebhook removes the webhook with its delivery log, queued events of
+// the webhook are dropped on delivery.
+func (s *Server) DeleteWebhook(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	err = s.db.DeleteWebhook(userId, c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	}
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.String(http.StatusNoContent, "")
+}
+
+func (s *Server) GetWebhookDeliveries(c echo.Context) error {
+	userId, err := getUserIdFromJWT(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	webhookId := c.Param("id")
+	if _, err = s.db.GetWebhook(userId, webhookId); err == mongo.ErrNoDocuments {
+		return c.String(http.StatusNotFound, "")
+	} else if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	skip, limit := parsePagination(c)
+
+	deliveries, total, err := s.db.GetWebhookDeliveries(userId, webhookId, skip, limit)
+	if err != nil {
+		c.Logger().Error(err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"total":      total,
+		"deliveries": deliveries,
+	})
+}
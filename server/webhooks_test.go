@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/arimatakao/deepenc/cmd/config"
+	"github.com/arimatakao/deepenc/server/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"message.read"}`)
+
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, expected, signWebhook("whsec_test", 1700000000, body))
+	assert.NotEqual(t, expected, signWebhook("whsec_test", 1700000001, body))
+	assert.NotEqual(t, expected, signWebhook("whsec_other", 1700000000, body))
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	assert.Equal(t, WEBHOOK_RETRY_DELAY, webhookRetryDelay(1))
+	assert.Equal(t, 2*WEBHOOK_RETRY_DELAY, webhookRetryDelay(2))
+	assert.Equal(t, 8*WEBHOOK_RETRY_DELAY, webhookRetryDelay(4))
+	assert.Equal(t, WEBHOOK_MAX_RETRY_DELAY, webhookRetryDelay(100))
+}
+
+// allowInsecureWebhooks lets the test send webhooks to local http servers.
+func allowInsecureWebhooks(t *testing.T) {
+	insecure := config.InsecureWebhooks
+	config.InsecureWebhooks = true
+	t.Cleanup(func() { config.InsecureWebhooks = insecure })
+}
+
+func TestIsValidWebhookURL(t *testing.T) {
+	assert.True(t, isValidWebhookURL("https://example.com/hooks/deepenc"))
+	assert.True(t, isValidWebhookURL("https://203.0.113.10/hooks"))
+	assert.False(t, isValidWebhookURL("http://example.com/hooks/deepenc"))
+	assert.False(t, isValidWebhookURL("http://localhost:9000"))
+	assert.False(t, isValidWebhookURL("https://localhost:9000"))
+	assert.False(t, isValidWebhookURL("https://127.0.0.1:6379"))
+	assert.False(t, isValidWebhookURL("https://169.254.169.254/latest/meta-data"))
+	assert.False(t, isValidWebhookURL("https://[::1]/hooks"))
+	assert.False(t, isValidWebhookURL(""))
+	assert.False(t, isValidWebhookURL("ftp://example.com"))
+	assert.False(t, isValidWebhookURL("/relative"))
+
+	allowInsecureWebhooks(t)
+	assert.True(t, isValidWebhookURL("http://localhost:9000"))
+	assert.False(t, isValidWebhookURL("ftp://example.com"))
+}
+
+func TestWebhookDialControl(t *testing.T) {
+	public := []string{"93.184.216.34:443", "[2606:2800:220:1::1]:443"}
+	for _, address := range public {
+		assert.Nil(t, webhookDialControl("tcp", address, nil), address)
+	}
+
+	internal := []string{
+		"127.0.0.1:6379",
+		"10.0.0.5:80",
+		"172.16.0.1:80",
+		"192.168.1.1:80",
+		"169.254.169.254:80",
+		"100.64.0.1:80",
+		"0.0.0.0:80",
+		"224.0.0.1:80",
+		"[::1]:443",
+		"[::]:443",
+		"[fe80::1]:443",
+		"[fd00::1]:443",
+		"[::ffff:127.0.0.1]:443",
+	}
+	for _, address := range internal {
+		assert.Equal(t, errWebhookAddress, webhookDialControl("tcp", address, nil), address)
+	}
+}
+
+func TestSendWebhookRejectsInternalAddress(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	_, err := sendWebhook(srv.URL, "secret", database.WebhookJob{Payload: "{}"})
+	assert.ErrorIs(t, err, errWebhookAddress)
+	assert.False(t, called)
+}
+
+func TestSendWebhook(t *testing.T) {
+	allowInsecureWebhooks(t)
+
+	j := database.WebhookJob{
+		DeliveryId: "delivery",
+		Event:      WEBHOOK_EVENT_BURNED,
+		Payload:    `{"event":"message.burned"}`,
+	}
+
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, err := strconv.ParseInt(r.Header.Get(WEBHOOK_TIMESTAMP_HEADER), 10, 64)
+		assert.Nil(t, err)
+		assert.InDelta(t, time.Now().Unix(), timestamp, 5)
+		assert.Equal(t, "sha256="+signWebhook("secret", timestamp, body),
+			r.Header.Get(WEBHOOK_SIGNATURE_HEADER))
+		assert.Equal(t, j.Event, r.Header.Get(WEBHOOK_EVENT_HEADER))
+		assert.Equal(t, j.DeliveryId, r.Header.Get(WEBHOOK_DELIVERY_HEADER))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	code, err := sendWebhook(srv.URL, "secret", j)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	status = http.StatusServiceUnavailable
+	code, err = sendWebhook(srv.URL, "secret", j)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 test secret "12345678901234567890" encoded in base32
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+type TestCaseTOTP struct {
+	Name         string
+	Time         time.Time
+	ExpectedCode string
+}
+
+func TestTOTPCode(t *testing.T) {
+	cases := []TestCaseTOTP{
+		{
+			Name:         "time 59",
+			Time:         time.Unix(59, 0),
+			ExpectedCode: "287082",
+		},
+		{
+			Name:         "time 1111111109",
+			Time:         time.Unix(1111111109, 0),
+			ExpectedCode: "081804",
+		},
+		{
+			Name:         "time 1234567890",
+			Time:         time.Unix(1234567890, 0),
+			ExpectedCode: "005924",
+		},
+		{
+			Name:         "time 2000000000",
+			Time:         time.Unix(2000000000, 0),
+			ExpectedCode: "279037",
+		},
+	}
+
+	for _, testCase := range cases {
+		code, err := TOTPCode(rfcTOTPSecret, testCase.Time)
+
+		assert.Nil(t, err, testCase.Name)
+		assert.Equal(t, testCase.ExpectedCode, code, testCase.Name)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	assert.Nil(t, err)
+
+	assert.True(t, ValidateTOTP(secret, code, now), "current step")
+	assert.True(t, ValidateTOTP(secret, code, now.Add(TOTP_PERIOD*time.Second)),
+		"next step is within skew")
+	assert.False(t, ValidateTOTP(secret, code, now.Add(5*TOTP_PERIOD*time.Second)),
+		"code is too old")
+	assert.False(t, ValidateTOTP(secret, "", now), "empty code")
+	assert.False(t, ValidateTOTP("", code, now), "empty secret")
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	assert.Nil(t, err)
+
+	step, ok := MatchTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/TOTP_PERIOD, step)
+
+	step, ok = MatchTOTP(secret, code, now.Add(TOTP_PERIOD*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/TOTP_PERIOD, step, "step of the code, not of the time")
+
+	_, ok = MatchTOTP(secret, "000000x", now)
+	assert.False(t, ok)
+}
@@ -8,10 +8,14 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
+	"math"
+	"math/big"
 )
 
 const (
 	EMPTY_SYMBOLS = "                "
+
+	BASE62_ALPHABET = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 )
 
 func EncryptAES256(key []byte, plaintext string) (string, error) {
@@ -91,3 +95,34 @@ func DecryptAES256(key []byte, base64ciphertext string) (string, error) {
 
 	return string(plaintext), nil
 }
+
+// RandomToken returns n random bytes encoded as unpadded URL-safe base64.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// RandomBase62 returns n random bytes encoded with base62 alphabet. The result
+// is padded with zeros to the same length for every value of n bytes.
+func RandomBase62(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	size := int(math.Ceil(float64(n*8) / math.Log2(62)))
+	out := make([]byte, size)
+
+	v := new(big.Int).SetBytes(b)
+	base := big.NewInt(62)
+	mod := new(big.Int)
+	for i := size - 1; i >= 0; i-- {
+		v.DivMod(v, base, mod)
+		out[i] = BASE62_ALPHABET[mod.Int64()]
+	}
+
+	return string(out), nil
+}
@@ -98,3 +98,17 @@ func TestDecryptAES256(t *testing.T) {
 		}
 	}
 }
+
+func TestRandomBase62(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := RandomBase62(16)
+		assert.Nil(t, err)
+		assert.Len(t, id, 22)
+		for _, r := range id {
+			assert.Contains(t, BASE62_ALPHABET, string(r))
+		}
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+}